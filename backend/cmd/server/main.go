@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pcas/dreams-cli/backend/internal/api"
 	"github.com/pcas/dreams-cli/backend/internal/config"
+	"github.com/pcas/dreams-cli/backend/internal/pcas"
 )
 
 func main() {
@@ -22,13 +29,83 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// One shared, long-lived connection pool to PCAS for all handlers
+	pool, err := pcas.NewPool(cfg.PCAS.Address, pcas.PoolOptions{
+		Size:                   cfg.PCAS.Pool.Size,
+		KeepaliveTime:          cfg.PCAS.Pool.KeepaliveTime,
+		KeepaliveTimeout:       cfg.PCAS.Pool.KeepaliveTimeout,
+		KeepaliveWithoutStream: cfg.PCAS.Pool.KeepaliveWithoutStream,
+		Reconnect: pcas.ReconnectPolicy{
+			MaxAttempts:    cfg.PCAS.Reconnect.MaxAttempts,
+			InitialBackoff: cfg.PCAS.Reconnect.InitialBackoff,
+			MaxBackoff:     cfg.PCAS.Reconnect.MaxBackoff,
+			BufferChunks:   cfg.PCAS.Reconnect.BufferChunks,
+		},
+		TLS: pcas.TLSOptions{
+			Enabled:            cfg.PCAS.TLS.Enabled,
+			CAFile:             cfg.PCAS.TLS.CAFile,
+			CertFile:           cfg.PCAS.TLS.CertFile,
+			KeyFile:            cfg.PCAS.TLS.KeyFile,
+			ServerName:         cfg.PCAS.TLS.ServerName,
+			InsecureSkipVerify: cfg.PCAS.TLS.InsecureSkipVerify,
+		},
+		Publish: pcas.PublishOptions{
+			QueueSize:    cfg.PCAS.Publish.QueueSize,
+			MaxBatch:     cfg.PCAS.Publish.MaxBatch,
+			Concurrency:  cfg.PCAS.Publish.Concurrency,
+			Timeout:      cfg.PCAS.Publish.Timeout,
+			DrainTimeout: cfg.PCAS.Publish.DrainTimeout,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to create PCAS pool: %v", err)
+	}
+	defer pool.Close()
+
+	// Memory events go through a durable outbox so PCAS outages do not lose them
+	outbox, err := pcas.OpenOutbox(pcas.OutboxOptions{
+		Dir:            cfg.PCAS.Outbox.Dir,
+		InitialBackoff: cfg.PCAS.Outbox.InitialBackoff,
+		MaxBackoff:     cfg.PCAS.Outbox.MaxBackoff,
+		PublishTimeout: cfg.PCAS.Outbox.PublishTimeout,
+	})
+	if err != nil {
+		// Memory events are still published, but not kept across outages or restarts
+		log.Printf("Memory outbox unavailable, publishing without it: %v", err)
+	} else {
+		pool.AttachOutbox(outbox)
+	}
+
+	// Events PCAS pushes on its own (hints) reach browsers via /api/events/stream
+	if cfg.PCAS.Subscribe.Enabled {
+		pool.AttachSubscriber(pcas.NewSubscriber(pcas.SubscriberOptions{
+			ClientID:       cfg.PCAS.Subscribe.ClientID,
+			InitialBackoff: cfg.PCAS.Subscribe.InitialBackoff,
+			MaxBackoff:     cfg.PCAS.Subscribe.MaxBackoff,
+			Buffer:         cfg.PCAS.Subscribe.Buffer,
+		}))
+	}
+
+	warmCtx, warmCancel := context.WithTimeout(context.Background(), cfg.PCAS.Pool.WarmupTimeout)
+	if err := pool.Warmup(warmCtx); err != nil {
+		// PCAS may come up later; connections keep retrying in the background
+		log.Printf("PCAS warm-up incomplete: %v", err)
+	}
+	warmCancel()
+
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(gin.Logger())
+	// Client addresses (per-user stream limits of anonymous callers) come
+	// from X-Forwarded-For only when the peer is one of these proxies
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid server.trustedProxies: %v", err)
+	}
+	// ?token= never reaches the access log
+	router.Use(api.AccessLogger())
 	router.Use(gin.Recovery())
 
 	// Register API routes first
-	api.RegisterRoutes(router, cfg)
+	routes := api.RegisterRoutes(router, cfg, pool)
 
 	// Serve static files if STATIC_PATH is set
 	staticPath := os.Getenv("STATIC_PATH")
@@ -58,9 +135,29 @@ func main() {
 	}
 
 	addr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
-	fmt.Printf("Backend server started at http://%s\n", addr)
-	
-	if err := router.Run(addr); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{Addr: addr, Handler: router}
+	// Open capability streams end with the server so their SSE readers return
+	srv.RegisterOnShutdown(routes.Close)
+
+	go func() {
+		fmt.Printf("Backend server started at http://%s\n", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	log.Println("Shutting down server...")
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
+	}
+	// Drains queued memory events before the connections go away
+	if err := pool.Close(); err != nil {
+		log.Printf("PCAS pool shutdown error: %v", err)
 	}
 }
@@ -2,9 +2,9 @@ package api
 
 import (
     "net/http"
+    "time"
 
     "github.com/gin-gonic/gin"
-    "github.com/pcas/dreams-cli/backend/internal/pcas"
 )
 
 type addRuleReq struct {
@@ -16,6 +16,8 @@ type addRuleReq struct {
 
 func (h *Handler) registerAdmin(router *gin.Engine) {
     router.POST("/api/admin/policy/add_rule", h.handleAdminAddRule)
+    router.GET("/api/admin/outbox", h.handleAdminOutbox)
+    router.GET("/api/admin/publisher", h.handleAdminPublisher)
 }
 
 func (h *Handler) handleAdminAddRule(c *gin.Context) {
@@ -24,11 +26,7 @@ func (h *Handler) handleAdminAddRule(c *gin.Context) {
         c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid request"}})
         return
     }
-    gw, err := pcas.NewGateway(h.config.PCAS.Address)
-    if err != nil {
-        c.JSON(http.StatusBadGateway, gin.H{"error": gin.H{"message": err.Error()}})
-        return
-    }
+    gw := h.pool.Gateway()
     defer gw.Close()
 
     adminToken := h.config.PCAS.AdminToken
@@ -38,3 +36,25 @@ func (h *Handler) handleAdminAddRule(c *gin.Context) {
     }
     c.JSON(http.StatusOK, gin.H{"ok": true})
 }
+
+// handleAdminOutbox reports how many memory events wait for delivery to PCAS
+// and which one has been waiting longest.
+func (h *Handler) handleAdminOutbox(c *gin.Context) {
+    ob := h.pool.Outbox()
+    if ob == nil {
+        c.JSON(http.StatusOK, gin.H{"enabled": false, "depth": 0})
+        return
+    }
+    st := ob.Stats()
+    resp := gin.H{"enabled": true, "depth": st.Depth}
+    if st.Oldest != nil {
+        resp["oldest"] = st.Oldest
+        resp["oldestAgeMs"] = time.Since(st.Oldest.QueuedAt).Milliseconds()
+    }
+    c.JSON(http.StatusOK, resp)
+}
+
+// handleAdminPublisher reports the memory publish queue metrics.
+func (h *Handler) handleAdminPublisher(c *gin.Context) {
+    c.JSON(http.StatusOK, h.pool.PublishStats())
+}
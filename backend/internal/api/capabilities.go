@@ -1,12 +1,10 @@
 package api
 
 import (
-    "context"
     "encoding/json"
-    "fmt"
     "log"
     "net/http"
-    "sync"
+    "strings"
     "time"
 
     "github.com/gin-gonic/gin"
@@ -15,156 +13,338 @@ import (
     "github.com/pcas/dreams-cli/backend/internal/pcas"
 )
 
-// sessionManager manages in-memory generic streams bridged to PCAS
-type sessionManager struct {
-    mu       sync.RWMutex
-    sessions map[string]*session
-}
-
-type session struct {
-    id      string
-    in      chan []byte
-    out     chan []byte
-    cancel  context.CancelFunc
-    created time.Time
-}
-
-func newSessionManager() *sessionManager {
-    return &sessionManager{sessions: make(map[string]*session)}
-}
-
-func (m *sessionManager) create(id string, s *session) {
-    m.mu.Lock(); defer m.mu.Unlock()
-    m.sessions[id] = s
-}
-
-func (m *sessionManager) get(id string) (*session, bool) {
-    m.mu.RLock(); defer m.mu.RUnlock()
-    s, ok := m.sessions[id]
-    return s, ok
-}
-
-func (m *sessionManager) delete(id string) {
-    m.mu.Lock(); defer m.mu.Unlock()
-    delete(m.sessions, id)
-}
-
 // capabilityHandler wires HTTP routes to PCAS streams via sessionManager
 type capabilityHandler struct {
-    cfg   *config.Config
-    sm    *sessionManager
+    cfg      *config.Config
+    pool     *pcas.Pool
+    sm       *sessionManager
+    history  *chatHistory
+    refs     *refStore
+    identify func(*gin.Context) (identity, error)
 }
 
-func newCapabilityHandler(cfg *config.Config) *capabilityHandler {
-    return &capabilityHandler{cfg: cfg, sm: newSessionManager()}
+func newCapabilityHandler(cfg *config.Config, pool *pcas.Pool, refs *refStore, identify func(*gin.Context) (identity, error)) *capabilityHandler {
+    limits := sessionLimits{
+        IdleTTL:         cfg.Sessions.IdleTTL,
+        MaxLifetime:     cfg.Sessions.MaxLifetime,
+        JanitorInterval: cfg.Sessions.JanitorInterval,
+        CompletedTTL:    cfg.Sessions.CompletedTTL,
+        MaxPerUser:      cfg.Sessions.MaxPerUser,
+        MaxTotal:        cfg.Sessions.MaxTotal,
+        ReplayEvents:    cfg.Sessions.ReplayEvents,
+    }
+    history := newChatHistory(chatLimits{
+        MaxTurns:     cfg.Chat.MaxTurns,
+        MaxTurnChars: cfg.Chat.MaxTurnChars,
+        ContextChars: cfg.Chat.ContextChars,
+        MaxSessions:  cfg.Chat.MaxSessions,
+        TTL:          cfg.Chat.TTL,
+    })
+    return &capabilityHandler{cfg: cfg, pool: pool, sm: newSessionManager(limits), history: history, refs: refs, identify: identify}
 }
 
 func (h *Handler) registerCapabilities(router *gin.Engine) {
-    ch := newCapabilityHandler(h.config)
-
-    router.POST("/api/translate/start", ch.startTranslate)
-    router.GET("/api/translate/stream", ch.streamSSE)
-    router.POST("/api/translate/run", ch.runTranslate)
-
-    router.POST("/api/summarize/start", ch.startSummarize)
-    router.GET("/api/summarize/stream", ch.streamSSE)
-    router.POST("/api/summarize/run", ch.runSummarize)
+    ch := newCapabilityHandler(h.config, h.pool, h.refs, h.resolveIdentity)
+    h.sessions = ch.sm
+
+    // One-shot routes stream the answer in the same response; streaming
+    // capabilities also get start/stream for incremental input.
+    router.GET("/api/capabilities", listCapabilities(h.caps))
+    for _, cp := range h.caps {
+        router.POST(cp.runPath(), ch.run(cp))
+        if cp.Streaming {
+            router.POST("/api/"+cp.Name+"/start", ch.start(cp))
+            router.GET("/api/"+cp.Name+"/stream", ch.streamSSE)
+        }
+        if cp.History {
+            router.GET(cp.historyPath(), ch.listChatHistory)
+            router.GET(cp.historyPath()+"/:sessionId", ch.getChatHistory)
+            router.DELETE(cp.historyPath()+"/:sessionId", ch.clearChatHistory)
+        }
+    }
 
     router.POST("/api/streams/:id/send", ch.sendToStream)
     router.POST("/api/streams/:id/commit", ch.commitStream)
     router.DELETE("/api/streams/:id", ch.closeStream)
+}
 
-    // Chat: one-shot stream, stream response in this request via SSE
-    router.POST("/api/chat", ch.chatOnce)
+type startResp struct {
+    StreamID string `json:"streamId"`
+    // Targets lists the fanout values the stream runs for, in order.
+    Targets []string `json:"targets,omitempty"`
 }
 
-type startReq struct {
-    SessionID string            `json:"sessionId"`
-    TargetLang string           `json:"targetLang"` // translate
-    Mode      string            `json:"mode"`       // summarize: rolling|final
-    Attrs     map[string]string `json:"attrs"`
+// bindCapability decodes the request body and maps it onto stream attributes.
+func bindCapability(c *gin.Context, cp *capability) (map[string]any, map[string]string, bool) {
+    var body map[string]any
+    if err := c.ShouldBindJSON(&body); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid request"}})
+        return nil, nil, false
+    }
+    attrs, err := cp.attributes(body)
+    if bad, ok := err.(errInvalidAttrs); ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": err.Error(), "code": "invalid_attrs", "keys": bad.keys(), "fields": bad}})
+        return nil, nil, false
+    }
+    return body, attrs, true
 }
 
-type startResp struct {
-    StreamID string `json:"streamId"`
+// bindTargets splits the request into its PCAS streams (see capability.fanout).
+func bindTargets(c *gin.Context, cp *capability, body map[string]any, attrs map[string]string) ([]streamTarget, bool) {
+    targets, err := cp.fanout(body, attrs)
+    if bad, ok := err.(errInvalidAttrs); ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": err.Error(), "code": "invalid_attrs", "keys": bad.keys(), "fields": bad}})
+        return nil, false
+    }
+    return targets, true
 }
 
-type runReq struct {
-    SessionID string            `json:"sessionId"`
-    Text      string            `json:"text"`
-    TargetLang string           `json:"targetLang"`
-    Mode      string            `json:"mode"`
-    Attrs     map[string]string `json:"attrs"`
+// start opens a long-lived stream of cp fed via /api/streams/:id/send.
+func (ch *capabilityHandler) start(cp *capability) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        body, attrs, ok := bindCapability(c, cp)
+        if !ok {
+            return
+        }
+        targets, ok := bindTargets(c, cp, body, attrs)
+        if !ok {
+            return
+        }
+        ch.startGeneric(c, cp, body, targets)
+    }
 }
 
-func (ch *capabilityHandler) startTranslate(c *gin.Context) {
-    var req startReq
-    if err := c.ShouldBindJSON(&req); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid request"}})
-        return
+// run sends the request input as the whole stream input and relays the answer.
+// With a sessionId, the message is sent along with resolved refs and earlier
+// turns, and the answer is recorded per the capability's history/output.
+func (ch *capabilityHandler) run(cp *capability) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ident, err := ch.identify(c)
+        if err != nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": gin.H{"message": err.Error()}})
+            return
+        }
+        body, attrs, ok := bindCapability(c, cp)
+        if !ok {
+            return
+        }
+        text := cp.input(body)
+        if text == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid request: " + cp.Input + " is required"}})
+            return
+        }
+        targets, ok := bindTargets(c, cp, body, attrs)
+        if !ok {
+            return
+        }
+        refs, ok := bindRefs(c, cp, body)
+        if !ok {
+            return
+        }
+        rag, ok := ch.bindRAG(c, cp, body)
+        if !ok {
+            return
+        }
+        // memories and refs are read as ident.UserID, which only a token vouches for
+        if (rag != nil || len(refs) > 0) && !ident.Authenticated {
+            authRequired(c)
+            return
+        }
+        log.Printf("[%s] session=%s bytes=%d refs=%d rag=%t", cp.Name, attrs["session_id"], len(text), len(refs), rag != nil)
+        sessionID, _ := body["sessionId"].(string)
+        var hooks streamHooks
+        if rag == nil && (sessionID == "" || !(cp.History || cp.Refs || cp.Output != "")) {
+            ch.streamOnce(c, cp.EventType, targets, text, hooks)
+            return
+        }
+
+        var sections []string
+        cited := sseCitationsData{Citations: []citation{}}
+        if rag != nil {
+            memories, err := ch.searchMemories(c.Request.Context(), ident.UserID, text, rag)
+            if err != nil {
+                // answer without memories rather than failing the chat
+                log.Printf("[%s] memory search failed: %v", cp.Name, err)
+                cited.Warning = "memory search unavailable"
+            }
+            if memText, cites := renderRefs(memories, 0, 1); memText != "" {
+                sections = append(sections, "Memories from earlier sessions (cite as [n])\n"+memText)
+                cited.Citations = append(cited.Citations, cites...)
+            }
+        }
+        if len(refs) > 0 {
+            items, bad := resolveRefs(ch.refs.items(ident.UserID, sessionID), refs, time.Now())
+            if len(bad) > 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid refs: " + strings.Join(bad.keys(), ", "), "code": "invalid_refs", "keys": bad.keys(), "fields": bad}})
+                return
+            }
+            if refsText, cites := renderRefs(items, ch.cfg.Chat.RefsChars, len(cited.Citations)+1); refsText != "" {
+                sections = append(sections, "References (cite as [n])\n"+refsText)
+                cited.Citations = append(cited.Citations, cites...)
+            }
+        }
+        if len(cited.Citations) > 0 || cited.Warning != "" {
+            hooks.lead = append(hooks.lead, leadEvent{sseCitations, cited})
+        }
+        if sessionID == "" {
+            ch.streamOnce(c, cp.EventType, targets, withSections(sections, text), hooks)
+            return
+        }
+        if cp.History {
+            // Send earlier turns ahead of the message and record the exchange
+            // once the answer completed. History is kept per user, which only
+            // a token vouches for; anonymous callers get it under a key the
+            // server issued them instead.
+            owner := ident.UserID
+            if !ident.Authenticated {
+                owner = anonymousHistoryOwner(c)
+            }
+            if history := ch.history.context(owner, sessionID); history != "" {
+                sections = append(sections, "Context\n"+history)
+            }
+            asked := time.Now()
+            hooks.observe = append(hooks.observe, collectAnswer(func(_, _, answer string) {
+                ch.history.append(owner, sessionID,
+                    chatTurn{Role: "user", Content: text, At: asked},
+                    chatTurn{Role: "assistant", Content: answer, At: time.Now()})
+            }))
+        }
+        if cp.Output != "" && ident.Authenticated {
+            hooks.observe = append(hooks.observe, collectAnswer(func(streamID, _, answer string) {
+                ch.refs.record(ident.UserID, sessionID, refItem{Kind: cp.Output, ID: streamID, Text: answer})
+            }))
+        }
+        ch.streamOnce(c, cp.EventType, targets, withSections(sections, text), hooks)
     }
-    attrs := map[string]string{}
-    if req.TargetLang != "" { attrs["target_lang"] = req.TargetLang }
-    for k, v := range req.Attrs { attrs[k] = v }
-    // Provide sensible defaults so testers don't have to hand-write attrs JSON
-    if _, ok := attrs["system"]; !ok {
-        attrs["system"] = "You are a translator. Translate all user input to English."
+}
+
+// historyKeyHeader carries the key an anonymous caller's chat history is
+// filed under: the server issues it in the response and the client sends it
+// back with its next message.
+const historyKeyHeader = "X-History-Key"
+
+// anonymousHistoryOwner returns the history owner of an anonymous caller,
+// issuing a new key unless the request brings back one the server could
+// have issued. The key is random, so knowing a sessionId is not enough to
+// read someone else's turns.
+func anonymousHistoryOwner(c *gin.Context) string {
+    key := c.GetHeader(historyKeyHeader)
+    if _, err := uuid.Parse(key); err != nil || len(key) != 36 {
+        key = uuid.New().String()
     }
-    if _, ok := attrs["model"]; !ok {
-        attrs["model"] = "gpt-5-mini"
+    c.Header(historyKeyHeader, key)
+    return "anon:" + key
+}
+
+// withSections prefixes the user's message with context sections.
+func withSections(sections []string, text string) string {
+    if len(sections) == 0 {
+        return text
     }
-    ch.startGeneric(c, ch.cfg.PCAS.TranslateEventType, attrs)
+    return strings.Join(sections, "\n\n") + "\n\nUser: " + text
 }
 
-func (ch *capabilityHandler) startSummarize(c *gin.Context) {
-    var req startReq
-    if err := c.ShouldBindJSON(&req); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid request"}})
-        return
+// bindRefs decodes the refs of a request; they need a sessionId to resolve against.
+func bindRefs(c *gin.Context, cp *capability, body map[string]any) ([]chatRef, bool) {
+    raw, ok := body["refs"]
+    if !ok || raw == nil || !cp.Refs {
+        return nil, true
     }
-    attrs := map[string]string{}
-    if req.Mode != "" { attrs["mode"] = req.Mode }
-    for k, v := range req.Attrs { attrs[k] = v }
-    if _, ok := attrs["system"]; !ok {
-        attrs["system"] = "Summarize the user input in 3 concise bullet points."
+    var refs []chatRef
+    b, _ := json.Marshal(raw)
+    if err := json.Unmarshal(b, &refs); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid refs: " + err.Error(), "code": "invalid_refs"}})
+        return nil, false
     }
-    if _, ok := attrs["model"]; !ok {
-        attrs["model"] = "gpt-5-mini"
+    if sessionID, _ := body["sessionId"].(string); len(refs) > 0 && sessionID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid refs: sessionId is required", "code": "invalid_refs"}})
+        return nil, false
+    }
+    return refs, true
+}
+
+// collectAnswer returns an observer that gathers the delta text of each
+// stream and calls done with its PCAS stream id, fanout target and the full
+// answer once it completed.
+func collectAnswer(done func(streamID, target, answer string)) func(string, any) {
+    streamIDs := map[string]string{}
+    answers := map[string]*strings.Builder{}
+    return func(event string, data any) {
+        switch event {
+        case sseReady:
+            d := data.(sseReadyData)
+            streamIDs[d.Target] = d.StreamID
+            answers[d.Target] = &strings.Builder{}
+        case sseDelta:
+            d := data.(sseDeltaData)
+            if b := answers[d.Target]; b != nil {
+                b.WriteString(d.Text)
+            }
+        case sseDone:
+            d := data.(sseDoneData)
+            if b := answers[d.Target]; b != nil {
+                done(streamIDs[d.Target], d.Target, b.String())
+            }
+        }
     }
-    ch.startGeneric(c, ch.cfg.PCAS.SummarizeEventType, attrs)
 }
 
-func (ch *capabilityHandler) startGeneric(c *gin.Context, eventType string, attrs map[string]string) {
-    id := uuid.New().String()
-    in := make(chan []byte, 16)
-    out := make(chan []byte, 16)
-    // Use a background context so the stream outlives this HTTP request.
-    // The lifecycle is controlled by commit/close.
-    ctx, cancel := context.WithCancel(context.Background())
+func (ch *capabilityHandler) startGeneric(c *gin.Context, cp *capability, body map[string]any, targets []streamTarget) {
+    ident, err := ch.identify(c)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": gin.H{"message": err.Error()}})
+        return
+    }
+    // The session context outlives this HTTP request; its lifecycle is
+    // controlled by commit/close, the SSE reader and the janitor.
+    s := ch.sm.newSession(uuid.New().String(), ident.UserID, sessionQuotaKey(c, ident))
+    if err := ch.sm.create(s); err != nil {
+        s.cancel(err)
+        c.JSON(http.StatusTooManyRequests, gin.H{"error": gin.H{"message": err.Error(), "code": "session_limit"}})
+        return
+    }
 
     // bridge to PCAS in background
-    go func() {
-        gw, err := pcas.NewGateway(ch.cfg.PCAS.Address)
-        if err != nil {
-            log.Printf("gateway error: %v", err)
-            close(out)
-            return
-        }
-        defer gw.Close()
-        log.Printf("[stream-start] id=%s eventType=%s attrs=%v", id, eventType, attrs)
-        if err := gw.StartGenericStream(ctx, eventType, attrs, in, out); err != nil {
-            log.Printf("pcas stream error: %v", err)
-            // surface immediate startup error to client and close
-            select { case out <- []byte(fmt.Sprintf("{\"error\":%q}", err.Error())): default: }
-            close(out)
+    resp := startResp{StreamID: s.id}
+    for _, t := range targets {
+        log.Printf("[stream-start] id=%s user=%s eventType=%s target=%s attrs=%v", s.id, s.userID, cp.EventType, t.name, t.attrs)
+        if t.name != "" {
+            resp.Targets = append(resp.Targets, t.name)
         }
+    }
+    record := recordTo(s.replay)
+    // outputs are recorded under the id the client knows the stream by,
+    // <id>/<target> for each stream of a fanout; like history, only for
+    // token-authenticated users
+    var observe func(string, any)
+    if sessionID, _ := body["sessionId"].(string); cp.Output != "" && sessionID != "" && ident.Authenticated {
+        observe = collectAnswer(func(_, target, answer string) {
+            id := s.id
+            if target != "" {
+                id += "/" + target
+            }
+            ch.refs.record(ident.UserID, sessionID, refItem{Kind: cp.Output, ID: id, Text: answer})
+        })
+    }
+    go func() {
+        runTargets(s.ctx, ch.pool, cp.EventType, targets, s.in, func(event string, data any) {
+            if observe != nil {
+                observe(event, data)
+            }
+            record(event, data)
+            s.touch()
+        })
+        s.replay.close()
+        ch.sm.finish(s)
     }()
 
-    ch.sm.create(id, &session{id: id, in: in, out: out, cancel: cancel, created: time.Now()})
-    c.JSON(http.StatusOK, &startResp{StreamID: id})
+    c.JSON(http.StatusOK, &resp)
 }
 
-// SSE stream for either translate or summarize
+// SSE stream for either translate or summarize. Every subscriber gets all
+// events; a reconnecting EventSource resumes after its Last-Event-ID. A
+// finished session stays readable until the janitor drops it after
+// CompletedTTL, so every tab and late reconnect still gets the tail.
 func (ch *capabilityHandler) streamSSE(c *gin.Context) {
     id := c.Query("streamId")
     s, ok := ch.sm.get(id)
@@ -172,32 +352,11 @@ func (ch *capabilityHandler) streamSSE(c *gin.Context) {
         c.JSON(http.StatusNotFound, gin.H{"error": gin.H{"message": "stream not found"}})
         return
     }
-    w := c.Writer
-    c.Header("Content-Type", "text/event-stream")
-    c.Header("Cache-Control", "no-cache")
-    c.Header("Connection", "keep-alive")
-    c.Header("X-Accel-Buffering", "no")
-
-    // Initial comment to open stream
-    _, _ = w.Write([]byte(":ok\n\n"))
-    w.Flush()
-
-    notify := c.Request.Context().Done()
-    for {
-        select {
-        case b, ok := <-s.out:
-            if !ok {
-                return
-            }
-            payload, _ := json.Marshal(gin.H{"text": string(b)})
-            _, _ = w.Write([]byte("data: "))
-            _, _ = w.Write(payload)
-            _, _ = w.Write([]byte("\n\n"))
-            w.Flush()
-        case <-notify:
-            return
-        }
-    }
+    detach := s.subscribe()
+    defer detach()
+
+    startSSE(c)
+    followSSE(c, s.replay, lastEventID(c), ch.cfg.Server.SSEHeartbeat)
 }
 
 type sendReq struct {
@@ -221,13 +380,10 @@ func (ch *capabilityHandler) sendToStream(c *gin.Context) {
         if len(clip) > 120 { clip = clip[:120] + "..." }
         log.Printf("[stream-send] id=%s bytes=%d preview=%q", id, len(req.Text), clip)
     }
-    // Guard against sending after commit (channel closed)
-    defer func() {
-        if r := recover(); r != nil {
-            c.JSON(http.StatusConflict, gin.H{"error": gin.H{"message": "stream already committed/closed"}})
-        }
-    }()
-    s.in <- []byte(req.Text)
+    if err := s.send(c.Request.Context(), []byte(req.Text)); err != nil {
+        c.JSON(http.StatusConflict, gin.H{"error": gin.H{"message": err.Error()}})
+        return
+    }
     c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
@@ -240,8 +396,7 @@ func (ch *capabilityHandler) commitStream(c *gin.Context) {
         return
     }
     // idempotent close
-    defer func() { recover() }()
-    close(s.in)
+    s.closeInput()
     log.Printf("[stream-commit] id=%s", id)
     c.JSON(http.StatusOK, gin.H{"ok": true})
 }
@@ -253,194 +408,54 @@ func (ch *capabilityHandler) closeStream(c *gin.Context) {
         c.JSON(http.StatusNotFound, gin.H{"error": gin.H{"message": "stream not found"}})
         return
     }
-    s.cancel()
-    close(s.in)
-    ch.sm.delete(id)
+    ch.sm.remove(s.id, "closed")
     c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
-// Chat: one-shot request that streams AI response over SSE in the same HTTP response
-type chatReq struct {
-    SessionID string            `json:"sessionId"`
-    Message   string            `json:"message"`
-    Refs      []map[string]any  `json:"refs"`
-    Attrs     map[string]string `json:"attrs"`
+// leadEvent is sent ahead of a one-shot stream's own events.
+type leadEvent struct {
+    event string
+    data  any
 }
 
-func (ch *capabilityHandler) chatOnce(c *gin.Context) {
-    var req chatReq
-    if err := c.ShouldBindJSON(&req); err != nil || req.Message == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid request"}})
-        return
-    }
-
-    w := c.Writer
-    c.Header("Content-Type", "text/event-stream")
-    c.Header("Cache-Control", "no-cache")
-    c.Header("Connection", "keep-alive")
-    c.Header("X-Accel-Buffering", "no")
-
-    _, _ = w.Write([]byte(":ok\n\n"))
-    w.Flush()
-
-    in := make(chan []byte, 4)
-    out := make(chan []byte, 16)
-    ctx, cancel := context.WithCancel(c.Request.Context())
-    defer cancel()
-
-    go func() {
-        gw, err := pcas.NewGateway(ch.cfg.PCAS.Address)
-        if err != nil {
-            log.Printf("gateway error: %v", err)
-            close(out)
-            return
-        }
-        defer gw.Close()
-        attrs := map[string]string{"session_id": req.SessionID}
-        for k, v := range req.Attrs { attrs[k] = v }
-        if _, ok := attrs["system"]; !ok {
-            attrs["system"] = "You are a helpful assistant."
-        }
-        if _, ok := attrs["model"]; !ok {
-            attrs["model"] = "gpt-5"
-        }
-        if err := gw.StartGenericStream(ctx, ch.cfg.PCAS.ChatEventType, attrs, in, out); err != nil {
-            log.Printf("pcas chat error: %v", err)
-            select { case out <- []byte(fmt.Sprintf("{\"error\":%q}", err.Error())): default: }
-            close(out)
-        }
-    }()
-
-    // send raw message bytes then close input (provider aggregates raw prompt and starts after ClientEnd)
-    log.Printf("[chat] session=%s bytes=%d", req.SessionID, len(req.Message))
-    in <- []byte(req.Message)
-    close(in)
-
-    notify := c.Request.Context().Done()
-    for {
-        select {
-        case b, ok := <-out:
-            if !ok { return }
-            payload, _ := json.Marshal(gin.H{"text": string(b)})
-            _, _ = w.Write([]byte("data: "))
-            _, _ = w.Write(payload)
-            _, _ = w.Write([]byte("\n\n"))
-            w.Flush()
-        case <-notify:
-            return
-        }
-    }
+// streamHooks customise a one-shot stream: lead events go out first and every
+// observer sees each stream event before it is sent.
+type streamHooks struct {
+    lead    []leadEvent
+    observe []func(event string, data any)
 }
 
-// runTranslate: one-shot SSE; BFF sends text then ClientEnd automatically
-func (ch *capabilityHandler) runTranslate(c *gin.Context) {
-    var req runReq
-    if err := c.ShouldBindJSON(&req); err != nil || req.Text == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid request"}})
-        return
-    }
-
-    w := c.Writer
-    c.Header("Content-Type", "text/event-stream")
-    c.Header("Cache-Control", "no-cache")
-    c.Header("Connection", "keep-alive")
-    c.Header("X-Accel-Buffering", "no")
-    _, _ = w.Write([]byte(":ok\n\n"))
-    w.Flush()
-
-    in := make(chan []byte, 4)
-    out := make(chan []byte, 16)
-    ctx, cancel := context.WithCancel(context.Background())
-    defer cancel()
-
-    go func() {
-        gw, err := pcas.NewGateway(ch.cfg.PCAS.Address)
-        if err != nil { log.Printf("gateway error: %v", err); close(out); return }
-        defer gw.Close()
-        attrs := map[string]string{}
-        if req.TargetLang != "" { attrs["target_lang"] = req.TargetLang }
-        for k, v := range req.Attrs { attrs[k] = v }
-        if _, ok := attrs["system"]; !ok { attrs["system"] = "You are a translator. Translate all user input to English." }
-        if _, ok := attrs["model"]; !ok { attrs["model"] = "gpt-5-mini" }
-        if err := gw.StartGenericStream(ctx, ch.cfg.PCAS.TranslateEventType, attrs, in, out); err != nil {
-            log.Printf("pcas translate error: %v", err)
-            select { case out <- []byte(fmt.Sprintf("{\"error\":%q}", err.Error())): default: }
-            close(out)
-        }
-    }()
-
-    // send input once then commit
-    in <- []byte(req.Text)
+// streamOnce sends text as the whole input of the PCAS stream of each target
+// and relays the typed events of the answers over SSE in this response. The
+// events are written as they come, holding up the streams while the client
+// reads slowly so none is lost; the streams are cancelled when it goes away.
+func (ch *capabilityHandler) streamOnce(c *gin.Context, eventType string, targets []streamTarget, text string, hooks streamHooks) {
+    in := make(chan []byte, 1)
+    in <- []byte(text)
     close(in)
 
-    notify := c.Request.Context().Done()
-    for {
+    ctx := c.Request.Context()
+    events := make(chan sseEvent)
+    send := func(event string, data any) {
+        b, _ := json.Marshal(data)
         select {
-        case b, ok := <-out:
-            if !ok { return }
-            payload, _ := json.Marshal(gin.H{"text": string(b)})
-            _, _ = w.Write([]byte("data: "))
-            _, _ = w.Write(payload)
-            _, _ = w.Write([]byte("\n\n"))
-            w.Flush()
-        case <-notify:
-            return
+        case events <- sseEvent{Event: event, Data: b}:
+        case <-ctx.Done():
         }
     }
-}
-
-// runSummarize: one-shot SSE; BFF sends text then ClientEnd automatically
-func (ch *capabilityHandler) runSummarize(c *gin.Context) {
-    var req runReq
-    if err := c.ShouldBindJSON(&req); err != nil || req.Text == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid request"}})
-        return
-    }
-
-    w := c.Writer
-    c.Header("Content-Type", "text/event-stream")
-    c.Header("Cache-Control", "no-cache")
-    c.Header("Connection", "keep-alive")
-    c.Header("X-Accel-Buffering", "no")
-    _, _ = w.Write([]byte(":ok\n\n"))
-    w.Flush()
-
-    in := make(chan []byte, 4)
-    out := make(chan []byte, 16)
-    ctx, cancel := context.WithCancel(context.Background())
-    defer cancel()
-
     go func() {
-        gw, err := pcas.NewGateway(ch.cfg.PCAS.Address)
-        if err != nil { log.Printf("gateway error: %v", err); close(out); return }
-        defer gw.Close()
-        attrs := map[string]string{}
-        if req.Mode != "" { attrs["mode"] = req.Mode }
-        for k, v := range req.Attrs { attrs[k] = v }
-        if _, ok := attrs["system"]; !ok { attrs["system"] = "Summarize the user input in 3 concise bullet points." }
-        if _, ok := attrs["model"]; !ok { attrs["model"] = "gpt-5-mini" }
-        if err := gw.StartGenericStream(ctx, ch.cfg.PCAS.SummarizeEventType, attrs, in, out); err != nil {
-            log.Printf("pcas summarize error: %v", err)
-            select { case out <- []byte(fmt.Sprintf("{\"error\":%q}", err.Error())): default: }
-            close(out)
+        defer close(events)
+        for _, ev := range hooks.lead {
+            send(ev.event, ev.data)
         }
+        runTargets(ctx, ch.pool, eventType, targets, in, func(event string, data any) {
+            for _, observe := range hooks.observe {
+                observe(event, data)
+            }
+            send(event, data)
+        })
     }()
 
-    in <- []byte(req.Text)
-    close(in)
-
-    notify := c.Request.Context().Done()
-    for {
-        select {
-        case b, ok := <-out:
-            if !ok { return }
-            payload, _ := json.Marshal(gin.H{"text": string(b)})
-            _, _ = w.Write([]byte("data: "))
-            _, _ = w.Write(payload)
-            _, _ = w.Write([]byte("\n\n"))
-            w.Flush()
-        case <-notify:
-            return
-        }
-    }
+    startSSE(c)
+    writeSSE(c, events, ch.cfg.Server.SSEHeartbeat)
 }
@@ -0,0 +1,181 @@
+package api
+
+import (
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+    "google.golang.org/grpc"
+)
+
+func TestRunRequiresToken(t *testing.T) {
+    cfg := &config.Config{}
+    cfg.User.ID = "default-user"
+    cfg.User.RequireToken = true
+    cfg.User.Tokens = []config.UserToken{{Token: "alice-token", ID: "alice"}}
+    h := &Handler{config: cfg}
+    // no pool: a request that got past identity would fail to open a stream
+    ch := newCapabilityHandler(cfg, nil, nil, h.resolveIdentity)
+
+    router := gin.New()
+    for _, cc := range []config.CapabilityConfig{
+        {Name: "translate", Input: "text"},
+        {Name: "summarize", Input: "text"},
+        {Name: "chat", Input: "message", Path: "/api/chat"},
+    } {
+        cp := newCapability(cc)
+        router.POST(cp.runPath(), ch.run(cp))
+    }
+
+    cases := []struct {
+        name   string
+        path   string
+        body   string
+        header string
+        value  string
+    }{
+        {"translate anonymous", "/api/translate/run", `{"text":"hello"}`, "", ""},
+        {"summarize anonymous", "/api/summarize/run", `{"text":"hello"}`, "", ""},
+        {"chat anonymous", "/api/chat", `{"message":"hello"}`, "", ""},
+        {"translate as X-User-ID", "/api/translate/run", `{"text":"hello"}`, "X-User-ID", "alice"},
+        {"translate with unknown token", "/api/translate/run", `{"text":"hello"}`, "Authorization", "Bearer nope"},
+        {"invalid body still 401", "/api/translate/run", `{}`, "", ""},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            w := httptest.NewRecorder()
+            req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+            req.Header.Set("Content-Type", "application/json")
+            if tc.header != "" {
+                req.Header.Set(tc.header, tc.value)
+            }
+            router.ServeHTTP(w, req)
+            if w.Code != http.StatusUnauthorized {
+                t.Fatalf("status = %d, want 401: %s", w.Code, w.Body.String())
+            }
+        })
+    }
+}
+
+// echoInteractServer answers every interact stream with its whole input as
+// one data response once the client ended it. Streams whose config fail
+// matches get a StreamError instead.
+type echoInteractServer struct {
+    busv1.UnimplementedEventBusServiceServer
+    fail func(*busv1.StreamConfig) bool
+    // delay holds every answer back, like a model taking its time.
+    delay time.Duration
+
+    mu      sync.Mutex
+    configs []*busv1.StreamConfig
+    inputs  []string
+}
+
+func (f *echoInteractServer) InteractStream(stream busv1.EventBusService_InteractStreamServer) error {
+    req, err := stream.Recv()
+    if err != nil {
+        return err
+    }
+    cfg := req.GetConfig()
+    f.mu.Lock()
+    f.configs = append(f.configs, cfg)
+    f.mu.Unlock()
+    ready := &busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Ready{Ready: &busv1.StreamReady{StreamId: "pcas-" + cfg.GetAttributes()["target_lang"]}}}
+    if err := stream.Send(ready); err != nil {
+        return err
+    }
+    var input strings.Builder
+    for {
+        req, err := stream.Recv()
+        if err != nil {
+            return err
+        }
+        if req.GetClientEnd() != nil {
+            break
+        }
+        input.Write(req.GetData().GetContent())
+    }
+    time.Sleep(f.delay)
+    if f.fail != nil && f.fail(cfg) {
+        return stream.Send(&busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Error{Error: &busv1.StreamError{Code: 500, Message: "boom"}}})
+    }
+    f.mu.Lock()
+    f.inputs = append(f.inputs, input.String())
+    f.mu.Unlock()
+    data := &busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Data{Data: &busv1.StreamData{Content: []byte(input.String())}}}
+    if err := stream.Send(data); err != nil {
+        return err
+    }
+    return stream.Send(&busv1.InteractResponse{ResponseType: &busv1.InteractResponse_ServerEnd{ServerEnd: &busv1.StreamEnd{}}})
+}
+
+// lastInput returns the input of the most recently ended stream.
+func (f *echoInteractServer) lastInput(t *testing.T) string {
+    t.Helper()
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if len(f.inputs) == 0 {
+        t.Fatal("no interact stream ended")
+    }
+    return f.inputs[len(f.inputs)-1]
+}
+
+func echoPool(t *testing.T, f *echoInteractServer) *pcas.Pool {
+    t.Helper()
+    return servePool(t, f)
+}
+
+// servePool serves f on a local port and returns a Pool dialing it.
+func servePool(t *testing.T, f busv1.EventBusServiceServer) *pcas.Pool {
+    t.Helper()
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := grpc.NewServer()
+    busv1.RegisterEventBusServiceServer(srv, f)
+    go func() { _ = srv.Serve(lis) }()
+    t.Cleanup(srv.Stop)
+    pool, err := pcas.NewPool(lis.Addr().String(), pcas.PoolOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { _ = pool.Close() })
+    return pool
+}
+
+// postSSE posts body to path and returns the status and the SSE events of the response.
+func postSSE(t *testing.T, router *gin.Engine, path, body string, header ...string) (int, []sseEvent) {
+    t.Helper()
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    for i := 0; i+1 < len(header); i += 2 {
+        req.Header.Set(header[i], header[i+1])
+    }
+    router.ServeHTTP(w, req)
+    var events []sseEvent
+    for _, block := range strings.Split(w.Body.String(), "\n\n") {
+        var ev sseEvent
+        for _, line := range strings.Split(block, "\n") {
+            if v, ok := strings.CutPrefix(line, "event: "); ok {
+                ev.Event = v
+            }
+            if v, ok := strings.CutPrefix(line, "data: "); ok {
+                ev.Data = []byte(v)
+            }
+        }
+        if ev.Event != "" {
+            events = append(events, ev)
+        }
+    }
+    return w.Code, events
+}
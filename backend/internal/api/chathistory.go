@@ -0,0 +1,235 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
+
+    "github.com/gin-gonic/gin"
+)
+
+// chatLimits bounds the server-side chat history. Zero fields disable the
+// matching limit.
+type chatLimits struct {
+    MaxTurns     int
+    MaxTurnChars int
+    ContextChars int
+    MaxSessions  int
+    TTL          time.Duration
+}
+
+type chatTurn struct {
+    Role    string    `json:"role"` // user|assistant
+    Content string    `json:"content"`
+    At      time.Time `json:"at"`
+}
+
+type chatSession struct {
+    userID    string
+    sessionID string
+    turns     []chatTurn
+    // dropped counts turns evicted by MaxTurns.
+    dropped int
+    updated time.Time
+}
+
+// chatHistory keeps the conversation of each (user, sessionId) so chat
+// streams can be sent with their earlier turns.
+type chatHistory struct {
+    limits   chatLimits
+    mu       sync.Mutex
+    sessions map[string]*chatSession
+}
+
+func newChatHistory(limits chatLimits) *chatHistory {
+    return &chatHistory{limits: limits, sessions: make(map[string]*chatSession)}
+}
+
+func chatKey(userID, sessionID string) string {
+    return userID + "\x00" + sessionID
+}
+
+// expired reports whether s outlived the TTL; callers hold mu.
+func (h *chatHistory) expired(s *chatSession, now time.Time) bool {
+    return h.limits.TTL > 0 && now.Sub(s.updated) > h.limits.TTL
+}
+
+// lookup returns the live session or nil, dropping it when expired.
+func (h *chatHistory) lookup(userID, sessionID string, now time.Time) *chatSession {
+    key := chatKey(userID, sessionID)
+    s, ok := h.sessions[key]
+    if !ok {
+        return nil
+    }
+    if h.expired(s, now) {
+        delete(h.sessions, key)
+        return nil
+    }
+    return s
+}
+
+// append records one exchange, trimming the session to MaxTurns and the
+// store to MaxSessions (least recently updated first).
+func (h *chatHistory) append(userID, sessionID string, turns ...chatTurn) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    now := time.Now()
+    s := h.lookup(userID, sessionID, now)
+    if s == nil {
+        s = &chatSession{userID: userID, sessionID: sessionID}
+        h.sessions[chatKey(userID, sessionID)] = s
+    }
+    for _, t := range turns {
+        if h.limits.MaxTurnChars > 0 && utf8.RuneCountInString(t.Content) > h.limits.MaxTurnChars {
+            t.Content = string([]rune(t.Content)[:h.limits.MaxTurnChars]) + "…"
+        }
+        s.turns = append(s.turns, t)
+    }
+    if n := len(s.turns) - h.limits.MaxTurns; h.limits.MaxTurns > 0 && n > 0 {
+        s.turns = append(s.turns[:0:0], s.turns[n:]...)
+        s.dropped += n
+    }
+    s.updated = now
+
+    if h.limits.MaxSessions > 0 && len(h.sessions) > h.limits.MaxSessions {
+        var oldest string
+        var at time.Time
+        for k, v := range h.sessions {
+            if oldest == "" || v.updated.Before(at) {
+                oldest, at = k, v.updated
+            }
+        }
+        delete(h.sessions, oldest)
+    }
+}
+
+// context renders the most recent turns that fit ContextChars, newest kept
+// whole when possible; older turns are truncated or omitted.
+func (h *chatHistory) context(userID, sessionID string) string {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    s := h.lookup(userID, sessionID, time.Now())
+    if s == nil || len(s.turns) == 0 {
+        return ""
+    }
+    budget := h.limits.ContextChars
+    var picked []string
+    i := len(s.turns) - 1
+    for ; i >= 0; i-- {
+        line := chatRoleLabel(s.turns[i].Role) + ": " + s.turns[i].Content
+        n := utf8.RuneCountInString(line)
+        if h.limits.ContextChars <= 0 || n <= budget {
+            picked = append(picked, line)
+            budget -= n + 1
+            continue
+        }
+        // keep the tail of a turn that only partly fits, unless it would be a fragment
+        if budget > 20 {
+            r := []rune(line)
+            picked = append(picked, "…"+string(r[len(r)-budget+1:]))
+            i--
+        }
+        break
+    }
+    if omitted := s.dropped + i + 1; omitted > 0 {
+        picked = append(picked, fmt.Sprintf("(%d earlier turns omitted)", omitted))
+    }
+    for l, r := 0, len(picked)-1; l < r; l, r = l+1, r-1 {
+        picked[l], picked[r] = picked[r], picked[l]
+    }
+    return strings.Join(picked, "\n")
+}
+
+func chatRoleLabel(role string) string {
+    if role == "assistant" {
+        return "Assistant"
+    }
+    return "User"
+}
+
+type chatSessionInfo struct {
+    SessionID string    `json:"sessionId"`
+    Turns     int       `json:"turns"`
+    Dropped   int       `json:"dropped"`
+    UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// list returns the sessions of userID, most recently updated first.
+func (h *chatHistory) list(userID string) []chatSessionInfo {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    now := time.Now()
+    out := []chatSessionInfo{}
+    for k, s := range h.sessions {
+        if s.userID != userID {
+            continue
+        }
+        if h.expired(s, now) {
+            delete(h.sessions, k)
+            continue
+        }
+        out = append(out, chatSessionInfo{SessionID: s.sessionID, Turns: len(s.turns), Dropped: s.dropped, UpdatedAt: s.updated})
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+    return out
+}
+
+func (h *chatHistory) get(userID, sessionID string) (chatSessionInfo, []chatTurn, bool) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    s := h.lookup(userID, sessionID, time.Now())
+    if s == nil {
+        return chatSessionInfo{}, nil, false
+    }
+    turns := append([]chatTurn(nil), s.turns...)
+    return chatSessionInfo{SessionID: s.sessionID, Turns: len(s.turns), Dropped: s.dropped, UpdatedAt: s.updated}, turns, true
+}
+
+func (h *chatHistory) clear(userID, sessionID string) bool {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    key := chatKey(userID, sessionID)
+    _, ok := h.sessions[key]
+    delete(h.sessions, key)
+    return ok
+}
+
+// History endpoints are scoped to the caller's identity, which must come
+// from an auth token.
+
+func (ch *capabilityHandler) listChatHistory(c *gin.Context) {
+    ident, ok := requireToken(c, ch.identify)
+    if !ok {
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"sessions": ch.history.list(ident.UserID)})
+}
+
+func (ch *capabilityHandler) getChatHistory(c *gin.Context) {
+    ident, ok := requireToken(c, ch.identify)
+    if !ok {
+        return
+    }
+    info, turns, ok := ch.history.get(ident.UserID, c.Param("sessionId"))
+    if !ok {
+        c.JSON(http.StatusNotFound, gin.H{"error": gin.H{"message": "chat session not found"}})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"session": info, "turns": turns})
+}
+
+func (ch *capabilityHandler) clearChatHistory(c *gin.Context) {
+    ident, ok := requireToken(c, ch.identify)
+    if !ok {
+        return
+    }
+    if !ch.history.clear(ident.UserID, c.Param("sessionId")) {
+        c.JSON(http.StatusNotFound, gin.H{"error": gin.H{"message": "chat session not found"}})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"ok": true})
+}
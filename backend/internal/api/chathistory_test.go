@@ -0,0 +1,213 @@
+package api
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+)
+
+func TestChatHistoryRequiresToken(t *testing.T) {
+    cfg := &config.Config{}
+    cfg.User.ID = "default-user"
+    cfg.User.Tokens = []config.UserToken{{Token: "alice-token", ID: "alice"}}
+    h := &Handler{config: cfg}
+    ch := newCapabilityHandler(cfg, nil, nil, h.resolveIdentity)
+    ch.history.append("alice", "s1", chatTurn{Role: "user", Content: "secret", At: time.Now()})
+
+    router := gin.New()
+    router.GET("/api/chat/history", ch.listChatHistory)
+    router.GET("/api/chat/history/:sessionId", ch.getChatHistory)
+    router.DELETE("/api/chat/history/:sessionId", ch.clearChatHistory)
+
+    cases := []struct {
+        name   string
+        method string
+        path   string
+        header string
+        value  string
+        want   int
+    }{
+        {"get as X-User-ID", http.MethodGet, "/api/chat/history/s1", "X-User-ID", "alice", http.StatusForbidden},
+        {"get as userId", http.MethodGet, "/api/chat/history/s1?userId=alice", "", "", http.StatusForbidden},
+        {"list as X-User-ID", http.MethodGet, "/api/chat/history", "X-User-ID", "alice", http.StatusForbidden},
+        {"delete as X-User-ID", http.MethodDelete, "/api/chat/history/s1", "X-User-ID", "alice", http.StatusForbidden},
+        {"get with unknown token", http.MethodGet, "/api/chat/history/s1", "Authorization", "Bearer nope", http.StatusUnauthorized},
+        {"get with token", http.MethodGet, "/api/chat/history/s1", "Authorization", "Bearer alice-token", http.StatusOK},
+        {"delete with token", http.MethodDelete, "/api/chat/history/s1", "Authorization", "Bearer alice-token", http.StatusOK},
+        {"deleted", http.MethodGet, "/api/chat/history/s1", "Authorization", "Bearer alice-token", http.StatusNotFound},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            w := httptest.NewRecorder()
+            req := httptest.NewRequest(tc.method, tc.path, nil)
+            if tc.header != "" {
+                req.Header.Set(tc.header, tc.value)
+            }
+            router.ServeHTTP(w, req)
+            if w.Code != tc.want {
+                t.Fatalf("status = %d, want %d: %s", w.Code, tc.want, w.Body.String())
+            }
+        })
+    }
+}
+
+func TestChatHistoryLimits(t *testing.T) {
+    at := time.Now()
+    exchange := func(h *chatHistory, sessionID, q, a string) {
+        h.append("alice", sessionID, chatTurn{Role: "user", Content: q, At: at}, chatTurn{Role: "assistant", Content: a, At: at})
+    }
+
+    t.Run("maxTurns", func(t *testing.T) {
+        h := newChatHistory(chatLimits{MaxTurns: 4})
+        exchange(h, "s1", "q1", "a1")
+        exchange(h, "s1", "q2", "a2")
+        exchange(h, "s1", "q3", "a3")
+        info, turns, ok := h.get("alice", "s1")
+        if !ok || info.Turns != 4 || info.Dropped != 2 {
+            t.Fatalf("info = %+v, ok %t", info, ok)
+        }
+        if turns[0].Content != "q2" || turns[3].Content != "a3" {
+            t.Fatalf("turns = %+v", turns)
+        }
+        if got, want := h.context("alice", "s1"), "(2 earlier turns omitted)\nUser: q2\nAssistant: a2\nUser: q3\nAssistant: a3"; got != want {
+            t.Fatalf("context = %q, want %q", got, want)
+        }
+    })
+
+    t.Run("maxTurnChars", func(t *testing.T) {
+        h := newChatHistory(chatLimits{MaxTurnChars: 5})
+        exchange(h, "s1", "光合作用发生在叶绿体", "short")
+        _, turns, _ := h.get("alice", "s1")
+        if turns[0].Content != "光合作用发…" || turns[1].Content != "short" {
+            t.Fatalf("turns = %+v", turns)
+        }
+    })
+
+    t.Run("contextChars", func(t *testing.T) {
+        cases := []struct {
+            chars int
+            want  string
+        }{
+            {0, "User: first question\nAssistant: first answer\nUser: second\nAssistant: second answer"},
+            // the newest turns fit whole; too little is left for a useful tail of the next
+            {40, "(2 earlier turns omitted)\nUser: second\nAssistant: second answer"},
+            // the turn that only partly fits keeps its tail
+            {60, "(1 earlier turns omitted)\n…sistant: first answer\nUser: second\nAssistant: second answer"},
+        }
+        for _, tc := range cases {
+            h := newChatHistory(chatLimits{ContextChars: tc.chars})
+            exchange(h, "s1", "first question", "first answer")
+            exchange(h, "s1", "second", "second answer")
+            if got := h.context("alice", "s1"); got != tc.want {
+                t.Errorf("contextChars %d: context = %q, want %q", tc.chars, got, tc.want)
+            }
+        }
+    })
+
+    t.Run("ttl", func(t *testing.T) {
+        h := newChatHistory(chatLimits{TTL: time.Minute})
+        exchange(h, "s1", "q1", "a1")
+        exchange(h, "s2", "q1", "a1")
+        h.sessions[chatKey("alice", "s1")].updated = time.Now().Add(-2 * time.Minute)
+        if got := h.context("alice", "s1"); got != "" {
+            t.Fatalf("expired context = %q", got)
+        }
+        if _, _, ok := h.get("alice", "s1"); ok {
+            t.Fatal("expired session still returned")
+        }
+        if list := h.list("alice"); len(list) != 1 || list[0].SessionID != "s2" {
+            t.Fatalf("list = %+v", list)
+        }
+        // an expired session starts over
+        exchange(h, "s1", "q2", "a2")
+        if info, _, _ := h.get("alice", "s1"); info.Turns != 2 {
+            t.Fatalf("turns after expiry = %d, want 2", info.Turns)
+        }
+    })
+
+    t.Run("maxSessions", func(t *testing.T) {
+        h := newChatHistory(chatLimits{MaxSessions: 2})
+        exchange(h, "s1", "q1", "a1")
+        exchange(h, "s2", "q1", "a1")
+        h.sessions[chatKey("alice", "s1")].updated = time.Now().Add(-2 * time.Second)
+        h.sessions[chatKey("alice", "s2")].updated = time.Now().Add(-time.Second)
+        // s1 is used again, so s2 is the least recently updated
+        exchange(h, "s1", "q2", "a2")
+        exchange(h, "s3", "q1", "a1")
+        var ids []string
+        for _, s := range h.list("alice") {
+            ids = append(ids, s.SessionID)
+        }
+        if len(ids) != 2 || ids[0] != "s3" || ids[1] != "s1" {
+            t.Fatalf("sessions = %v, want [s3 s1]", ids)
+        }
+    })
+}
+
+func TestChatHistoryAnonymousCallers(t *testing.T) {
+    f := &echoInteractServer{}
+    cfg := &config.Config{}
+    cfg.User.ID = "default-user"
+    cfg.User.Tokens = []config.UserToken{{Token: "alice-token", ID: "alice"}}
+    h := &Handler{config: cfg}
+    ch := newCapabilityHandler(cfg, echoPool(t, f), newRefStore(0, 0, 0), h.resolveIdentity)
+    on := true
+    router := gin.New()
+    router.POST("/api/chat", ch.run(newCapability(config.CapabilityConfig{Name: "chat", Input: "message", History: &on})))
+    // send posts a chat message and returns the history key of the response
+    send := func(message string, header ...string) string {
+        t.Helper()
+        w := httptest.NewRecorder()
+        req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"sessionId":"s1","message":"`+message+`"}`))
+        for i := 0; i+1 < len(header); i += 2 {
+            req.Header.Set(header[i], header[i+1])
+        }
+        router.ServeHTTP(w, req)
+        if w.Code != http.StatusOK {
+            t.Fatalf("%s: status = %d", message, w.Code)
+        }
+        return w.Header().Get(historyKeyHeader)
+    }
+
+    if key := send("first", "Authorization", "Bearer alice-token"); key != "" {
+        t.Fatalf("history key %q issued to a token user", key)
+    }
+    // naming alice without her token neither reads nor extends her history
+    key := send("second", "X-User-ID", "alice")
+    if got := f.lastInput(t); got != "second" {
+        t.Fatalf("anonymous input = %q, want the message alone", got)
+    }
+    if info, _, _ := ch.history.get("alice", "s1"); info.Turns != 2 {
+        t.Fatalf("turns = %d, want 2", info.Turns)
+    }
+    if key == "" {
+        t.Fatal("no history key issued to an anonymous caller")
+    }
+
+    // the issued key brings the anonymous turns back
+    if again := send("third", historyKeyHeader, key); again != key {
+        t.Fatalf("history key = %q, want %q kept", again, key)
+    }
+    if got, want := f.lastInput(t), "Context\nUser: second\nAssistant: second\n\nUser: third"; got != want {
+        t.Fatalf("second anonymous input = %q, want %q", got, want)
+    }
+    // without it, or with a key the server never issued, the same sessionId starts afresh
+    for _, header := range [][]string{nil, {historyKeyHeader, "s1"}} {
+        if fresh := send("fourth", header...); fresh == key || fresh == "" {
+            t.Fatalf("history key = %q, want a new one", fresh)
+        }
+        if got := f.lastInput(t); got != "fourth" {
+            t.Fatalf("input = %q, want the message alone", got)
+        }
+    }
+
+    send("fifth", "Authorization", "Bearer alice-token")
+    if got, want := f.lastInput(t), "Context\nUser: first\nAssistant: first\n\nUser: fifth"; got != want {
+        t.Fatalf("input = %q, want %q", got, want)
+    }
+}
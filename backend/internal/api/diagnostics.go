@@ -18,7 +18,10 @@ func (h *Handler) registerDiagnostics(router *gin.Engine) {
 type healthStatus struct {
     Server string `json:"server"`
     PCAS   struct {
-        Address    string `json:"address"`
+        Address    string           `json:"address"`
+        Pool       []pcas.ConnState `json:"pool"`
+        Publisher  pcas.PublishStats `json:"publisher"`
+        Subscriber *pcas.SubscriberStats `json:"subscriber,omitempty"`
         Transcribe status `json:"transcribe"`
         Translate  status `json:"translate"`
         Summarize  status `json:"summarize"`
@@ -34,14 +37,17 @@ type status struct {
 func (h *Handler) handleHealth(c *gin.Context) {
     s := healthStatus{Server: "ok"}
     s.PCAS.Address = h.config.PCAS.Address
+    s.PCAS.Pool = h.pool.States()
+    s.PCAS.Publisher = h.pool.PublishStats()
+    if sub := h.pool.Subscriber(); sub != nil {
+        st := sub.Stats()
+        s.PCAS.Subscriber = &st
+    }
 
     check := func(evt string) status {
         ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
         defer cancel()
-        gw, err := pcas.NewGateway(h.config.PCAS.Address)
-        if err != nil {
-            return status{Ok: false, Error: err.Error()}
-        }
+        gw := h.pool.Gateway()
         defer gw.Close()
         if err := gw.CheckReady(ctx, evt, map[string]string{"probe": "true"}); err != nil {
             return status{Ok: false, Error: err.Error()}
@@ -202,15 +208,25 @@ func (h *Handler) handleTestPage(c *gin.Context) {
     };
     wsCloseBtn.onclick = () => { try{ ws && ws.close(); }catch{} };
 
-    // Default attrs presets for quick testing (no need to hand-write JSON)
-    const defaults = {
-      translate: { model: 'gpt-5-mini', system: 'You are a translator. Translate all user input to English.' },
-      summarize: { model: 'gpt-5-mini', system: 'Summarize the user input in 3 concise bullet points.' },
-      chat:      { model: 'gpt-5', system: 'You are a helpful assistant.' }
-    };
-    document.getElementById('trAttrs').value = JSON.stringify(defaults.translate);
-    document.getElementById('smAttrs').value = JSON.stringify(defaults.summarize);
-    document.getElementById('chatAttrs').value = JSON.stringify(defaults.chat);
+    // Prefill options with the server-side presets (no need to hand-write JSON)
+    getJSON('/api/capabilities').then((r)=> {
+      const ids = { translate: ['trAttrs','tr1Attrs'], summarize: ['smAttrs','sm1Attrs'], chat: ['chatAttrs'] };
+      for (const cp of r.capabilities || []) {
+        for (const id of ids[cp.name] || []) {
+          if (cp.model) document.getElementById(id).value = JSON.stringify({ model: cp.model });
+        }
+      }
+    }).catch(()=>{});
+
+    // Typed capability events; done/error end the stream, so stop EventSource from reconnecting
+    function followES(es, el) {
+      for (const name of ['ready','delta','heartbeat']) es.addEventListener(name, (ev)=> log(el, name+':', ev.data));
+      es.addEventListener('done', (ev)=> { log(el, 'done:', ev.data); es.close(); });
+      es.addEventListener('error', (ev)=> {
+        if (ev.data) { log(el, 'error:', ev.data); es.close(); }
+        else log(el, 'connection error');
+      });
+    }
 
     // Translate SSE
     let trId = null, trES = null;
@@ -225,8 +241,7 @@ func (h *Handler) handleTestPage(c *gin.Context) {
         let attrs = {}; try { if (attrsRaw) attrs = JSON.parse(attrsRaw) } catch(e){}
         const r = await getJSON('/api/translate/start', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({sessionId:'test', targetLang:lang, attrs})});
         trId = r.streamId; trES = new EventSource('/api/translate/stream?streamId='+encodeURIComponent(trId));
-        trES.onmessage = (ev)=> log(trLog, 'data:', ev.data);
-        trES.onerror = (e)=> log(trLog, 'error');
+        followES(trES, trLog);
         trSendBtn.disabled = false; trStopBtn.disabled=false; document.getElementById('trCommit').disabled=false;
       } catch(e){ log(trLog, 'ERR', e.message); }
     };
@@ -261,8 +276,7 @@ func (h *Handler) handleTestPage(c *gin.Context) {
         let attrs = {}; try { if (attrsRaw) attrs = JSON.parse(attrsRaw) } catch(e){}
         const r = await getJSON('/api/summarize/start', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({sessionId:'test', mode:mode, attrs})});
         smId = r.streamId; smES = new EventSource('/api/summarize/stream?streamId='+encodeURIComponent(smId));
-        smES.onmessage = (ev)=> log(smLog, 'data:', ev.data);
-        smES.onerror = (e)=> log(smLog, 'error');
+        followES(smES, smLog);
         smSendBtn.disabled = false; smStopBtn.disabled=false; document.getElementById('smCommit').disabled=false;
       } catch(e){ log(smLog, 'ERR', e.message); }
     };
@@ -0,0 +1,82 @@
+package api
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+// ssePCASEvent carries one event PCAS pushed on the Subscribe stream.
+const ssePCASEvent = "pcas_event"
+
+func (h *Handler) registerEvents(router *gin.Engine) {
+    router.GET("/api/events/stream", h.handleEventStream)
+}
+
+// eventStreamReady opens /api/events/stream.
+type eventStreamReady struct {
+    ClientID  string `json:"clientId"`
+    Connected bool   `json:"connected"`
+    SessionID string `json:"sessionId,omitempty"`
+}
+
+// handleEventStream forwards the events PCAS pushes for the caller (and, with
+// X-Session-ID or ?sessionId=, for that session) as pcas_event SSE events.
+// Events without a user or session are broadcasts and go to every stream.
+// The stream is live only: events pushed while disconnected are not replayed.
+func (h *Handler) handleEventStream(c *gin.Context) {
+    ident, ok := requireToken(c, h.resolveIdentity)
+    if !ok {
+        return
+    }
+    s := h.pool.Subscriber()
+    if s == nil {
+        c.JSON(http.StatusServiceUnavailable, gin.H{"error": gin.H{"code": "subscribe_disabled", "message": "PCAS event subscription is disabled (pcas.subscribe.enabled)"}})
+        return
+    }
+    // resolveIdentity invents a session id when none is given; only an
+    // explicit one narrows the stream
+    sessionID := c.GetHeader("X-Session-ID")
+    if sessionID == "" {
+        sessionID = c.Query("sessionId")
+    }
+    sub := s.Subscribe(pcas.SubscriptionFilter{UserID: ident.UserID, SessionID: sessionID})
+    defer sub.Close()
+
+    startSSE(c)
+    w := c.Writer
+    write := func(event, id string, data any) {
+        b, _ := json.Marshal(data)
+        if id != "" {
+            _, _ = fmt.Fprintf(w, "id: %s\n", id)
+        }
+        _, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+        w.Flush()
+    }
+    write(sseReady, "", eventStreamReady{ClientID: s.ClientID(), Connected: s.Stats().Connected, SessionID: sessionID})
+
+    var beat <-chan time.Time
+    if hb := h.config.Server.SSEHeartbeat; hb > 0 {
+        t := time.NewTicker(hb)
+        defer t.Stop()
+        beat = t.C
+    }
+    for {
+        select {
+        case ev, ok := <-sub.Events():
+            if !ok {
+                // the pool is shutting down; EventSource reconnects by itself
+                return
+            }
+            write(ssePCASEvent, ev.ID, ev)
+        case <-beat:
+            write(sseHeartbeat, "", sseHeartbeatData{Ts: time.Now().UnixMilli()})
+        case <-c.Request.Context().Done():
+            return
+        }
+    }
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+func TestEventStreamRequiresToken(t *testing.T) {
+    // no subscriber: a caller that passes the identity check gets 503
+    pool, err := pcas.NewPool("127.0.0.1:1", pcas.PoolOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer pool.Close()
+    cfg := &config.Config{}
+    cfg.User.ID = "default-user"
+    cfg.User.Tokens = []config.UserToken{{Token: "alice-token", ID: "alice"}}
+    router := gin.New()
+    (&Handler{config: cfg, pool: pool}).registerEvents(router)
+
+    cases := []struct {
+        name   string
+        header string
+        value  string
+        query  string
+        want   int
+    }{
+        {"X-User-ID", "X-User-ID", "alice", "", http.StatusForbidden},
+        {"userId", "", "", "?userId=alice", http.StatusForbidden},
+        {"default user", "", "", "", http.StatusForbidden},
+        {"unknown token", "Authorization", "Bearer nope", "", http.StatusUnauthorized},
+        {"token", "Authorization", "Bearer alice-token", "", http.StatusServiceUnavailable},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            w := httptest.NewRecorder()
+            req := httptest.NewRequest(http.MethodGet, "/api/events/stream"+tc.query, nil)
+            if tc.header != "" {
+                req.Header.Set(tc.header, tc.value)
+            }
+            router.ServeHTTP(w, req)
+            if w.Code != tc.want {
+                t.Fatalf("status = %d, want %d: %s", w.Code, tc.want, w.Body.String())
+            }
+        })
+    }
+}
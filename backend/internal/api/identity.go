@@ -0,0 +1,156 @@
+package api
+
+import (
+    "crypto/subtle"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/gorilla/websocket"
+)
+
+// identity is who a connection acts as and which session it belongs to.
+type identity struct {
+    UserID    string
+    SessionID string
+    TraceID   string
+    // Authenticated is true when UserID came from a configured token; such an
+    // identity cannot be overridden by client-supplied ids.
+    Authenticated bool
+}
+
+var errUnknownToken = errors.New("unknown or invalid auth token")
+
+// resolveIdentity picks the user for a request, in order of precedence:
+// auth token (Authorization: Bearer, a bearer.<token> WebSocket subprotocol
+// or ?token=), X-User-ID header, ?userId=, then the configured default. Session ids come from X-Session-ID or
+// ?sessionId= and are generated when absent; each call gets a fresh trace id.
+func (h *Handler) resolveIdentity(c *gin.Context) (identity, error) {
+    id := identity{TraceID: newTraceID()}
+
+    token := c.Query("token")
+    // browsers cannot set headers on a WebSocket; the subprotocol list keeps
+    // the token out of the URL and so out of proxy and access logs
+    for _, p := range websocket.Subprotocols(c.Request) {
+        if t, ok := strings.CutPrefix(p, subprotocolBearer); ok && t != "" {
+            token = t
+        }
+    }
+    if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+        token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+    }
+    switch {
+    case token != "":
+        userID, ok := h.lookupToken(token)
+        if !ok {
+            return id, errUnknownToken
+        }
+        id.UserID = userID
+        id.Authenticated = true
+    case h.config.User.RequireToken:
+        return id, errUnknownToken
+    case c.GetHeader("X-User-ID") != "":
+        id.UserID = c.GetHeader("X-User-ID")
+    case c.Query("userId") != "":
+        id.UserID = c.Query("userId")
+    default:
+        id.UserID = h.config.User.ID
+    }
+
+    id.SessionID = c.GetHeader("X-Session-ID")
+    if id.SessionID == "" {
+        id.SessionID = c.Query("sessionId")
+    }
+    if id.SessionID == "" {
+        id.SessionID = uuid.New().String()
+    }
+    return id, nil
+}
+
+// requireToken resolves the caller of an endpoint that reads stored per-user
+// data and writes the error response unless the identity came from an auth
+// token: the X-User-ID and ?userId= fallbacks would let anyone name any user.
+func requireToken(c *gin.Context, resolve func(*gin.Context) (identity, error)) (identity, bool) {
+    ident, err := resolve(c)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": gin.H{"message": err.Error()}})
+        return ident, false
+    }
+    if !ident.Authenticated {
+        authRequired(c)
+        return ident, false
+    }
+    return ident, true
+}
+
+// authRequired writes the 403 for a caller without an auth token asking for
+// stored per-user data.
+func authRequired(c *gin.Context) {
+    c.JSON(http.StatusForbidden, gin.H{"error": gin.H{"code": "auth_required", "message": "an auth token (user.tokens) is required to read this user's data"}})
+}
+
+// sessionQuotaKey is the key per-user stream limits count ident under:
+// the user id when a token vouches for it, otherwise the client address,
+// since anonymous user ids can be picked freely.
+func sessionQuotaKey(c *gin.Context, ident identity) string {
+    if ident.Authenticated {
+        return "user:" + ident.UserID
+    }
+    return "ip:" + c.ClientIP()
+}
+
+func (h *Handler) lookupToken(token string) (string, bool) {
+    for _, t := range h.config.User.Tokens {
+        if t.Token != "" && subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+            return t.ID, true
+        }
+    }
+    return "", false
+}
+
+// newTraceID returns a W3C-style 32 hex character trace id.
+func newTraceID() string {
+    return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// AccessLogger is gin's request logger with ?token= values redacted: the
+// socket and SSE routes accept the auth token in the query, and the default
+// logger writes the raw query to the access log.
+func AccessLogger() gin.HandlerFunc {
+    return gin.LoggerWithConfig(gin.LoggerConfig{Formatter: func(p gin.LogFormatterParams) string {
+        if p.Latency > time.Minute {
+            p.Latency = p.Latency.Truncate(time.Second)
+        }
+        return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+            p.TimeStamp.Format("2006/01/02 - 15:04:05"),
+            p.StatusCode,
+            p.Latency,
+            p.ClientIP,
+            p.Method,
+            redactToken(p.Path),
+            p.ErrorMessage,
+        )
+    }})
+}
+
+// redactToken replaces the value of every token parameter in the query of
+// path, leaving the other parameters as they were sent.
+func redactToken(path string) string {
+    base, query, ok := strings.Cut(path, "?")
+    if !ok {
+        return path
+    }
+    params := strings.Split(query, "&")
+    for i, kv := range params {
+        key, _, _ := strings.Cut(kv, "=")
+        if name, err := url.QueryUnescape(key); err == nil && name == "token" {
+            params[i] = key + "=REDACTED"
+        }
+    }
+    return base + "?" + strings.Join(params, "&")
+}
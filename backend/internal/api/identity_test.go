@@ -0,0 +1,85 @@
+package api
+
+import (
+    "bytes"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+)
+
+func TestRedactToken(t *testing.T) {
+    cases := []struct{ in, want string }{
+        {"/ws/transcribe", "/ws/transcribe"},
+        {"/ws/transcribe?sessionId=s1", "/ws/transcribe?sessionId=s1"},
+        {"/ws/transcribe?token=secret", "/ws/transcribe?token=REDACTED"},
+        {"/api/events/stream?sessionId=s1&token=secret&protocol=v2", "/api/events/stream?sessionId=s1&token=REDACTED&protocol=v2"},
+        {"/x?token=a&token=b", "/x?token=REDACTED&token=REDACTED"},
+        // escaped names are what the server decodes as token too
+        {"/x?%74oken=secret", "/x?%74oken=REDACTED"},
+        {"/x?tokens=1&mytoken=2", "/x?tokens=1&mytoken=2"},
+    }
+    for _, tc := range cases {
+        if got := redactToken(tc.in); got != tc.want {
+            t.Errorf("redactToken(%q) = %q, want %q", tc.in, got, tc.want)
+        }
+    }
+}
+
+func TestAccessLoggerRedactsToken(t *testing.T) {
+    var out bytes.Buffer
+    writer := gin.DefaultWriter
+    gin.DefaultWriter = &out
+    defer func() { gin.DefaultWriter = writer }()
+
+    router := gin.New()
+    router.Use(AccessLogger())
+    router.GET("/api/events/stream", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+    router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/events/stream?token=secret&sessionId=s1", nil))
+
+    line := out.String()
+    if strings.Contains(line, "secret") || !strings.Contains(line, `"/api/events/stream?token=REDACTED&sessionId=s1"`) || !strings.Contains(line, "204") {
+        t.Fatalf("log line = %q", line)
+    }
+}
+
+func TestResolveIdentityToken(t *testing.T) {
+    cfg := &config.Config{}
+    cfg.User.ID = "default-user"
+    cfg.User.Tokens = []config.UserToken{{Token: "alice-token", ID: "alice"}, {Token: "bob-token", ID: "bob"}}
+    h := &Handler{config: cfg}
+    cases := []struct {
+        name   string
+        target string
+        header []string
+        user   string
+        auth   bool
+        err    bool
+    }{
+        {"query", "/ws/transcribe?token=alice-token", nil, "alice", true, false},
+        {"subprotocol", "/ws/transcribe", []string{"Sec-WebSocket-Protocol", "dreamscribe.v2, bearer.alice-token"}, "alice", true, false},
+        {"subprotocol over query", "/ws/transcribe?token=bob-token", []string{"Sec-WebSocket-Protocol", "bearer.alice-token"}, "alice", true, false},
+        {"header over subprotocol", "/ws/transcribe", []string{"Sec-WebSocket-Protocol", "bearer.alice-token", "Authorization", "Bearer bob-token"}, "bob", true, false},
+        {"unknown subprotocol token", "/ws/transcribe", []string{"Sec-WebSocket-Protocol", "bearer.nope"}, "", false, true},
+        {"v2 alone", "/ws/transcribe?userId=carol", []string{"Sec-WebSocket-Protocol", "dreamscribe.v2"}, "carol", false, false},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            c, _ := gin.CreateTestContext(httptest.NewRecorder())
+            c.Request = httptest.NewRequest(http.MethodGet, tc.target, nil)
+            for i := 0; i+1 < len(tc.header); i += 2 {
+                c.Request.Header.Set(tc.header[i], tc.header[i+1])
+            }
+            id, err := h.resolveIdentity(c)
+            if (err != nil) != tc.err {
+                t.Fatalf("error = %v, want error %t", err, tc.err)
+            }
+            if err == nil && (id.UserID != tc.user || id.Authenticated != tc.auth) {
+                t.Fatalf("identity = %+v, want %s authenticated=%t", id, tc.user, tc.auth)
+            }
+        })
+    }
+}
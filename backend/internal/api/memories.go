@@ -0,0 +1,118 @@
+package api
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+func (h *Handler) registerMemories(router *gin.Engine) {
+    router.GET("/api/memories/search", h.handleMemorySearch)
+}
+
+type memorySearchResp struct {
+    Query    string           `json:"query"`
+    Results  []pcas.SearchHit `json:"results"`
+    Offset   int              `json:"offset"`
+    TopK     int              `json:"topK"`
+    MinScore float64          `json:"minScore"`
+    // NextOffset is set when more results are available.
+    NextOffset *int `json:"nextOffset,omitempty"`
+}
+
+// handleMemorySearch searches the caller's memory events:
+// ?q=&topK=&offset=&session=&course=&tag=a&tag=b&minScore=
+// PCAS has no paging, so offset+topK hits are fetched (up to search.maxTopK)
+// and the requested page is cut out after the score threshold is applied.
+func (h *Handler) handleMemorySearch(c *gin.Context) {
+    ident, ok := requireToken(c, h.resolveIdentity)
+    if !ok {
+        return
+    }
+    q := strings.TrimSpace(c.Query("q"))
+    if q == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "q is required"}})
+        return
+    }
+    cfg := h.config.Search
+    topK, offset, minScore := cfg.TopK, 0, cfg.MinScore
+    var err error
+    if v := c.Query("topK"); v != "" {
+        if topK, err = strconv.Atoi(v); err != nil || topK <= 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "topK must be a positive integer"}})
+            return
+        }
+    }
+    if v := c.Query("offset"); v != "" {
+        if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "offset must be a non-negative integer"}})
+            return
+        }
+    }
+    if v := c.Query("minScore"); v != "" {
+        s, err := strconv.ParseFloat(v, 64)
+        if err != nil || s < 0 || s > 1 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "minScore must be between 0 and 1"}})
+            return
+        }
+        // clients may only raise the server-side threshold
+        if s > minScore {
+            minScore = s
+        }
+    }
+    if offset >= cfg.MaxTopK {
+        c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "offset beyond search.maxTopK (" + strconv.Itoa(cfg.MaxTopK) + ")"}})
+        return
+    }
+    if offset+topK > cfg.MaxTopK {
+        topK = cfg.MaxTopK - offset
+    }
+
+    var tags []string
+    for _, t := range c.QueryArray("tag") {
+        tags = append(tags, parseTags(t)...)
+    }
+    ctx, cancel := context.WithTimeout(c.Request.Context(), cfg.Timeout)
+    defer cancel()
+    gw := h.pool.Gateway()
+    defer gw.Close()
+    // one extra hit tells whether another page exists
+    fetch := offset + topK + 1
+    if fetch > cfg.MaxTopK {
+        fetch = cfg.MaxTopK
+    }
+    hits, err := gw.Search(ctx, pcas.SearchQuery{
+        Text:    q,
+        TopK:    fetch,
+        UserID:  ident.UserID,
+        Filters: memoryFilters(c.Query("course"), c.Query("session"), tags),
+    })
+    if err != nil {
+        c.JSON(http.StatusBadGateway, gin.H{"error": gin.H{"message": err.Error()}})
+        return
+    }
+
+    kept := hits[:0]
+    for _, hit := range hits {
+        // PCAS filters by user already; never leak another user's events
+        if float64(hit.Score) >= minScore && (hit.UserID == "" || hit.UserID == ident.UserID) {
+            kept = append(kept, hit)
+        }
+    }
+    resp := memorySearchResp{Query: q, Results: []pcas.SearchHit{}, Offset: offset, TopK: topK, MinScore: minScore}
+    if offset < len(kept) {
+        end := offset + topK
+        if end > len(kept) {
+            end = len(kept)
+        }
+        resp.Results = kept[offset:end]
+        if end < len(kept) {
+            resp.NextOffset = &end
+        }
+    }
+    c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+    "google.golang.org/grpc"
+)
+
+// fakeSearchServer answers Search with the first TopK of its events and
+// records every request.
+type fakeSearchServer struct {
+    busv1.UnimplementedEventBusServiceServer
+
+    events []*eventsv1.Event
+    scores []float32
+
+    mu   sync.Mutex
+    reqs []*busv1.SearchRequest
+}
+
+func (f *fakeSearchServer) Search(_ context.Context, req *busv1.SearchRequest) (*busv1.SearchResponse, error) {
+    f.mu.Lock()
+    f.reqs = append(f.reqs, req)
+    f.mu.Unlock()
+    n := int(req.GetTopK())
+    if n > len(f.events) {
+        n = len(f.events)
+    }
+    return &busv1.SearchResponse{Events: f.events[:n], Scores: f.scores[:n]}, nil
+}
+
+func (f *fakeSearchServer) last(t *testing.T) *busv1.SearchRequest {
+    t.Helper()
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if len(f.reqs) == 0 {
+        t.Fatal("PCAS Search was not called")
+    }
+    return f.reqs[len(f.reqs)-1]
+}
+
+// newFakeSearch serves n events for alice, scored 0.96, 0.91, ... down by 0.05
+// so no score sits on a threshold; the indexes in foreign belong to another user.
+func newFakeSearch(n int, foreign ...int) *fakeSearchServer {
+    f := &fakeSearchServer{}
+    for i := 0; i < n; i++ {
+        user := "alice"
+        for _, j := range foreign {
+            if i == j {
+                user = "mallory"
+            }
+        }
+        f.events = append(f.events, &eventsv1.Event{Id: fmt.Sprintf("ev-%d", i), Subject: fmt.Sprintf("memory %d", i), UserId: user})
+        f.scores = append(f.scores, 0.96-0.05*float32(i))
+    }
+    return f
+}
+
+// memoriesRouter serves /api/memories/search against f through a real Pool.
+func memoriesRouter(t *testing.T, f *fakeSearchServer, search config.SearchConfig) *gin.Engine {
+    t.Helper()
+    cfg := &config.Config{Search: search}
+    cfg.User.ID = "default-user"
+    cfg.User.Tokens = []config.UserToken{{Token: "alice-token", ID: "alice"}}
+    h := &Handler{config: cfg, pool: fakeSearchPool(t, f)}
+    router := gin.New()
+    h.registerMemories(router)
+    return router
+}
+
+// fakeSearchPool serves f on a local port and returns a Pool dialing it.
+func fakeSearchPool(t *testing.T, f *fakeSearchServer) *pcas.Pool {
+    t.Helper()
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := grpc.NewServer()
+    busv1.RegisterEventBusServiceServer(srv, f)
+    go func() { _ = srv.Serve(lis) }()
+    t.Cleanup(srv.Stop)
+
+    pool, err := pcas.NewPool(lis.Addr().String(), pcas.PoolOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { _ = pool.Close() })
+    return pool
+}
+
+func searchMemories(t *testing.T, router *gin.Engine, query string) (int, memorySearchResp) {
+    t.Helper()
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodGet, "/api/memories/search?"+query, nil)
+    req.Header.Set("Authorization", "Bearer alice-token")
+    router.ServeHTTP(w, req)
+    var resp memorySearchResp
+    if w.Code == http.StatusOK {
+        if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return w.Code, resp
+}
+
+func hitIDs(hits []pcas.SearchHit) []string {
+    ids := []string{}
+    for _, h := range hits {
+        ids = append(ids, h.EventID)
+    }
+    return ids
+}
+
+var testSearchConfig = config.SearchConfig{TopK: 3, MaxTopK: 8, MinScore: 0.6, Timeout: 2 * time.Second}
+
+func TestMemorySearchRequest(t *testing.T) {
+    f := newFakeSearch(3)
+    router := memoriesRouter(t, f, testSearchConfig)
+
+    code, _ := searchMemories(t, router, "q=photosynthesis&session=s1&course=bio&tag=exam,lab&tag=final&tag=exam")
+    if code != http.StatusOK {
+        t.Fatalf("status = %d", code)
+    }
+    req := f.last(t)
+    if req.GetQueryText() != "photosynthesis" || req.GetUserId() != "alice" {
+        t.Fatalf("query %q user %q", req.GetQueryText(), req.GetUserId())
+    }
+    want := map[string]string{
+        "session_id": "s1",
+        "course":     "bio",
+        "tag:exam":   "true",
+        "tag:lab":    "true",
+        "tag:final":  "true",
+    }
+    if !reflect.DeepEqual(req.GetAttributeFilters(), want) {
+        t.Fatalf("filters = %v, want %v", req.GetAttributeFilters(), want)
+    }
+    // default topK plus one to detect a further page
+    if req.GetTopK() != 4 {
+        t.Fatalf("TopK = %d, want 4", req.GetTopK())
+    }
+
+    searchMemories(t, router, "q=x")
+    if filters := f.last(t).GetAttributeFilters(); len(filters) != 0 {
+        t.Fatalf("filters without parameters = %v", filters)
+    }
+}
+
+func TestMemorySearchMinScore(t *testing.T) {
+    // ev-2 (0.86) belongs to another user and must never be returned
+    router := memoriesRouter(t, newFakeSearch(10, 2), testSearchConfig)
+
+    cases := []struct {
+        query    string
+        minScore float64
+        want     []string
+    }{
+        // the configured 0.6 applies by default
+        {"q=x&topK=7", 0.6, []string{"ev-0", "ev-1", "ev-3", "ev-4", "ev-5", "ev-6", "ev-7"}},
+        {"q=x&topK=7&minScore=0.8", 0.8, []string{"ev-0", "ev-1", "ev-3"}},
+        // a lower client threshold does not undercut the server's
+        {"q=x&topK=7&minScore=0.1", 0.6, []string{"ev-0", "ev-1", "ev-3", "ev-4", "ev-5", "ev-6", "ev-7"}},
+    }
+    for _, tc := range cases {
+        t.Run(tc.query, func(t *testing.T) {
+            code, resp := searchMemories(t, router, tc.query)
+            if code != http.StatusOK {
+                t.Fatalf("status = %d", code)
+            }
+            if resp.MinScore != tc.minScore {
+                t.Errorf("minScore = %v, want %v", resp.MinScore, tc.minScore)
+            }
+            if got := hitIDs(resp.Results); !reflect.DeepEqual(got, tc.want) {
+                t.Errorf("results = %v, want %v", got, tc.want)
+            }
+            for _, h := range resp.Results {
+                if float64(h.Score) < tc.minScore {
+                    t.Errorf("%s score %v below %v", h.EventID, h.Score, tc.minScore)
+                }
+            }
+        })
+    }
+}
+
+func TestMemorySearchPagination(t *testing.T) {
+    router := memoriesRouter(t, newFakeSearch(6), config.SearchConfig{TopK: 3, MaxTopK: 20, MinScore: 0.6, Timeout: 2 * time.Second})
+
+    // ev-0..ev-5 score 0.96..0.71, all above the threshold
+    cases := []struct {
+        query string
+        want  []string
+        next  *int
+    }{
+        {"q=x&topK=2", []string{"ev-0", "ev-1"}, intPtr(2)},
+        {"q=x&topK=2&offset=2", []string{"ev-2", "ev-3"}, intPtr(4)},
+        {"q=x&topK=2&offset=4", []string{"ev-4", "ev-5"}, nil},
+        {"q=x&topK=4&offset=4", []string{"ev-4", "ev-5"}, nil},
+        {"q=x&topK=2&offset=6", []string{}, nil},
+    }
+    for _, tc := range cases {
+        t.Run(tc.query, func(t *testing.T) {
+            code, resp := searchMemories(t, router, tc.query)
+            if code != http.StatusOK {
+                t.Fatalf("status = %d", code)
+            }
+            if got := hitIDs(resp.Results); !reflect.DeepEqual(got, tc.want) {
+                t.Errorf("results = %v, want %v", got, tc.want)
+            }
+            if !reflect.DeepEqual(resp.NextOffset, tc.next) {
+                t.Errorf("nextOffset = %v, want %v", derefInt(resp.NextOffset), derefInt(tc.next))
+            }
+        })
+    }
+}
+
+func TestMemorySearchMaxTopK(t *testing.T) {
+    f := newFakeSearch(20)
+    router := memoriesRouter(t, f, config.SearchConfig{TopK: 3, MaxTopK: 8, Timeout: 2 * time.Second})
+
+    cases := []struct {
+        query     string
+        topK      int
+        fetch     int32
+        next      *int
+        resultLen int
+    }{
+        // topK is clamped to maxTopK and the look-ahead hit is not fetched
+        {"q=x&topK=20", 8, 8, nil, 8},
+        {"q=x&topK=5&offset=6", 2, 8, nil, 2},
+        {"q=x&topK=5", 5, 6, intPtr(5), 5},
+    }
+    for _, tc := range cases {
+        t.Run(tc.query, func(t *testing.T) {
+            code, resp := searchMemories(t, router, tc.query)
+            if code != http.StatusOK {
+                t.Fatalf("status = %d", code)
+            }
+            if resp.TopK != tc.topK {
+                t.Errorf("topK = %d, want %d", resp.TopK, tc.topK)
+            }
+            if got := f.last(t).GetTopK(); got != tc.fetch {
+                t.Errorf("PCAS TopK = %d, want %d", got, tc.fetch)
+            }
+            if len(resp.Results) != tc.resultLen || !reflect.DeepEqual(resp.NextOffset, tc.next) {
+                t.Errorf("%d results, nextOffset %v; want %d, %v", len(resp.Results), derefInt(resp.NextOffset), tc.resultLen, derefInt(tc.next))
+            }
+        })
+    }
+
+    for _, q := range []string{"q=x&offset=8", "q=x&topK=0", "q=x&offset=-1", "q=x&minScore=2", "topK=3"} {
+        if code, _ := searchMemories(t, router, q); code != http.StatusBadRequest {
+            t.Errorf("%s: status = %d, want 400", q, code)
+        }
+    }
+}
+
+func intPtr(n int) *int { return &n }
+
+func derefInt(p *int) any {
+    if p == nil {
+        return nil
+    }
+    return *p
+}
+
+func TestMemorySearchTextFromPayload(t *testing.T) {
+    // events without a Subject carry their text only in the payload, as
+    // published by MemoryEvent
+    ev, err := pcas.MemoryEvent(pcas.Memory{Text: "光合作用发生在叶绿体中。", UserID: "alice", SessionID: "s1"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    ev.Subject = ""
+    f := &fakeSearchServer{events: []*eventsv1.Event{ev}, scores: []float32{0.9}}
+    router := memoriesRouter(t, f, testSearchConfig)
+
+    code, resp := searchMemories(t, router, "q=x")
+    if code != http.StatusOK {
+        t.Fatalf("status = %d", code)
+    }
+    if len(resp.Results) != 1 || resp.Results[0].Text != "光合作用发生在叶绿体中。" {
+        t.Fatalf("results = %+v", resp.Results)
+    }
+}
+
+func TestMemorySearchRequiresToken(t *testing.T) {
+    f := newFakeSearch(3)
+    router := memoriesRouter(t, f, testSearchConfig)
+
+    cases := []struct {
+        name   string
+        header string
+        value  string
+        query  string
+        want   int
+    }{
+        {"X-User-ID", "X-User-ID", "alice", "", http.StatusForbidden},
+        {"userId", "", "", "&userId=alice", http.StatusForbidden},
+        {"default user", "", "", "", http.StatusForbidden},
+        {"unknown token", "Authorization", "Bearer nope", "", http.StatusUnauthorized},
+        {"query token", "", "", "&token=alice-token", http.StatusOK},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            w := httptest.NewRecorder()
+            req := httptest.NewRequest(http.MethodGet, "/api/memories/search?q=x"+tc.query, nil)
+            if tc.header != "" {
+                req.Header.Set(tc.header, tc.value)
+            }
+            router.ServeHTTP(w, req)
+            if w.Code != tc.want {
+                t.Fatalf("status = %d, want %d: %s", w.Code, tc.want, w.Body.String())
+            }
+        })
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if len(f.reqs) != 1 {
+        t.Fatalf("PCAS searched %d times, want only for the token", len(f.reqs))
+    }
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+// Pipeline modes of a capability (see config.CapabilityConfig.Pipeline).
+const (
+    pipelineSegment = "segment"
+    pipelineRolling = "rolling"
+)
+
+// pipelineQueue is how many sentences a pipeline may fall behind the
+// transcript before further ones are skipped.
+const pipelineQueue = 64
+
+// pipelineConcurrency is how many sentences a segment pipeline has in
+// flight at once; their results still reach the client in transcript order.
+const pipelineConcurrency = 8
+
+// transcriptPipeline feeds the distilled sentences of a transcription into
+// one capability.
+type transcriptPipeline struct {
+    cp      *capability
+    targets []streamTarget
+    segs    chan *pcas.SegmentInfo
+
+    mu sync.Mutex
+    // ended is the error code reported for sentences offered after the
+    // pipeline's stream ended: stream_ended or the code of its error.
+    ended string
+}
+
+// offer queues seg, or returns the frame reporting why it was skipped.
+func (p *transcriptPipeline) offer(seg *pcas.SegmentInfo) (wsPipeline, bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.ended != "" {
+        return p.skipped(seg, p.ended, "pipeline stream has ended; sentence not sent"), false
+    }
+    select {
+    case p.segs <- seg:
+        return wsPipeline{}, true
+    default:
+        return p.skipped(seg, "pipeline_overloaded", "pipeline is behind the transcript; sentence skipped"), false
+    }
+}
+
+// end marks the pipeline's stream as ended; later offers are skipped with code.
+func (p *transcriptPipeline) end(code string) {
+    p.mu.Lock()
+    p.ended = code
+    p.mu.Unlock()
+}
+
+func (p *transcriptPipeline) skipped(seg *pcas.SegmentInfo, code, message string) wsPipeline {
+    return wsPipeline{Pipeline: p.cp.Name, Event: sseError, SegmentID: seg.ID, Code: code, Message: message}
+}
+
+// bindPipelines resolves the pipelines a transcription asked for: capability
+// name to a body shaped like that capability's start request.
+func (h *Handler) bindPipelines(bodies map[string]map[string]any) ([]*transcriptPipeline, error) {
+    names := make([]string, 0, len(bodies))
+    for name := range bodies {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var out []*transcriptPipeline
+    for _, name := range names {
+        var cp *capability
+        for _, c := range h.caps {
+            if c.Name == name {
+                cp = c
+            }
+        }
+        if cp == nil {
+            return nil, fmt.Errorf("pipeline %s: unknown capability", name)
+        }
+        if cp.Pipeline == "" {
+            return nil, fmt.Errorf("pipeline %s: capability cannot run on the transcript", name)
+        }
+        body := map[string]any{}
+        for k, v := range bodies[name] {
+            body[k] = v
+        }
+        // the query form lists fanout values comma-separated
+        if list, ok := body[cp.Fanout.Field].(string); ok && cp.Fanout.Field != "" {
+            var values []any
+            for _, v := range parseTags(list) {
+                values = append(values, v)
+            }
+            body[cp.Fanout.Field] = values
+        }
+        attrs, err := cp.attributes(body)
+        if err != nil {
+            return nil, fmt.Errorf("pipeline %s: %w", name, err)
+        }
+        targets, err := cp.fanout(body, attrs)
+        if err != nil {
+            return nil, fmt.Errorf("pipeline %s: %w", name, err)
+        }
+        out = append(out, &transcriptPipeline{cp: cp, targets: targets, segs: make(chan *pcas.SegmentInfo, pipelineQueue)})
+    }
+    return out, nil
+}
+
+// pipelineBodies reads ?pipelines=translate,summarize. Each pipeline's body
+// takes from the other query parameters only the fields its capability
+// declares (params and the fanout field, e.g. targetLang, mode), so the
+// token, ids and the like never reach a pipeline.
+func pipelineBodies(query map[string][]string, caps []*capability) map[string]map[string]any {
+    list := query["pipelines"]
+    if len(list) == 0 {
+        return nil
+    }
+    bodies := map[string]map[string]any{}
+    for _, name := range parseTags(strings.Join(list, ",")) {
+        body := map[string]any{}
+        for _, cp := range caps {
+            if cp.Name != name {
+                continue
+            }
+            fields := []string{cp.Fanout.Field}
+            for field := range cp.Params {
+                fields = append(fields, field)
+            }
+            for _, field := range fields {
+                if v := query[field]; field != "" && len(v) > 0 {
+                    body[field] = v[0]
+                }
+            }
+        }
+        bodies[name] = body
+    }
+    return bodies
+}
+
+// transcriptPipelines runs the pipelines of one transcription and merges
+// their results into out for the socket writer. out is closed once every
+// pipeline finished after finish.
+type transcriptPipelines struct {
+    h    *Handler
+    list []*transcriptPipeline
+    out  chan wsPipeline
+    wg   sync.WaitGroup
+    // keepRefs records results as refs of the session.
+    keepRefs bool
+}
+
+func newTranscriptPipelines(h *Handler) *transcriptPipelines {
+    return &transcriptPipelines{h: h, out: make(chan wsPipeline, 32)}
+}
+
+// start launches the pipelines; with keepRefs, results are recorded as refs of the session.
+func (tp *transcriptPipelines) start(ctx context.Context, list []*transcriptPipeline, userID, sessionID string) {
+    tp.list = list
+    for _, p := range list {
+        for _, t := range p.targets {
+            log.Printf("[pipeline-start] %s mode=%s session=%s target=%s", p.cp.Name, p.cp.Pipeline, sessionID, t.name)
+        }
+        tp.wg.Add(1)
+        go func(p *transcriptPipeline) {
+            defer tp.wg.Done()
+            switch p.cp.Pipeline {
+            case pipelineSegment:
+                tp.runSegments(ctx, p, userID, sessionID)
+            case pipelineRolling:
+                tp.runRolling(ctx, p, userID, sessionID)
+            }
+        }(p)
+    }
+    go func() {
+        tp.wg.Wait()
+        close(tp.out)
+    }()
+}
+
+// feed queues a final sentence on every pipeline. A pipeline that fell too
+// far behind or whose stream ended skips it; the returned frames report that
+// to the client.
+func (tp *transcriptPipelines) feed(seg *pcas.SegmentInfo) []wsPipeline {
+    var skipped []wsPipeline
+    for _, p := range tp.list {
+        if f, ok := p.offer(seg); !ok {
+            skipped = append(skipped, f)
+        }
+    }
+    return skipped
+}
+
+// finish tells the pipelines that no more sentences follow.
+func (tp *transcriptPipelines) finish() {
+    for _, p := range tp.list {
+        close(p.segs)
+    }
+}
+
+func (tp *transcriptPipelines) emit(ctx context.Context, f wsPipeline) {
+    select {
+    case tp.out <- f:
+    case <-ctx.Done():
+    }
+}
+
+// pipelineFrame turns a runGeneric event into a pipeline frame.
+func pipelineFrame(name, segmentID, event string, data any) wsPipeline {
+    f := wsPipeline{Pipeline: name, Event: event, SegmentID: segmentID}
+    switch d := data.(type) {
+    case sseReadyData:
+        f.StreamID, f.Target = d.StreamID, d.Target
+    case sseDeltaData:
+        f.Text, f.Target = d.Text, d.Target
+    case sseDoneData:
+        f.StreamID, f.Target = d.StreamID, d.Target
+    case sseErrorData:
+        f.StreamID, f.Target, f.Code, f.Message = d.StreamID, d.Target, d.Code, d.Message
+    }
+    return f
+}
+
+// runSegments runs one stream per sentence (and target), up to
+// pipelineConcurrency sentences at a time, so every result carries the id of
+// its source segment. Frames are relayed in transcript order: those of a
+// sentence wait until every earlier sentence is done. The full text of a
+// sentence comes with its done frame.
+func (tp *transcriptPipelines) runSegments(ctx context.Context, p *transcriptPipeline, userID, sessionID string) {
+    // order holds the frames of each sentence in flight, oldest first
+    order := make(chan chan wsPipeline, pipelineConcurrency)
+    slots := make(chan struct{}, pipelineConcurrency)
+    relayed := make(chan struct{})
+    go func() {
+        defer close(relayed)
+        for frames := range order {
+            for f := range frames {
+                tp.emit(ctx, f)
+            }
+        }
+    }()
+    defer func() {
+        close(order)
+        <-relayed
+    }()
+    for {
+        var seg *pcas.SegmentInfo
+        select {
+        case next, ok := <-p.segs:
+            if !ok {
+                return
+            }
+            seg = next
+        case <-ctx.Done():
+            return
+        }
+        select {
+        case slots <- struct{}{}:
+        case <-ctx.Done():
+            return
+        }
+        frames := make(chan wsPipeline, pipelineQueue)
+        order <- frames
+        go func() {
+            defer func() { <-slots }()
+            defer close(frames)
+            tp.runSegment(ctx, p, seg, userID, sessionID, frames)
+        }()
+    }
+}
+
+// runSegment runs the streams of one sentence and sends their frames to out.
+func (tp *transcriptPipelines) runSegment(ctx context.Context, p *transcriptPipeline, seg *pcas.SegmentInfo, userID, sessionID string, out chan<- wsPipeline) {
+    in := make(chan []byte, 1)
+    in <- []byte(seg.Text)
+    close(in)
+    answers := map[string]*strings.Builder{}
+    runTargets(ctx, tp.h.pool, p.cp.EventType, p.targets, in, func(event string, data any) {
+        f := pipelineFrame(p.cp.Name, seg.ID, event, data)
+        switch event {
+        case sseReady:
+            answers[f.Target] = &strings.Builder{}
+        case sseDelta:
+            if b := answers[f.Target]; b != nil {
+                b.WriteString(f.Text)
+            }
+        case sseDone:
+            if b := answers[f.Target]; b != nil {
+                f.Text = b.String()
+            }
+            if p.cp.Output != "" && tp.keepRefs {
+                id := seg.ID
+                if f.Target != "" {
+                    id += "/" + f.Target
+                }
+                tp.h.refs.record(userID, sessionID, refItem{Kind: p.cp.Output, ID: id, Text: f.Text})
+            }
+        }
+        select {
+        case out <- f:
+        case <-ctx.Done():
+        }
+    })
+}
+
+// runRolling sends every sentence into one stream. Its frames carry the id of
+// the latest sentence sent when they were produced. Once the stream ended,
+// on its own or with an error, the sentences still queued and any fed later
+// are reported as skipped.
+func (tp *transcriptPipelines) runRolling(ctx context.Context, p *transcriptPipeline, userID, sessionID string) {
+    sctx, stop := context.WithCancel(ctx)
+    defer stop()
+    in := make(chan []byte, pipelineQueue)
+    var last atomic.Value
+    last.Store("")
+    forwarded := make(chan struct{})
+    go func() {
+        defer close(forwarded)
+        defer close(in)
+        for {
+            select {
+            case seg, ok := <-p.segs:
+                if !ok {
+                    return
+                }
+                last.Store(seg.ID)
+                select {
+                case in <- []byte(seg.Text):
+                case <-sctx.Done():
+                    return
+                }
+            case <-sctx.Done():
+                return
+            }
+        }
+    }()
+    observe := collectAnswer(func(streamID, _, answer string) {
+        if p.cp.Output != "" && tp.keepRefs {
+            tp.h.refs.record(userID, sessionID, refItem{Kind: p.cp.Output, ID: streamID, Text: answer})
+        }
+    })
+    code := "stream_ended"
+    runTargets(sctx, tp.h.pool, p.cp.EventType, p.targets, in, func(event string, data any) {
+        if d, ok := data.(sseErrorData); ok && event == sseError {
+            code = d.Code
+        }
+        observe(event, data)
+        tp.emit(ctx, pipelineFrame(p.cp.Name, last.Load().(string), event, data))
+    })
+
+    p.end(code)
+    stop()
+    <-forwarded
+    for {
+        select {
+        case seg, ok := <-p.segs:
+            if !ok {
+                return
+            }
+            tp.emit(ctx, p.skipped(seg, code, "pipeline stream has ended; sentence not sent"))
+        default:
+            return
+        }
+    }
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/url"
+    "reflect"
+    "testing"
+    "time"
+
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+    "google.golang.org/grpc"
+)
+
+// endingInteractServer opens every interact stream and ends it right away,
+// with ServerEnd or, for the event type "fail", a stream error.
+type endingInteractServer struct {
+    busv1.UnimplementedEventBusServiceServer
+}
+
+func (endingInteractServer) InteractStream(stream busv1.EventBusService_InteractStreamServer) error {
+    req, err := stream.Recv()
+    if err != nil {
+        return err
+    }
+    ready := &busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Ready{Ready: &busv1.StreamReady{StreamId: "pcas-1"}}}
+    if err := stream.Send(ready); err != nil {
+        return err
+    }
+    end := &busv1.InteractResponse{ResponseType: &busv1.InteractResponse_ServerEnd{ServerEnd: &busv1.StreamEnd{}}}
+    if req.GetConfig().GetEventType() == "fail" {
+        end = &busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Error{Error: &busv1.StreamError{Code: 500, Message: "boom"}}}
+    }
+    return stream.Send(end)
+}
+
+func endingPool(t *testing.T) *pcas.Pool {
+    t.Helper()
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := grpc.NewServer()
+    busv1.RegisterEventBusServiceServer(srv, endingInteractServer{})
+    go func() { _ = srv.Serve(lis) }()
+    t.Cleanup(srv.Stop)
+    pool, err := pcas.NewPool(lis.Addr().String(), pcas.PoolOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { _ = pool.Close() })
+    return pool
+}
+
+func TestRollingPipelineFeedAfterStreamEnded(t *testing.T) {
+    pool := endingPool(t)
+    one := []streamTarget{{attrs: map[string]string{}}}
+    // every stream of a fanout ends; the pipeline stops without waiting for the transcript
+    two := []streamTarget{{name: "ja", attrs: map[string]string{}}, {name: "en", attrs: map[string]string{}}}
+    cases := []struct {
+        name      string
+        eventType string
+        targets   []streamTarget
+        final     string
+        code      string
+    }{
+        {"done", "summarize", one, sseDone, "stream_ended"},
+        {"error", "fail", one, sseError, pcas.CodePCASError},
+        {"fanout done", "summarize", two, sseDone, "stream_ended"},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            h := &Handler{config: &config.Config{}, pool: pool}
+            p := &transcriptPipeline{
+                cp:      &capability{Name: "summarize", EventType: tc.eventType, Pipeline: pipelineRolling},
+                targets: tc.targets,
+                segs:    make(chan *pcas.SegmentInfo, pipelineQueue),
+            }
+            tp := newTranscriptPipelines(h)
+            ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+            defer cancel()
+            tp.start(ctx, []*transcriptPipeline{p}, "u", "s")
+
+            // out closes once the only pipeline is done
+            count := map[string]int{}
+            for f := range tp.out {
+                count[f.Event]++
+            }
+            if n := len(tc.targets); count[sseReady] != n || count[tc.final] != n || len(count) != 2 {
+                t.Fatalf("events = %v, want %d ready and %s", count, n, tc.final)
+            }
+
+            // more sentences than the queue holds: none is reported as overload
+            for i := 0; i < pipelineQueue+2; i++ {
+                skipped := tp.feed(&pcas.SegmentInfo{ID: "seg-x", Text: "后来的句子。"})
+                if len(skipped) != 1 || skipped[0].Code != tc.code || skipped[0].SegmentID != "seg-x" {
+                    t.Fatalf("feed %d after end = %+v, want code %s", i, skipped, tc.code)
+                }
+            }
+            tp.finish()
+        })
+    }
+}
+
+func TestPipelineBodies(t *testing.T) {
+    caps := []*capability{
+        newCapability(config.CapabilityConfig{
+            Name:   "translate",
+            Params: []config.CapabilityParam{{Field: "targetLang", Attr: "target_lang"}},
+            Fanout: config.CapabilityFanout{Field: "targetLangs", Attr: "target_lang"},
+        }),
+        newCapability(config.CapabilityConfig{
+            Name:   "summarize",
+            Params: []config.CapabilityParam{{Field: "mode", Attr: "mode"}},
+        }),
+    }
+    cases := []struct {
+        query string
+        want  map[string]map[string]any
+    }{
+        {"token=secret&sessionId=s1", nil},
+        {
+            "pipelines=translate,summarize&targetLang=ja&targetLangs=en,ja&mode=final&token=secret&sessionId=s1&userId=u1&course=bio&title=t",
+            map[string]map[string]any{
+                "translate": {"targetLang": "ja", "targetLangs": "en,ja"},
+                "summarize": {"mode": "final"},
+            },
+        },
+        // parameters of other capabilities stay with them
+        {"pipelines=summarize&targetLang=ja", map[string]map[string]any{"summarize": {}}},
+        // unknown names are kept for bindPipelines to reject
+        {"pipelines=keywords&token=secret", map[string]map[string]any{"keywords": {}}},
+    }
+    for _, tc := range cases {
+        query, err := url.ParseQuery(tc.query)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := pipelineBodies(query, caps); !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("pipelineBodies(%s) = %v, want %v", tc.query, got, tc.want)
+        }
+    }
+}
+
+func TestSegmentPipelineKeepsUpWithSpeech(t *testing.T) {
+    // every answer takes 30ms; sentences arrive every 8ms, faster than one
+    // stream at a time could answer them, for longer than the queue lasts
+    f := &echoInteractServer{delay: 30 * time.Millisecond}
+    h := &Handler{config: &config.Config{}, pool: echoPool(t, f)}
+    targets := []streamTarget{{name: "ja", attrs: map[string]string{"target_lang": "ja"}}, {name: "en", attrs: map[string]string{"target_lang": "en"}}}
+    p := &transcriptPipeline{
+        cp:      &capability{Name: "translate", EventType: "translate", Pipeline: pipelineSegment},
+        targets: targets,
+        segs:    make(chan *pcas.SegmentInfo, pipelineQueue),
+    }
+    tp := newTranscriptPipelines(h)
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+    defer cancel()
+    tp.start(ctx, []*transcriptPipeline{p}, "u", "s")
+
+    const n = 2*pipelineQueue - 8
+    go func() {
+        for i := 0; i < n; i++ {
+            seg := &pcas.SegmentInfo{ID: fmt.Sprintf("seg-%d", i), Text: fmt.Sprintf("第%d句。", i)}
+            for _, skipped := range tp.feed(seg) {
+                t.Errorf("%s skipped: %s", seg.ID, skipped.Code)
+            }
+            time.Sleep(8 * time.Millisecond)
+        }
+        tp.finish()
+    }()
+
+    // results arrive in transcript order, each sentence complete before the next
+    var done []string
+    current := -1
+    for f := range tp.out {
+        var i int
+        if _, err := fmt.Sscanf(f.SegmentID, "seg-%d", &i); err != nil {
+            t.Fatalf("frame %+v", f)
+        }
+        if i < current {
+            t.Fatalf("frame of %s after seg-%d", f.SegmentID, current)
+        }
+        current = i
+        switch f.Event {
+        case sseDone:
+            if want := fmt.Sprintf("第%d句。", i); f.Text != want {
+                t.Fatalf("%s/%s text = %q, want %q", f.SegmentID, f.Target, f.Text, want)
+            }
+            done = append(done, f.SegmentID+"/"+f.Target)
+        case sseError:
+            t.Fatalf("error frame %+v", f)
+        }
+    }
+    if len(done) != n*len(targets) {
+        t.Fatalf("%d results, want %d", len(done), n*len(targets))
+    }
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "unicode/utf8"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+// ragOptions is the rag field of a request: true, or an object narrowing
+// the memory search. Zero fields fall back to the search config; MinScore
+// never goes below search.minScore.
+type ragOptions struct {
+    TopK      int      `json:"topK"`
+    MinScore  float64  `json:"minScore"`
+    Course    string   `json:"course"`
+    SessionID string   `json:"sessionId"`
+    Tags      []string `json:"tags"`
+}
+
+// bindRAG reads the rag option; nil means no memory search.
+func (ch *capabilityHandler) bindRAG(c *gin.Context, cp *capability, body map[string]any) (*ragOptions, bool) {
+    raw, ok := body["rag"]
+    if !ok || raw == nil || raw == false || !cp.RAG {
+        return nil, true
+    }
+    o := &ragOptions{}
+    if raw != true {
+        b, _ := json.Marshal(raw)
+        if err := json.Unmarshal(b, o); err != nil || o.TopK < 0 || o.MinScore < 0 || o.MinScore > 1 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "invalid rag: expected true or {topK, minScore, course, sessionId, tags}", "code": "invalid_rag"}})
+            return nil, false
+        }
+    }
+    if o.TopK == 0 {
+        o.TopK = ch.cfg.Search.TopK
+    }
+    if o.TopK > ch.cfg.Search.MaxTopK {
+        o.TopK = ch.cfg.Search.MaxTopK
+    }
+    // clients may only raise the server threshold, as in /api/memories/search
+    if o.MinScore < ch.cfg.Search.MinScore {
+        o.MinScore = ch.cfg.Search.MinScore
+    }
+    return o, true
+}
+
+// memoryFilters maps search options onto the attributes MemoryEvent sets.
+func memoryFilters(course, sessionID string, tags []string) map[string]string {
+    filters := map[string]string{}
+    if course != "" {
+        filters["course"] = course
+    }
+    if sessionID != "" {
+        filters["session_id"] = sessionID
+    }
+    for _, t := range tags {
+        filters["tag:"+t] = "true"
+    }
+    return filters
+}
+
+// searchMemories returns the user's past memory events matching text, best
+// first, as many as fit the refs budget.
+func (ch *capabilityHandler) searchMemories(ctx context.Context, userID, text string, o *ragOptions) ([]refItem, error) {
+    ctx, cancel := context.WithTimeout(ctx, ch.cfg.Search.Timeout)
+    defer cancel()
+    gw := ch.pool.Gateway()
+    defer gw.Close()
+    hits, err := gw.Search(ctx, pcas.SearchQuery{
+        Text:    text,
+        TopK:    o.TopK,
+        UserID:  userID,
+        Filters: memoryFilters(o.Course, o.SessionID, o.Tags),
+    })
+    if err != nil {
+        return nil, err
+    }
+    // keep the best hits that fit the refs budget
+    var items []refItem
+    used := 0
+    for _, h := range hits {
+        // PCAS filters by user already; never cite another user's events
+        if float64(h.Score) < o.MinScore || h.Text == "" || (h.UserID != "" && h.UserID != userID) {
+            continue
+        }
+        if used += utf8.RuneCountInString(h.Text) + 32; ch.cfg.Chat.RefsChars > 0 && used > ch.cfg.Chat.RefsChars && len(items) > 0 {
+            break
+        }
+        items = append(items, refItem{Kind: refMemory, ID: h.EventID, Text: h.Text, At: h.Time, Score: h.Score})
+    }
+    return items, nil
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+)
+
+func TestRAGRequiresToken(t *testing.T) {
+    f := newFakeSearch(3)
+    cfg := &config.Config{Search: testSearchConfig}
+    cfg.User.ID = "default-user"
+    cfg.User.Tokens = []config.UserToken{{Token: "alice-token", ID: "alice"}}
+    h := &Handler{config: cfg}
+    ch := newCapabilityHandler(cfg, fakeSearchPool(t, f), newRefStore(0, 0, 0), h.resolveIdentity)
+    on := true
+    router := gin.New()
+    router.POST("/api/chat", ch.run(newCapability(config.CapabilityConfig{Name: "chat", Input: "message", RAG: &on})))
+
+    cases := []struct {
+        name   string
+        header string
+        value  string
+        query  string
+        want   int
+    }{
+        {"X-User-ID", "X-User-ID", "alice", "", http.StatusForbidden},
+        {"userId", "", "", "?userId=alice", http.StatusForbidden},
+        {"default user", "", "", "", http.StatusForbidden},
+        {"unknown token", "Authorization", "Bearer nope", "", http.StatusUnauthorized},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            w := httptest.NewRecorder()
+            req := httptest.NewRequest(http.MethodPost, "/api/chat"+tc.query, strings.NewReader(`{"message":"hello","rag":true}`))
+            req.Header.Set("Content-Type", "application/json")
+            if tc.header != "" {
+                req.Header.Set(tc.header, tc.value)
+            }
+            router.ServeHTTP(w, req)
+            if w.Code != tc.want {
+                t.Fatalf("status = %d, want %d: %s", w.Code, tc.want, w.Body.String())
+            }
+        })
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if len(f.reqs) != 0 {
+        t.Fatalf("PCAS searched %d times for anonymous callers", len(f.reqs))
+    }
+}
+
+func TestSearchMemoriesDropsOtherUsers(t *testing.T) {
+    // ev-1 and ev-3 belong to mallory
+    f := newFakeSearch(5, 1, 3)
+    cfg := &config.Config{Search: testSearchConfig}
+    ch := newCapabilityHandler(cfg, fakeSearchPool(t, f), newRefStore(0, 0, 0), nil)
+
+    items, err := ch.searchMemories(context.Background(), "alice", "x", &ragOptions{TopK: 5, MinScore: 0.6})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ids []string
+    for _, it := range items {
+        ids = append(ids, it.ID)
+    }
+    if want := []string{"ev-0", "ev-2", "ev-4"}; !reflect.DeepEqual(ids, want) {
+        t.Fatalf("items = %v, want %v", ids, want)
+    }
+    if got := f.last(t).GetUserId(); got != "alice" {
+        t.Fatalf("searched as %q", got)
+    }
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+// Kinds of items chat messages can cite. Capability outputs are recorded
+// under the kind set in their preset (translation, summary, ...); memory
+// items come from PCAS search.
+const (
+    refTranscript = "transcript"
+    refMemory     = "memory"
+)
+
+// refItem is one piece of session text a chat message may cite.
+type refItem struct {
+    Kind string
+    ID   string
+    Text string
+    At   time.Time
+    // Score is the search similarity of memory items.
+    Score float32
+}
+
+type refSession struct {
+    items   []refItem
+    updated time.Time
+}
+
+// refStore keeps the recent transcript segments and capability outputs of
+// each (user, session) so chat refs are resolved server-side.
+type refStore struct {
+    maxItems    int
+    maxSessions int
+    ttl         time.Duration
+    mu          sync.Mutex
+    sessions    map[string]*refSession
+}
+
+func newRefStore(maxItems, maxSessions int, ttl time.Duration) *refStore {
+    return &refStore{maxItems: maxItems, maxSessions: maxSessions, ttl: ttl, sessions: make(map[string]*refSession)}
+}
+
+// record appends an item, keeping at most maxItems per session and
+// maxSessions overall (least recently updated evicted first).
+func (s *refStore) record(userID, sessionID string, it refItem) {
+    if sessionID == "" || strings.TrimSpace(it.Text) == "" {
+        return
+    }
+    if it.At.IsZero() {
+        it.At = time.Now()
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    key := chatKey(userID, sessionID)
+    rs, ok := s.sessions[key]
+    if !ok || (s.ttl > 0 && time.Since(rs.updated) > s.ttl) {
+        rs = &refSession{}
+        s.sessions[key] = rs
+    }
+    rs.items = append(rs.items, it)
+    if n := len(rs.items) - s.maxItems; s.maxItems > 0 && n > 0 {
+        rs.items = append(rs.items[:0:0], rs.items[n:]...)
+    }
+    rs.updated = time.Now()
+
+    if s.maxSessions > 0 && len(s.sessions) > s.maxSessions {
+        var oldest string
+        var at time.Time
+        for k, v := range s.sessions {
+            if oldest == "" || v.updated.Before(at) {
+                oldest, at = k, v.updated
+            }
+        }
+        delete(s.sessions, oldest)
+    }
+}
+
+// items returns a snapshot of the session's items, oldest first.
+func (s *refStore) items(userID, sessionID string) []refItem {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    rs, ok := s.sessions[chatKey(userID, sessionID)]
+    if !ok || (s.ttl > 0 && time.Since(rs.updated) > s.ttl) {
+        return nil
+    }
+    return append([]refItem(nil), rs.items...)
+}
+
+// chatRef selects session items: one by id, or all of a kind within a time
+// range (from/to as unix ms or RFC 3339) or the last N seconds.
+type chatRef struct {
+    Type        string          `json:"type"`
+    ID          string          `json:"id"`
+    From        json.RawMessage `json:"from"`
+    To          json.RawMessage `json:"to"`
+    LastSeconds float64         `json:"lastSeconds"`
+}
+
+// citation is sent in the citations SSE event for each resolved item.
+type citation struct {
+    N    int    `json:"n"`
+    Type string `json:"type"`
+    ID   string `json:"id"`
+    At   int64  `json:"at"`
+    Text string `json:"text"`
+    // Score is set for memory search hits.
+    Score float32 `json:"score,omitempty"`
+}
+
+// parseRefTime accepts unix milliseconds (number or string) or RFC 3339.
+func parseRefTime(raw json.RawMessage) (time.Time, error) {
+    if len(raw) == 0 || string(raw) == "null" {
+        return time.Time{}, nil
+    }
+    var v any
+    if err := json.Unmarshal(raw, &v); err != nil {
+        return time.Time{}, err
+    }
+    switch x := v.(type) {
+    case float64:
+        return time.UnixMilli(int64(x)), nil
+    case string:
+        if ms, err := strconv.ParseInt(x, 10, 64); err == nil {
+            return time.UnixMilli(ms), nil
+        }
+        return time.Parse(time.RFC3339, x)
+    }
+    return time.Time{}, fmt.Errorf("unsupported time %s", raw)
+}
+
+// resolveRefs maps refs onto stored items of the session, in session order
+// and without duplicates. Problems are reported per ref as refs[i].
+func resolveRefs(items []refItem, refs []chatRef, now time.Time) ([]refItem, errInvalidAttrs) {
+    bad := errInvalidAttrs{}
+    picked := make([]bool, len(items))
+    for i, ref := range refs {
+        key := fmt.Sprintf("refs[%d]", i)
+        if ref.Type == "" {
+            bad[key] = "type is required"
+            continue
+        }
+        if ref.ID != "" {
+            found := -1
+            // the latest item wins when an id was reused, e.g. after a reconnect
+            for j := len(items) - 1; j >= 0; j-- {
+                if items[j].Kind == ref.Type && items[j].ID == ref.ID {
+                    found = j
+                    break
+                }
+            }
+            if found < 0 {
+                bad[key] = fmt.Sprintf("%s %s not found in this session", ref.Type, ref.ID)
+                continue
+            }
+            picked[found] = true
+            continue
+        }
+
+        from, err := parseRefTime(ref.From)
+        if err != nil {
+            bad[key] = "invalid from"
+            continue
+        }
+        to, err := parseRefTime(ref.To)
+        if err != nil {
+            bad[key] = "invalid to"
+            continue
+        }
+        if ref.LastSeconds > 0 {
+            from = now.Add(-time.Duration(ref.LastSeconds * float64(time.Second)))
+        }
+        if from.IsZero() && to.IsZero() {
+            bad[key] = "id, from/to or lastSeconds is required"
+            continue
+        }
+        for j, it := range items {
+            if it.Kind != ref.Type || (!from.IsZero() && it.At.Before(from)) || (!to.IsZero() && it.At.After(to)) {
+                continue
+            }
+            picked[j] = true
+        }
+    }
+    if len(bad) > 0 {
+        return nil, bad
+    }
+    var out []refItem
+    for j, ok := range picked {
+        if ok {
+            out = append(out, items[j])
+        }
+    }
+    return out, nil
+}
+
+// renderRefs numbers the items from first on for the prompt within budget
+// characters, keeping the latest ones when they do not all fit.
+func renderRefs(items []refItem, budget, first int) (string, []citation) {
+    start := 0
+    if budget > 0 {
+        used := 0
+        for start = len(items); start > 0; start-- {
+            n := utf8.RuneCountInString(items[start-1].Text) + 32
+            if used+n > budget {
+                break
+            }
+            used += n
+        }
+        // a single item larger than the budget is cut to its tail
+        if start == len(items) && start > 0 {
+            it := items[start-1]
+            r := []rune(it.Text)
+            if keep := budget - 32; keep > 0 && keep < len(r) {
+                it.Text = "…" + string(r[len(r)-keep:])
+            }
+            items = append(items[:start-1:start-1], it)
+            start--
+        }
+    }
+    var b strings.Builder
+    var cites []citation
+    for i, it := range items[start:] {
+        n := first + i
+        fmt.Fprintf(&b, "[%d] %s %s: %s\n", n, it.Kind, it.ID, it.Text)
+        cites = append(cites, citation{N: n, Type: it.Kind, ID: it.ID, At: it.At.UnixMilli(), Text: it.Text, Score: it.Score})
+    }
+    return strings.TrimRight(b.String(), "\n"), cites
+}
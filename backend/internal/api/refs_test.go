@@ -0,0 +1,219 @@
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+)
+
+func TestResolveRefs(t *testing.T) {
+    now := time.UnixMilli(1_760_000_100_000)
+    at := func(sec int) time.Time { return now.Add(time.Duration(sec) * time.Second) }
+    items := []refItem{
+        {Kind: refTranscript, ID: "seg-0", Text: "a", At: at(-100)},
+        {Kind: refTranscript, ID: "seg-1", Text: "b", At: at(-50)},
+        {Kind: "summary", ID: "sum-1", Text: "s", At: at(-40)},
+        {Kind: refTranscript, ID: "seg-2", Text: "c", At: at(-10)},
+        // a reconnect reused seg-0
+        {Kind: refTranscript, ID: "seg-0", Text: "d", At: at(-5)},
+    }
+    ms := func(sec int) string { return string(mustJSON(t, at(sec).UnixMilli())) }
+
+    cases := []struct {
+        name string
+        refs string
+        want []string
+        bad  map[string]string
+    }{
+        {"id", `[{"type":"transcript","id":"seg-1"}]`, []string{"b"}, nil},
+        {"reused id picks the latest", `[{"type":"transcript","id":"seg-0"}]`, []string{"d"}, nil},
+        {"ids in session order without duplicates", `[{"type":"summary","id":"sum-1"},{"type":"transcript","id":"seg-1"},{"type":"transcript","id":"seg-1"}]`, []string{"b", "s"}, nil},
+        {"from/to as unix ms", `[{"type":"transcript","from":` + ms(-60) + `,"to":` + ms(-10) + `}]`, []string{"b", "c"}, nil},
+        {"from as a ms string", `[{"type":"transcript","from":"` + ms(-20) + `"}]`, []string{"c", "d"}, nil},
+        {"to as RFC 3339", `[{"type":"transcript","to":"` + at(-50).UTC().Format(time.RFC3339) + `"}]`, []string{"a", "b"}, nil},
+        {"lastSeconds", `[{"type":"transcript","lastSeconds":45}]`, []string{"c", "d"}, nil},
+        {"lastSeconds of another kind", `[{"type":"summary","lastSeconds":45}]`, []string{"s"}, nil},
+        {"range without matches", `[{"type":"translation","lastSeconds":600}]`, nil, nil},
+        {"unknown id", `[{"type":"transcript","id":"seg-9"}]`, nil, map[string]string{"refs[0]": "transcript seg-9 not found in this session"}},
+        {"id of another kind", `[{"type":"summary","id":"seg-1"}]`, nil, map[string]string{"refs[0]": "summary seg-1 not found in this session"}},
+        {"missing type", `[{"type":"transcript","id":"seg-1"},{"id":"seg-1"}]`, nil, map[string]string{"refs[1]": "type is required"}},
+        {"no selector", `[{"type":"transcript"}]`, nil, map[string]string{"refs[0]": "id, from/to or lastSeconds is required"}},
+        {"malformed from", `[{"type":"transcript","from":"yesterday"}]`, nil, map[string]string{"refs[0]": "invalid from"}},
+        {"malformed to", `[{"type":"transcript","from":1,"to":true}]`, nil, map[string]string{"refs[0]": "invalid to"}},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            var refs []chatRef
+            if err := json.Unmarshal([]byte(tc.refs), &refs); err != nil {
+                t.Fatal(err)
+            }
+            got, bad := resolveRefs(items, refs, now)
+            if len(bad) > 0 || tc.bad != nil {
+                if !reflect.DeepEqual(map[string]string(bad), tc.bad) {
+                    t.Fatalf("bad = %v, want %v", bad, tc.bad)
+                }
+                return
+            }
+            var texts []string
+            for _, it := range got {
+                texts = append(texts, it.Text)
+            }
+            if !reflect.DeepEqual(texts, tc.want) {
+                t.Fatalf("items = %v, want %v", texts, tc.want)
+            }
+        })
+    }
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+    t.Helper()
+    b, err := json.Marshal(v)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return b
+}
+
+func TestRenderRefs(t *testing.T) {
+    at := time.UnixMilli(1_760_000_000_000)
+    items := []refItem{
+        {Kind: refTranscript, ID: "seg-0", Text: strings.Repeat("a", 10), At: at},
+        {Kind: refTranscript, ID: "seg-1", Text: strings.Repeat("b", 10), At: at},
+        {Kind: "summary", ID: "sum-1", Text: strings.Repeat("c", 10), At: at},
+    }
+    cases := []struct {
+        name   string
+        items  []refItem
+        budget int
+        first  int
+        want   string
+        ns     []int
+    }{
+        {"no budget", items, 0, 1, "[1] transcript seg-0: aaaaaaaaaa\n[2] transcript seg-1: bbbbbbbbbb\n[3] summary sum-1: cccccccccc", []int{1, 2, 3}},
+        {"numbered after earlier citations", items[:1], 0, 4, "[4] transcript seg-0: aaaaaaaaaa", []int{4}},
+        // each item costs its text plus 32
+        {"budget keeps the latest", items, 84, 1, "[1] transcript seg-1: bbbbbbbbbb\n[2] summary sum-1: cccccccccc", []int{1, 2}},
+        {"budget for all", items, 126, 1, "[1] transcript seg-0: aaaaaaaaaa\n[2] transcript seg-1: bbbbbbbbbb\n[3] summary sum-1: cccccccccc", []int{1, 2, 3}},
+        {"single item cut to its tail", []refItem{{Kind: refTranscript, ID: "seg-0", Text: "0123456789", At: at}}, 36, 1, "[1] transcript seg-0: …6789", []int{1}},
+        {"nothing to render", nil, 100, 1, "", nil},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            text, cites := renderRefs(tc.items, tc.budget, tc.first)
+            if text != tc.want {
+                t.Fatalf("text = %q, want %q", text, tc.want)
+            }
+            var ns []int
+            for _, c := range cites {
+                ns = append(ns, c.N)
+                if c.At != at.UnixMilli() {
+                    t.Errorf("citation %d at = %d", c.N, c.At)
+                }
+            }
+            if !reflect.DeepEqual(ns, tc.ns) {
+                t.Fatalf("citations = %v, want %v", ns, tc.ns)
+            }
+        })
+    }
+    // the original items are left alone when one is cut
+    long := []refItem{{Kind: refTranscript, ID: "seg-0", Text: "0123456789"}}
+    renderRefs(long, 36, 1)
+    if long[0].Text != "0123456789" {
+        t.Fatalf("item modified: %q", long[0].Text)
+    }
+}
+
+func TestRefStore(t *testing.T) {
+    s := newRefStore(3, 2, time.Minute)
+    for _, id := range []string{"seg-0", "seg-1", "seg-2", "seg-3"} {
+        s.record("alice", "s1", refItem{Kind: refTranscript, ID: id, Text: id})
+    }
+    // blank text and a missing session are not recorded
+    s.record("alice", "s1", refItem{Kind: refTranscript, ID: "seg-4", Text: "  "})
+    s.record("alice", "", refItem{Kind: refTranscript, ID: "seg-5", Text: "x"})
+    var ids []string
+    for _, it := range s.items("alice", "s1") {
+        ids = append(ids, it.ID)
+        if it.At.IsZero() {
+            t.Errorf("%s recorded without a time", it.ID)
+        }
+    }
+    if want := []string{"seg-1", "seg-2", "seg-3"}; !reflect.DeepEqual(ids, want) {
+        t.Fatalf("items = %v, want %v", ids, want)
+    }
+    if items := s.items("mallory", "s1"); items != nil {
+        t.Fatalf("another user's items = %v", items)
+    }
+
+    // least recently updated session is evicted beyond maxSessions
+    s.record("alice", "s2", refItem{Kind: refTranscript, ID: "seg-0", Text: "x"})
+    s.sessions[chatKey("alice", "s1")].updated = time.Now().Add(-time.Second)
+    s.record("alice", "s3", refItem{Kind: refTranscript, ID: "seg-0", Text: "x"})
+    if s.items("alice", "s1") != nil || s.items("alice", "s2") == nil {
+        t.Fatal("expected s1 evicted and s2 kept")
+    }
+
+    // expired sessions read as empty and start over
+    s.sessions[chatKey("alice", "s2")].updated = time.Now().Add(-2 * time.Minute)
+    if items := s.items("alice", "s2"); items != nil {
+        t.Fatalf("expired items = %v", items)
+    }
+    s.record("alice", "s2", refItem{Kind: refTranscript, ID: "seg-1", Text: "y"})
+    if items := s.items("alice", "s2"); len(items) != 1 || items[0].ID != "seg-1" {
+        t.Fatalf("items after expiry = %+v", items)
+    }
+}
+
+func TestRunRefs(t *testing.T) {
+    cfg := &config.Config{}
+    cfg.User.ID = "default-user"
+    cfg.User.Tokens = []config.UserToken{{Token: "alice-token", ID: "alice"}}
+    h := &Handler{config: cfg}
+    refs := newRefStore(0, 0, 0)
+    refs.record("alice", "s1", refItem{Kind: refTranscript, ID: "seg-0", Text: "光合作用发生在叶绿体中。"})
+    // no pool: every case must be answered before a stream opens
+    ch := newCapabilityHandler(cfg, nil, refs, h.resolveIdentity)
+    on := true
+    router := gin.New()
+    router.POST("/api/chat", ch.run(newCapability(config.CapabilityConfig{Name: "chat", Input: "message", Refs: &on})))
+
+    cases := []struct {
+        name   string
+        body   string
+        header string
+        value  string
+        want   int
+        code   string
+    }{
+        {"anonymous", `{"sessionId":"s1","message":"?","refs":[{"type":"transcript","id":"seg-0"}]}`, "X-User-ID", "alice", http.StatusForbidden, "auth_required"},
+        {"unknown id", `{"sessionId":"s1","message":"?","refs":[{"type":"transcript","id":"seg-9"}]}`, "Authorization", "Bearer alice-token", http.StatusBadRequest, "invalid_refs"},
+        {"malformed range", `{"sessionId":"s1","message":"?","refs":[{"type":"transcript","from":"soon"}]}`, "Authorization", "Bearer alice-token", http.StatusBadRequest, "invalid_refs"},
+        {"not a list", `{"sessionId":"s1","message":"?","refs":{"type":"transcript"}}`, "Authorization", "Bearer alice-token", http.StatusBadRequest, "invalid_refs"},
+        {"without sessionId", `{"message":"?","refs":[{"type":"transcript","id":"seg-0"}]}`, "Authorization", "Bearer alice-token", http.StatusBadRequest, "invalid_refs"},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            w := httptest.NewRecorder()
+            req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(tc.body))
+            req.Header.Set("Content-Type", "application/json")
+            req.Header.Set(tc.header, tc.value)
+            router.ServeHTTP(w, req)
+            var resp struct {
+                Error struct {
+                    Code string `json:"code"`
+                } `json:"error"`
+            }
+            _ = json.Unmarshal(w.Body.Bytes(), &resp)
+            if w.Code != tc.want || resp.Error.Code != tc.code {
+                t.Fatalf("status = %d code %q, want %d %q: %s", w.Code, resp.Error.Code, tc.want, tc.code, w.Body.String())
+            }
+        })
+    }
+}
@@ -0,0 +1,403 @@
+package api
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+)
+
+// capability declares one PCAS streaming capability. Routes, request parsing
+// and default attributes are all derived from it; the registry is built from
+// the capabilities presets in config.
+type capability struct {
+    Name      string
+    EventType string
+    // System is a prompt template rendered against the stream attributes
+    // and Vars; it and Model are sent unless the client overrides them.
+    System string
+    Model  string
+    Vars   map[string]string
+    // Input is the JSON field holding the text of a one-shot request.
+    Input string
+    // Params maps optional string fields of the request body to stream attributes.
+    Params map[string]string
+    // Attrs are the rules for attributes a client may set, keyed by name;
+    // "*" applies to attributes without a rule of their own.
+    Attrs map[string]*attrRule
+    // Streaming adds /api/<name>/start and /api/<name>/stream for incremental input.
+    Streaming bool
+    // RunPath overrides the one-shot route, /api/<name>/run by default.
+    RunPath string
+    // History sends earlier turns of the request's sessionId with each message.
+    History bool
+    // Refs resolves request refs against the session's transcript and outputs.
+    Refs bool
+    // Output is the ref type answers are recorded under; empty records nothing.
+    Output string
+    // RAG allows the rag option: past memory events matching the message are sent along.
+    RAG bool
+    // Pipeline is how a transcription may feed sentences in (segment|rolling).
+    Pipeline string
+    // Fanout runs a stream per value of a request list field (Field empty: off).
+    Fanout config.CapabilityFanout
+}
+
+func newCapability(cc config.CapabilityConfig) *capability {
+    cp := &capability{
+        Name:      cc.Name,
+        EventType: cc.EventType,
+        System:    cc.System,
+        Model:     cc.Model,
+        Vars:      cc.Vars,
+        Input:     cc.Input,
+        Params:    make(map[string]string, len(cc.Params)),
+        Attrs:     make(map[string]*attrRule, len(cc.Attrs)),
+        Streaming: config.Enabled(cc.Streaming),
+        RunPath:   cc.Path,
+        History:   config.Enabled(cc.History),
+        Refs:      config.Enabled(cc.Refs),
+        Output:    cc.Output,
+        RAG:       config.Enabled(cc.RAG),
+        Pipeline:  cc.Pipeline,
+        Fanout:    cc.Fanout,
+    }
+    for _, p := range cc.Params {
+        cp.Params[p.Field] = p.Attr
+    }
+    for _, a := range cc.Attrs {
+        cp.Attrs[a.Name] = newAttrRule(a)
+    }
+    return cp
+}
+
+// capabilitiesFrom builds the registry from the configured presets.
+func capabilitiesFrom(cfg *config.Config) []*capability {
+    caps := make([]*capability, 0, len(cfg.Capabilities))
+    for _, cc := range cfg.Capabilities {
+        caps = append(caps, newCapability(cc))
+    }
+    return caps
+}
+
+func (cp *capability) runPath() string {
+    if cp.RunPath != "" {
+        return cp.RunPath
+    }
+    return "/api/" + cp.Name + "/run"
+}
+
+// errInvalidAttrs lists the request fields that failed validation.
+type errInvalidAttrs map[string]string
+
+func (e errInvalidAttrs) keys() []string {
+    keys := make([]string, 0, len(e))
+    for k := range e {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func (e errInvalidAttrs) Error() string {
+    return "invalid attrs: " + strings.Join(e.keys(), ", ")
+}
+
+// rule returns the schema for attribute name, or nil when clients may not set it.
+func (cp *capability) rule(name string) *attrRule {
+    if r, ok := cp.Attrs[name]; ok {
+        return r
+    }
+    return cp.Attrs["*"]
+}
+
+// historyPath is where the chat history routes of cp live.
+func (cp *capability) historyPath() string {
+    return cp.runPath() + "/history"
+}
+
+// attributes maps the request body onto PCAS stream attributes: mapped params
+// first, then the client's attrs, then the capability defaults. Values are
+// checked against the capability's attr rules; every failing field is
+// reported in the returned errInvalidAttrs.
+func (cp *capability) attributes(body map[string]any) (map[string]string, error) {
+    attrs := map[string]string{}
+    bad := errInvalidAttrs{}
+    for field, attr := range cp.Params {
+        v, ok := body[field]
+        if !ok || v == nil || v == "" {
+            continue
+        }
+        r := cp.Attrs[attr]
+        if r == nil {
+            r = &attrRule{typ: "string"}
+        }
+        s, err := r.check(v)
+        if err != nil {
+            bad[field] = err.Error()
+            continue
+        }
+        attrs[attr] = s
+    }
+    if raw, ok := body["attrs"]; ok && raw != nil {
+        m, ok := raw.(map[string]any)
+        if !ok {
+            return nil, errInvalidAttrs{"attrs": "must be an object"}
+        }
+        for k, v := range m {
+            r := cp.rule(k)
+            if r == nil {
+                bad["attrs."+k] = "not allowed"
+                continue
+            }
+            s, err := r.check(v)
+            if err != nil {
+                bad["attrs."+k] = err.Error()
+                continue
+            }
+            attrs[k] = s
+        }
+    }
+    if len(bad) > 0 {
+        return nil, bad
+    }
+    if _, ok := attrs["system"]; !ok && cp.System != "" {
+        attrs["system"] = cp.render(cp.System, attrs)
+    }
+    if _, ok := attrs["model"]; !ok && cp.Model != "" {
+        attrs["model"] = cp.Model
+    }
+    return attrs, nil
+}
+
+// fanout splits a request into one stream per value of the fanout field, each
+// with the attributes it would get with that value in attrs. Without the
+// field the request runs as a single untagged stream with attrs.
+func (cp *capability) fanout(body map[string]any, attrs map[string]string) ([]streamTarget, error) {
+    field := cp.Fanout.Field
+    raw, ok := body[field]
+    if field == "" || !ok || raw == nil {
+        return []streamTarget{{attrs: attrs}}, nil
+    }
+    list, ok := raw.([]any)
+    if !ok || len(list) == 0 {
+        return nil, errInvalidAttrs{field: "must be a non-empty list"}
+    }
+    if len(list) > cp.Fanout.Max {
+        return nil, errInvalidAttrs{field: fmt.Sprintf("at most %d values", cp.Fanout.Max)}
+    }
+    var targets []streamTarget
+    seen := map[string]bool{}
+    for i, v := range list {
+        key := fmt.Sprintf("%s[%d]", field, i)
+        s, ok := v.(string)
+        if !ok || s == "" {
+            return nil, errInvalidAttrs{key: "must be a non-empty string"}
+        }
+        if seen[s] {
+            continue
+        }
+        seen[s] = true
+        b := make(map[string]any, len(body))
+        for k, v := range body {
+            b[k] = v
+        }
+        a := map[string]any{}
+        if m, ok := body["attrs"].(map[string]any); ok {
+            for k, v := range m {
+                a[k] = v
+            }
+        }
+        a[cp.Fanout.Attr] = s
+        b["attrs"] = a
+        ta, err := cp.attributes(b)
+        if bad, ok := err.(errInvalidAttrs); ok {
+            if msg, ok := bad["attrs."+cp.Fanout.Attr]; ok {
+                delete(bad, "attrs."+cp.Fanout.Attr)
+                bad[key] = msg
+            }
+            return nil, bad
+        }
+        targets = append(targets, streamTarget{name: s, attrs: ta})
+    }
+    return targets, nil
+}
+
+// attrRule validates one client-supplied attribute and converts it to the
+// string form PCAS stream attributes use.
+type attrRule struct {
+    typ       string
+    maxLength int
+    values    []string
+}
+
+func newAttrRule(a config.CapabilityAttr) *attrRule {
+    return &attrRule{typ: a.Type, maxLength: a.MaxLength, values: a.Values}
+}
+
+func (r *attrRule) check(v any) (string, error) {
+    var s string
+    switch r.typ {
+    case "int":
+        switch x := v.(type) {
+        case float64:
+            if x != math.Trunc(x) {
+                return "", fmt.Errorf("must be an integer")
+            }
+            s = strconv.FormatInt(int64(x), 10)
+        case string:
+            if _, err := strconv.ParseInt(x, 10, 64); err != nil {
+                return "", fmt.Errorf("must be an integer")
+            }
+            s = x
+        default:
+            return "", fmt.Errorf("must be an integer")
+        }
+    case "number":
+        switch x := v.(type) {
+        case float64:
+            s = strconv.FormatFloat(x, 'f', -1, 64)
+        case string:
+            if _, err := strconv.ParseFloat(x, 64); err != nil {
+                return "", fmt.Errorf("must be a number")
+            }
+            s = x
+        default:
+            return "", fmt.Errorf("must be a number")
+        }
+    case "bool":
+        switch x := v.(type) {
+        case bool:
+            s = strconv.FormatBool(x)
+        case string:
+            if _, err := strconv.ParseBool(x); err != nil {
+                return "", fmt.Errorf("must be a boolean")
+            }
+            s = x
+        default:
+            return "", fmt.Errorf("must be a boolean")
+        }
+    default:
+        x, ok := v.(string)
+        if !ok {
+            return "", fmt.Errorf("must be a string")
+        }
+        s = x
+    }
+    if r.maxLength > 0 && utf8.RuneCountInString(s) > r.maxLength {
+        return "", fmt.Errorf("longer than %d characters", r.maxLength)
+    }
+    if len(r.values) > 0 {
+        for _, allowed := range r.values {
+            if s == allowed {
+                return s, nil
+            }
+        }
+        return "", fmt.Errorf("must be one of %s", strings.Join(r.values, ", "))
+    }
+    return s, nil
+}
+
+var templateVar = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// render replaces {{var}} in tmpl with the attribute var, falling back to
+// Vars; unknown variables become empty.
+func (cp *capability) render(tmpl string, attrs map[string]string) string {
+    return templateVar.ReplaceAllStringFunc(tmpl, func(m string) string {
+        name := templateVar.FindStringSubmatch(m)[1]
+        if v, ok := attrs[name]; ok {
+            return v
+        }
+        return cp.Vars[name]
+    })
+}
+
+// input returns the text of a one-shot request.
+func (cp *capability) input(body map[string]any) string {
+    s, _ := body[cp.Input].(string)
+    return s
+}
+
+// capabilityInfo describes a preset for GET /api/capabilities.
+type capabilityInfo struct {
+    Name      string            `json:"name"`
+    EventType string            `json:"eventType"`
+    Model     string            `json:"model,omitempty"`
+    System    string            `json:"system,omitempty"`
+    Vars      map[string]string `json:"vars,omitempty"`
+    Input     string            `json:"input"`
+    Params    map[string]string `json:"params,omitempty"`
+    Attrs     []attrInfo        `json:"attrs"`
+    Routes    map[string]string `json:"routes"`
+    History   bool              `json:"history,omitempty"`
+    Refs      bool              `json:"refs,omitempty"`
+    Output    string            `json:"output,omitempty"`
+    RAG       bool              `json:"rag,omitempty"`
+    Pipeline  string            `json:"pipeline,omitempty"`
+    Fanout    *fanoutInfo       `json:"fanout,omitempty"`
+}
+
+type fanoutInfo struct {
+    Field string `json:"field"`
+    Attr  string `json:"attr"`
+    Max   int    `json:"max"`
+}
+
+type attrInfo struct {
+    Name      string   `json:"name"`
+    Type      string   `json:"type"`
+    MaxLength int      `json:"maxLength,omitempty"`
+    Values    []string `json:"values,omitempty"`
+}
+
+func (cp *capability) info() capabilityInfo {
+    info := capabilityInfo{
+        Name:      cp.Name,
+        EventType: cp.EventType,
+        Model:     cp.Model,
+        System:    cp.System,
+        Vars:      cp.Vars,
+        Input:     cp.Input,
+        Params:    cp.Params,
+        Attrs:     []attrInfo{},
+        Routes:    map[string]string{"run": cp.runPath()},
+        History:   cp.History,
+        Refs:      cp.Refs,
+        Output:    cp.Output,
+        RAG:       cp.RAG,
+        Pipeline:  cp.Pipeline,
+    }
+    for name, r := range cp.Attrs {
+        info.Attrs = append(info.Attrs, attrInfo{Name: name, Type: r.typ, MaxLength: r.maxLength, Values: r.values})
+    }
+    sort.Slice(info.Attrs, func(i, j int) bool { return info.Attrs[i].Name < info.Attrs[j].Name })
+    if cp.Streaming {
+        info.Routes["start"] = "/api/" + cp.Name + "/start"
+        info.Routes["stream"] = "/api/" + cp.Name + "/stream"
+    }
+    if cp.History {
+        info.Routes["history"] = cp.historyPath()
+    }
+    if cp.Fanout.Field != "" {
+        info.Fanout = &fanoutInfo{Field: cp.Fanout.Field, Attr: cp.Fanout.Attr, Max: cp.Fanout.Max}
+    }
+    return info
+}
+
+// listCapabilities serves the registry so clients can discover presets.
+func listCapabilities(caps []*capability) gin.HandlerFunc {
+    infos := make([]capabilityInfo, 0, len(caps))
+    for _, cp := range caps {
+        infos = append(infos, cp.info())
+    }
+    return func(c *gin.Context) {
+        c.JSON(http.StatusOK, gin.H{"capabilities": infos})
+    }
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+)
+
+func TestAttrRuleCheck(t *testing.T) {
+    cases := []struct {
+        name string
+        rule attrRule
+        in   any
+        want string
+        err  string
+    }{
+        {"string", attrRule{typ: "string"}, "ja", "ja", ""},
+        {"string from number", attrRule{typ: "string"}, 3.0, "", "must be a string"},
+        {"int", attrRule{typ: "int"}, 3.0, "3", ""},
+        {"int from string", attrRule{typ: "int"}, "42", "42", ""},
+        {"int from fraction", attrRule{typ: "int"}, 3.5, "", "must be an integer"},
+        {"int from bool", attrRule{typ: "int"}, true, "", "must be an integer"},
+        {"number", attrRule{typ: "number"}, 0.25, "0.25", ""},
+        {"number from text", attrRule{typ: "number"}, "warm", "", "must be a number"},
+        {"bool", attrRule{typ: "bool"}, false, "false", ""},
+        {"bool from string", attrRule{typ: "bool"}, "true", "true", ""},
+        {"bool from number", attrRule{typ: "bool"}, 1.0, "", "must be a boolean"},
+        {"maxLength in runes", attrRule{typ: "string", maxLength: 3}, "日本語", "日本語", ""},
+        {"maxLength exceeded", attrRule{typ: "string", maxLength: 3}, "日本語だ", "", "longer than 3 characters"},
+        {"maxLength on converted value", attrRule{typ: "int", maxLength: 2}, 100.0, "", "longer than 2 characters"},
+        {"allowed value", attrRule{typ: "string", values: []string{"ja", "en"}}, "en", "en", ""},
+        {"value not allowed", attrRule{typ: "string", values: []string{"ja", "en"}}, "de", "", "must be one of ja, en"},
+        {"values after conversion", attrRule{typ: "int", values: []string{"1", "2"}}, 2.0, "2", ""},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            got, err := tc.rule.check(tc.in)
+            if tc.err != "" {
+                if err == nil || err.Error() != tc.err {
+                    t.Fatalf("check(%v) error = %v, want %q", tc.in, err, tc.err)
+                }
+                return
+            }
+            if err != nil || got != tc.want {
+                t.Fatalf("check(%v) = %q, %v; want %q", tc.in, got, err, tc.want)
+            }
+        })
+    }
+}
+
+// testCapability declares a model allow-list, a typed temperature and a
+// mapped targetLang param; withWildcard adds a "*" rule for other attributes.
+func testCapability(withWildcard bool) *capability {
+    cp := &capability{
+        Name:   "translate",
+        System: "Translate into {{target_lang}}.",
+        Model:  "gpt-5-mini",
+        Params: map[string]string{"targetLang": "target_lang"},
+        Attrs: map[string]*attrRule{
+            "model":       {typ: "string", values: []string{"gpt-5", "gpt-5-mini"}},
+            "temperature": {typ: "number"},
+            "target_lang": {typ: "string", maxLength: 8},
+        },
+    }
+    if withWildcard {
+        cp.Attrs["*"] = &attrRule{typ: "string", maxLength: 4}
+    }
+    return cp
+}
+
+func TestCapabilityAttributes(t *testing.T) {
+    cases := []struct {
+        name     string
+        wildcard bool
+        body     string
+        want     map[string]string
+        bad      errInvalidAttrs
+    }{
+        {"defaults", false, `{"text":"hi"}`,
+            map[string]string{"system": "Translate into .", "model": "gpt-5-mini"}, nil},
+        {"param and attrs", false, `{"targetLang":"ja","attrs":{"model":"gpt-5","temperature":0.2}}`,
+            map[string]string{"target_lang": "ja", "model": "gpt-5", "temperature": "0.2", "system": "Translate into ja."}, nil},
+        {"undeclared key", false, `{"attrs":{"tone":"formal"}}`,
+            nil, errInvalidAttrs{"attrs.tone": "not allowed"}},
+        {"wildcard allows undeclared key", true, `{"attrs":{"tone":"dry"}}`,
+            map[string]string{"tone": "dry", "system": "Translate into .", "model": "gpt-5-mini"}, nil},
+        {"wildcard rule applies", true, `{"attrs":{"tone":"formal"}}`,
+            nil, errInvalidAttrs{"attrs.tone": "longer than 4 characters"}},
+        {"declared rule wins over wildcard", true, `{"attrs":{"temperature":"hot"}}`,
+            nil, errInvalidAttrs{"attrs.temperature": "must be a number"}},
+        {"type mismatch", false, `{"attrs":{"temperature":true}}`,
+            nil, errInvalidAttrs{"attrs.temperature": "must be a number"}},
+        {"value not allowed", false, `{"attrs":{"model":"gpt-4"}}`,
+            nil, errInvalidAttrs{"attrs.model": "must be one of gpt-5, gpt-5-mini"}},
+        {"param maxLength reported under its field", false, `{"targetLang":"japanese-formal"}`,
+            nil, errInvalidAttrs{"targetLang": "longer than 8 characters"}},
+        {"attrs not an object", false, `{"attrs":["model"]}`,
+            nil, errInvalidAttrs{"attrs": "must be an object"}},
+        {"every failure reported", false, `{"targetLang":"japanese-formal","attrs":{"model":"gpt-4","tone":"x","temperature":"hot"}}`,
+            nil, errInvalidAttrs{
+                "targetLang":        "longer than 8 characters",
+                "attrs.model":       "must be one of gpt-5, gpt-5-mini",
+                "attrs.tone":        "not allowed",
+                "attrs.temperature": "must be a number",
+            }},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            var body map[string]any
+            if err := json.Unmarshal([]byte(tc.body), &body); err != nil {
+                t.Fatal(err)
+            }
+            got, err := testCapability(tc.wildcard).attributes(body)
+            if tc.bad != nil {
+                if !reflect.DeepEqual(err, tc.bad) {
+                    t.Fatalf("error = %#v, want %#v", err, tc.bad)
+                }
+                return
+            }
+            if err != nil || !reflect.DeepEqual(got, tc.want) {
+                t.Fatalf("attributes = %v, %v; want %v", got, err, tc.want)
+            }
+        })
+    }
+}
+
+func TestBindCapabilityInvalidAttrsBody(t *testing.T) {
+    w := httptest.NewRecorder()
+    c, _ := gin.CreateTestContext(w)
+    body := `{"targetLang":"japanese-formal","attrs":{"tone":"x","model":"gpt-4","temperature":"hot"}}`
+    c.Request = httptest.NewRequest(http.MethodPost, "/api/translate/run", strings.NewReader(body))
+    c.Request.Header.Set("Content-Type", "application/json")
+
+    if _, _, ok := bindCapability(c, testCapability(false)); ok {
+        t.Fatal("invalid attrs accepted")
+    }
+    if w.Code != http.StatusBadRequest {
+        t.Fatalf("status = %d, want 400", w.Code)
+    }
+    var resp struct {
+        Error struct {
+            Code    string            `json:"code"`
+            Message string            `json:"message"`
+            Keys    []string          `json:"keys"`
+            Fields  map[string]string `json:"fields"`
+        } `json:"error"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+        t.Fatal(err)
+    }
+    // keys are sorted so clients and logs see a stable order
+    wantKeys := []string{"attrs.model", "attrs.temperature", "attrs.tone", "targetLang"}
+    if resp.Error.Code != "invalid_attrs" || !reflect.DeepEqual(resp.Error.Keys, wantKeys) {
+        t.Fatalf("code %q keys %v, want invalid_attrs %v", resp.Error.Code, resp.Error.Keys, wantKeys)
+    }
+    if want := "invalid attrs: " + strings.Join(wantKeys, ", "); resp.Error.Message != want {
+        t.Fatalf("message = %q, want %q", resp.Error.Message, want)
+    }
+    if len(resp.Error.Fields) != len(wantKeys) || resp.Error.Fields["attrs.tone"] != "not allowed" {
+        t.Fatalf("fields = %v", resp.Error.Fields)
+    }
+    // the fields object is encoded with its keys in the same order
+    raw := w.Body.String()
+    last := -1
+    for _, k := range wantKeys {
+        i := strings.Index(raw, `"`+k+`":"`)
+        if i < last {
+            t.Fatalf("fields not in key order: %s", raw)
+        }
+        last = i
+    }
+}
+
+func TestCapabilityFanout(t *testing.T) {
+    type target struct {
+        name  string
+        attrs map[string]string
+    }
+    cases := []struct {
+        name string
+        body string
+        want []target
+        bad  errInvalidAttrs
+    }{
+        {"no fanout field", `{"targetLang":"ja"}`,
+            []target{{"", map[string]string{"target_lang": "ja", "system": "Translate into ja.", "model": "gpt-5-mini"}}}, nil},
+        {"one stream per value", `{"targetLangs":["ja","en"]}`,
+            []target{
+                {"ja", map[string]string{"target_lang": "ja", "system": "Translate into ja.", "model": "gpt-5-mini"}},
+                {"en", map[string]string{"target_lang": "en", "system": "Translate into en.", "model": "gpt-5-mini"}},
+            }, nil},
+        {"duplicates run once", `{"targetLangs":["ja","en","ja"]}`,
+            []target{
+                {"ja", map[string]string{"target_lang": "ja", "system": "Translate into ja.", "model": "gpt-5-mini"}},
+                {"en", map[string]string{"target_lang": "en", "system": "Translate into en.", "model": "gpt-5-mini"}},
+            }, nil},
+        // shared attrs go to every stream; the fanout value wins over the
+        // client's own target_lang and the mapped param
+        {"per-target attrs", `{"targetLang":"de","targetLangs":["ja","en"],"attrs":{"model":"gpt-5","target_lang":"fr"}}`,
+            []target{
+                {"ja", map[string]string{"target_lang": "ja", "system": "Translate into ja.", "model": "gpt-5"}},
+                {"en", map[string]string{"target_lang": "en", "system": "Translate into en.", "model": "gpt-5"}},
+            }, nil},
+        {"not a list", `{"targetLangs":"ja,en"}`, nil, errInvalidAttrs{"targetLangs": "must be a non-empty list"}},
+        {"empty list", `{"targetLangs":[]}`, nil, errInvalidAttrs{"targetLangs": "must be a non-empty list"}},
+        {"more than max", `{"targetLangs":["ja","en","de","fr"]}`, nil, errInvalidAttrs{"targetLangs": "at most 3 values"}},
+        {"non-string value", `{"targetLangs":["ja",7]}`, nil, errInvalidAttrs{"targetLangs[1]": "must be a non-empty string"}},
+        {"empty value", `{"targetLangs":["","ja"]}`, nil, errInvalidAttrs{"targetLangs[0]": "must be a non-empty string"}},
+        {"value rule reported under its index", `{"targetLangs":["ja","japanese-formal"]}`, nil, errInvalidAttrs{"targetLangs[1]": "longer than 8 characters"}},
+        {"other attrs still checked", `{"targetLangs":["ja"],"attrs":{"model":"gpt-4"}}`, nil, errInvalidAttrs{"attrs.model": "must be one of gpt-5, gpt-5-mini"}},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            cp := testCapability(false)
+            cp.Fanout.Field, cp.Fanout.Attr, cp.Fanout.Max = "targetLangs", "target_lang", 3
+            var body map[string]any
+            if err := json.Unmarshal([]byte(tc.body), &body); err != nil {
+                t.Fatal(err)
+            }
+            attrs, err := cp.attributes(body)
+            if err == nil {
+                var targets []streamTarget
+                targets, err = cp.fanout(body, attrs)
+                if err == nil {
+                    var got []target
+                    for _, st := range targets {
+                        got = append(got, target{st.name, st.attrs})
+                    }
+                    if tc.bad != nil || !reflect.DeepEqual(got, tc.want) {
+                        t.Fatalf("targets = %+v, want %+v", got, tc.want)
+                    }
+                    return
+                }
+            }
+            if !reflect.DeepEqual(err, tc.bad) {
+                t.Fatalf("error = %#v, want %#v", err, tc.bad)
+            }
+        })
+    }
+}
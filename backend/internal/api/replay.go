@@ -0,0 +1,87 @@
+package api
+
+import (
+    "strconv"
+    "sync"
+
+    "github.com/gin-gonic/gin"
+)
+
+// sseEvent is one numbered event of a capability stream. IDs start at 1 and
+// are sent as the SSE id: field; Data is the JSON payload.
+type sseEvent struct {
+    ID    uint64
+    Event string
+    Data  []byte
+}
+
+// replayBuffer keeps the most recent events of a session so any number of SSE
+// subscribers can follow it independently and reconnect with Last-Event-ID.
+type replayBuffer struct {
+    mu     sync.Mutex
+    events []sseEvent
+    limit  int
+    lastID uint64
+    closed bool
+    // wake is closed (and replaced) whenever an event arrives or the buffer closes.
+    wake chan struct{}
+}
+
+func newReplayBuffer(limit int) *replayBuffer {
+    if limit <= 0 {
+        limit = 256
+    }
+    return &replayBuffer{limit: limit, wake: make(chan struct{})}
+}
+
+// append stores an event under the next id, evicting the oldest one when full.
+func (r *replayBuffer) append(event string, data []byte) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.lastID++
+    r.events = append(r.events, sseEvent{ID: r.lastID, Event: event, Data: data})
+    if len(r.events) > r.limit {
+        r.events = append(r.events[:0:0], r.events[len(r.events)-r.limit:]...)
+    }
+    close(r.wake)
+    r.wake = make(chan struct{})
+}
+
+// close marks the end of the stream; subscribers finish after draining.
+func (r *replayBuffer) close() {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if !r.closed {
+        r.closed = true
+        close(r.wake)
+    }
+}
+
+// since returns the retained events after id, whether the stream has ended,
+// and a channel that is closed on the next change. An id of 0 starts at the
+// oldest retained event. Otherwise gap reports that events right after id
+// were already evicted, so events does not continue from id.
+func (r *replayBuffer) since(id uint64) (events []sseEvent, gap, done bool, wake <-chan struct{}) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    i := len(r.events)
+    for i > 0 && r.events[i-1].ID > id {
+        i--
+    }
+    gap = id > 0 && i == 0 && len(r.events) > 0 && r.events[0].ID > id+1
+    events = make([]sseEvent, len(r.events)-i)
+    copy(events, r.events[i:])
+    return events, gap, r.closed, r.wake
+}
+
+// lastEventID reads the resume point of a reconnecting subscriber from the
+// Last-Event-ID header, or ?lastEventId= for clients that cannot set headers.
+// A new subscriber sends neither and gets 0.
+func lastEventID(c *gin.Context) uint64 {
+    v := c.GetHeader("Last-Event-ID")
+    if v == "" {
+        v = c.Query("lastEventId")
+    }
+    id, _ := strconv.ParseUint(v, 10, 64)
+    return id
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+)
+
+func TestReplayBufferSince(t *testing.T) {
+    rb := newReplayBuffer(3)
+    for i := 0; i < 5; i++ {
+        rb.append(sseDelta, []byte(`{}`))
+    }
+    // retained: 3, 4, 5
+    cases := []struct {
+        id    uint64
+        first uint64
+        n     int
+        gap   bool
+    }{
+        {0, 3, 3, false},
+        {1, 3, 3, true},
+        {2, 3, 3, false},
+        {4, 5, 1, false},
+        {5, 0, 0, false},
+    }
+    for _, tc := range cases {
+        events, gap, _, _ := rb.since(tc.id)
+        if gap != tc.gap || len(events) != tc.n || (tc.n > 0 && events[0].ID != tc.first) {
+            t.Errorf("since(%d) = %d events from %v, gap %v; want %d from %d, gap %v", tc.id, len(events), events, gap, tc.n, tc.first, tc.gap)
+        }
+    }
+}
+
+// followBody runs followSSE on a closed rb from last and returns the body.
+func followBody(t *testing.T, rb *replayBuffer, last uint64) (string, bool) {
+    t.Helper()
+    w := httptest.NewRecorder()
+    c, _ := gin.CreateTestContext(w)
+    c.Request = httptest.NewRequest(http.MethodGet, "/api/translate/stream", nil).WithContext(context.Background())
+    ok := followSSE(c, rb, last, 0)
+    return w.Body.String(), ok
+}
+
+func TestFollowSSEReplayGap(t *testing.T) {
+    rb := newReplayBuffer(2)
+    record := recordTo(rb)
+    record(sseReady, sseReadyData{StreamID: "pcas-1"})
+    record(sseDelta, sseDeltaData{Text: "a"})
+    record(sseDelta, sseDeltaData{Text: "b"})
+    record(sseDone, sseDoneData{StreamID: "pcas-1"})
+    rb.close()
+
+    // ready and the first delta are gone: no silent resume past them
+    body, ok := followBody(t, rb, 1)
+    if ok || !strings.Contains(body, "event: error") || !strings.Contains(body, `"code":"replay_gap"`) {
+        t.Fatalf("from 1: delivered %v, body %q", ok, body)
+    }
+    if strings.Contains(body, "event: delta") || strings.Contains(body, "event: done") {
+        t.Fatalf("events sent after the gap: %q", body)
+    }
+
+    // a new subscriber without Last-Event-ID starts at the oldest retained event
+    body, ok = followBody(t, rb, 0)
+    if !ok || strings.Contains(body, "replay_gap") || !strings.Contains(body, "id: 3\nevent: delta") || !strings.Contains(body, "id: 4\nevent: done") {
+        t.Fatalf("from 0: delivered %v, body %q", ok, body)
+    }
+
+    // resuming right before the oldest retained event is complete
+    body, ok = followBody(t, rb, 2)
+    if !ok || strings.Contains(body, "replay_gap") || !strings.Contains(body, "id: 3\nevent: delta") || !strings.Contains(body, "id: 4\nevent: done") {
+        t.Fatalf("from 2: delivered %v, body %q", ok, body)
+    }
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+)
+
+// sessionLimits bounds how many capability streams may exist and for how long.
+// Zero fields disable the matching limit.
+type sessionLimits struct {
+    IdleTTL         time.Duration
+    MaxLifetime     time.Duration
+    JanitorInterval time.Duration
+    // CompletedTTL is how long a finished session stays readable.
+    CompletedTTL    time.Duration
+    MaxPerUser      int
+    MaxTotal        int
+    // ReplayEvents is how many recent events each session keeps for subscribers.
+    ReplayEvents int
+}
+
+// errSessionLimit is returned by sessionManager.create when a cap is reached.
+type errSessionLimit struct {
+    scope string // "user" or "global"
+    limit int
+}
+
+func (e *errSessionLimit) Error() string {
+    if e.scope == "user" {
+        return fmt.Sprintf("too many concurrent streams for this user (limit %d); close one and retry", e.limit)
+    }
+    return fmt.Sprintf("server stream capacity reached (limit %d); retry later", e.limit)
+}
+
+var errSessionClosed = errors.New("stream already committed/closed")
+
+// sessionManager manages in-memory generic streams bridged to PCAS. A janitor
+// removes sessions that stayed idle past IdleTTL, outlived MaxLifetime or
+// finished more than CompletedTTL ago, until Close.
+type sessionManager struct {
+    limits   sessionLimits
+    mu       sync.RWMutex
+    sessions map[string]*session
+    // perUser counts open sessions by quotaKey.
+    perUser  map[string]int
+
+    stop      chan struct{}
+    closeOnce sync.Once
+}
+
+type session struct {
+    id      string
+    userID  string
+    // quotaKey is what MaxPerUser counts: the user id when a token vouches
+    // for it, otherwise the client address (anyone can claim an anonymous id).
+    quotaKey string
+    // counted is set while the session holds a MaxPerUser slot; guarded by
+    // the manager's mu.
+    counted bool
+    in      chan []byte
+    // replay holds the typed events of the stream for all SSE subscribers.
+    replay  *replayBuffer
+    ctx     context.Context
+    cancel  context.CancelCauseFunc
+    created time.Time
+
+    // inMu guards in: senders hold it shared, closeInput exclusively.
+    inMu     sync.RWMutex
+    inClosed bool
+
+    mu          sync.Mutex
+    lastActive  time.Time
+    subscribers int
+    // finished is when the PCAS streams ended; zero while they run.
+    finished    time.Time
+}
+
+func newSessionManager(limits sessionLimits) *sessionManager {
+    m := &sessionManager{
+        limits:   limits,
+        sessions: make(map[string]*session),
+        perUser:  make(map[string]int),
+        stop:     make(chan struct{}),
+    }
+    if limits.JanitorInterval > 0 && (limits.IdleTTL > 0 || limits.MaxLifetime > 0 || limits.CompletedTTL > 0) {
+        go m.janitor(limits.JanitorInterval)
+    }
+    return m
+}
+
+// newSession prepares a session for userID; it is not tracked until create.
+// MaxPerUser applies per quotaKey, see sessionQuotaKey.
+func (m *sessionManager) newSession(id, userID, quotaKey string) *session {
+    ctx, cancel := context.WithCancelCause(context.Background())
+    now := time.Now()
+    return &session{
+        id:         id,
+        userID:     userID,
+        quotaKey:   quotaKey,
+        in:         make(chan []byte, 16),
+        replay:     newReplayBuffer(m.limits.ReplayEvents),
+        ctx:        ctx,
+        cancel:     cancel,
+        created:    now,
+        lastActive: now,
+    }
+}
+
+// create registers s unless the per-user or global cap is reached.
+func (m *sessionManager) create(s *session) error {
+    m.mu.Lock(); defer m.mu.Unlock()
+    if m.limits.MaxTotal > 0 && len(m.sessions) >= m.limits.MaxTotal {
+        return &errSessionLimit{scope: "global", limit: m.limits.MaxTotal}
+    }
+    if m.limits.MaxPerUser > 0 && m.perUser[s.quotaKey] >= m.limits.MaxPerUser {
+        return &errSessionLimit{scope: "user", limit: m.limits.MaxPerUser}
+    }
+    m.sessions[s.id] = s
+    m.perUser[s.quotaKey]++
+    s.counted = true
+    return nil
+}
+
+// release gives back the MaxPerUser slot of s once; m.mu must be held.
+func (m *sessionManager) release(s *session) {
+    if !s.counted {
+        return
+    }
+    s.counted = false
+    if m.perUser[s.quotaKey]--; m.perUser[s.quotaKey] <= 0 {
+        delete(m.perUser, s.quotaKey)
+    }
+}
+
+func (m *sessionManager) get(id string) (*session, bool) {
+    m.mu.RLock(); defer m.mu.RUnlock()
+    s, ok := m.sessions[id]
+    return s, ok
+}
+
+// finish records that the PCAS streams of s ended and frees its MaxPerUser
+// slot right away, whether or not anyone reads the result. The session and
+// its replay buffer stay for readers until CompletedTTL has passed.
+func (m *sessionManager) finish(s *session) {
+    m.mu.Lock()
+    m.release(s)
+    m.mu.Unlock()
+    s.mu.Lock()
+    s.finished = time.Now()
+    s.mu.Unlock()
+}
+
+// remove forgets the session and tears down its PCAS stream. It is safe to
+// call more than once.
+func (m *sessionManager) remove(id, reason string) {
+    m.mu.Lock()
+    s, ok := m.sessions[id]
+    if ok {
+        delete(m.sessions, id)
+        m.release(s)
+    }
+    m.mu.Unlock()
+    if !ok {
+        return
+    }
+    // Cancel first so a send blocked on a full input gives up; subscribers
+    // still attached see the reason in the final error event
+    s.cancel(fmt.Errorf("stream closed: %s", reason))
+    s.closeInput()
+    log.Printf("[stream-close] id=%s reason=%s age=%s", id, reason, time.Since(s.created).Round(time.Second))
+}
+
+func (m *sessionManager) janitor(interval time.Duration) {
+    t := time.NewTicker(interval)
+    defer t.Stop()
+    for {
+        select {
+        case now := <-t.C:
+            m.sweep(now)
+        case <-m.stop:
+            return
+        }
+    }
+}
+
+// Close stops the janitor and closes every open session. It is safe to call
+// more than once.
+func (m *sessionManager) Close() {
+    m.closeOnce.Do(func() { close(m.stop) })
+    m.mu.RLock()
+    ids := make([]string, 0, len(m.sessions))
+    for id := range m.sessions {
+        ids = append(ids, id)
+    }
+    m.mu.RUnlock()
+    for _, id := range ids {
+        m.remove(id, "shutdown")
+    }
+}
+
+// sweep removes sessions past their idle TTL, absolute lifetime or
+// completed grace period.
+func (m *sessionManager) sweep(now time.Time) {
+    type expired struct{ id, reason string }
+    var victims []expired
+    m.mu.RLock()
+    for id, s := range m.sessions {
+        switch {
+        case m.limits.CompletedTTL > 0 && s.finishedFor(now) > m.limits.CompletedTTL:
+            victims = append(victims, expired{id, "completed"})
+        case m.limits.MaxLifetime > 0 && now.Sub(s.created) > m.limits.MaxLifetime:
+            victims = append(victims, expired{id, "max_lifetime"})
+        case m.limits.IdleTTL > 0 && s.idleSince(now) > m.limits.IdleTTL:
+            victims = append(victims, expired{id, "idle"})
+        }
+    }
+    m.mu.RUnlock()
+    for _, v := range victims {
+        m.remove(v.id, v.reason)
+    }
+}
+
+// finishedFor returns how long ago the session finished, or 0 while it runs.
+func (s *session) finishedFor(now time.Time) time.Duration {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.finished.IsZero() {
+        return 0
+    }
+    return now.Sub(s.finished)
+}
+
+func (s *session) touch() {
+    s.mu.Lock()
+    s.lastActive = time.Now()
+    s.mu.Unlock()
+}
+
+// idleSince reports how long nothing happened on the session. A session with
+// an attached SSE subscriber is never idle.
+func (s *session) idleSince(now time.Time) time.Duration {
+    s.mu.Lock(); defer s.mu.Unlock()
+    if s.subscribers > 0 {
+        return 0
+    }
+    return now.Sub(s.lastActive)
+}
+
+// subscribe marks an SSE reader as attached; the returned func detaches it.
+// Any number of readers may follow the same session.
+func (s *session) subscribe() func() {
+    s.mu.Lock()
+    s.subscribers++
+    s.lastActive = time.Now()
+    s.mu.Unlock()
+    return func() {
+        s.mu.Lock()
+        s.subscribers--
+        s.lastActive = time.Now()
+        s.mu.Unlock()
+    }
+}
+
+// send forwards text to PCAS unless input was already closed or the session ended.
+func (s *session) send(ctx context.Context, b []byte) error {
+    s.inMu.RLock()
+    defer s.inMu.RUnlock()
+    if s.inClosed {
+        return errSessionClosed
+    }
+    s.touch()
+    select {
+    case s.in <- b:
+        return nil
+    case <-s.ctx.Done():
+        return errSessionClosed
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// closeInput signals ClientEnd to PCAS; later calls are no-ops.
+func (s *session) closeInput() {
+    s.inMu.Lock()
+    defer s.inMu.Unlock()
+    if !s.inClosed {
+        s.inClosed = true
+        close(s.in)
+    }
+    s.touch()
+}
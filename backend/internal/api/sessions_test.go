@@ -0,0 +1,352 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+)
+
+func init() {
+    gin.SetMode(gin.TestMode)
+}
+
+func TestSessionCreateLimits(t *testing.T) {
+    cases := []struct {
+        name   string
+        limits sessionLimits
+        // quota keys of the sessions opened before the one under test
+        open []string
+        key  string
+        // scope of the expected errSessionLimit, or "" for success
+        scope string
+    }{
+        {"per-user cap", sessionLimits{MaxPerUser: 2}, []string{"user:alice", "user:alice"}, "user:alice", "user"},
+        {"other user unaffected", sessionLimits{MaxPerUser: 2}, []string{"user:alice", "user:alice"}, "user:bob", ""},
+        {"anonymous per address", sessionLimits{MaxPerUser: 2}, []string{"ip:10.0.0.1", "ip:10.0.0.1"}, "ip:10.0.0.1", "user"},
+        {"other address unaffected", sessionLimits{MaxPerUser: 2}, []string{"ip:10.0.0.1", "ip:10.0.0.1"}, "ip:10.0.0.2", ""},
+        {"global cap", sessionLimits{MaxTotal: 2}, []string{"user:alice", "user:bob"}, "user:carol", "global"},
+        {"global cap counts anonymous", sessionLimits{MaxTotal: 2, MaxPerUser: 1}, []string{"ip:a", "ip:b"}, "ip:c", "global"},
+        {"global before per-user", sessionLimits{MaxTotal: 2, MaxPerUser: 2}, []string{"user:alice", "user:alice"}, "user:alice", "global"},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            m := newSessionManager(tc.limits)
+            for i, key := range tc.open {
+                if err := m.create(m.newSession(tc.name+string(rune('a'+i)), "u", key)); err != nil {
+                    t.Fatalf("opening %s: %v", key, err)
+                }
+            }
+            err := m.create(m.newSession("under-test", "u", tc.key))
+            var limit *errSessionLimit
+            switch {
+            case tc.scope == "" && err != nil:
+                t.Fatalf("create: %v", err)
+            case tc.scope != "" && !errors.As(err, &limit):
+                t.Fatalf("create = %v, want errSessionLimit", err)
+            case tc.scope != "" && limit.scope != tc.scope:
+                t.Fatalf("scope = %s, want %s", limit.scope, tc.scope)
+            }
+        })
+    }
+}
+
+func TestSessionRemoveReleasesPerUserSlot(t *testing.T) {
+    m := newSessionManager(sessionLimits{MaxPerUser: 1})
+    if err := m.create(m.newSession("s1", "alice", "user:alice")); err != nil {
+        t.Fatal(err)
+    }
+    if err := m.create(m.newSession("s2", "alice", "user:alice")); err == nil {
+        t.Fatal("second stream accepted over MaxPerUser")
+    }
+    m.remove("s1", "closed")
+    m.remove("s1", "closed")
+    if err := m.create(m.newSession("s3", "alice", "user:alice")); err != nil {
+        t.Fatalf("slot not released: %v", err)
+    }
+    if n := m.perUser["user:alice"]; n != 1 {
+        t.Fatalf("perUser[user:alice] = %d, want 1", n)
+    }
+}
+
+func TestStartGenericSessionLimit(t *testing.T) {
+    cases := []struct {
+        name   string
+        limits sessionLimits
+        ident  identity
+        // quota key of the stream already open
+        existing string
+        want     string
+    }{
+        {"user", sessionLimits{MaxPerUser: 1}, identity{UserID: "alice", Authenticated: true}, "user:alice", "too many concurrent streams for this user (limit 1); close one and retry"},
+        {"global", sessionLimits{MaxTotal: 1}, identity{UserID: "alice", Authenticated: true}, "user:alice", "server stream capacity reached (limit 1); retry later"},
+        // a fresh anonymous id does not get the caller a fresh quota
+        {"anonymous", sessionLimits{MaxPerUser: 1}, identity{UserID: "anyone-else"}, "ip:192.0.2.1", "too many concurrent streams for this user (limit 1); close one and retry"},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            ch := &capabilityHandler{
+                cfg: &config.Config{},
+                sm:  newSessionManager(tc.limits),
+                identify: func(*gin.Context) (identity, error) {
+                    return tc.ident, nil
+                },
+            }
+            if err := ch.sm.create(ch.sm.newSession("existing", "alice", tc.existing)); err != nil {
+                t.Fatal(err)
+            }
+
+            w := httptest.NewRecorder()
+            c, _ := gin.CreateTestContext(w)
+            c.Request = httptest.NewRequest(http.MethodPost, "/api/translate/start", nil)
+            c.Request.RemoteAddr = "192.0.2.1:40000"
+            ch.startGeneric(c, &capability{Name: "translate"}, map[string]any{}, []streamTarget{{}})
+
+            if w.Code != http.StatusTooManyRequests {
+                t.Fatalf("status = %d, want 429", w.Code)
+            }
+            var body struct {
+                Error struct {
+                    Code    string `json:"code"`
+                    Message string `json:"message"`
+                } `json:"error"`
+            }
+            if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+                t.Fatal(err)
+            }
+            if body.Error.Code != "session_limit" || body.Error.Message != tc.want {
+                t.Fatalf("body = %s", w.Body.String())
+            }
+            if len(ch.sm.sessions) != 1 {
+                t.Fatalf("%d sessions tracked, want 1", len(ch.sm.sessions))
+            }
+        })
+    }
+}
+
+func TestSessionSweep(t *testing.T) {
+    m := newSessionManager(sessionLimits{IdleTTL: time.Minute, MaxLifetime: time.Hour})
+    now := time.Now()
+
+    fresh := m.newSession("fresh", "u", "user:u")
+    idle := m.newSession("idle", "u", "user:u")
+    idle.lastActive = now.Add(-2 * time.Minute)
+    old := m.newSession("old", "u", "user:u")
+    old.created = now.Add(-2 * time.Hour)
+    watched := m.newSession("watched", "u", "user:u")
+    detach := watched.subscribe()
+    defer detach()
+    watched.lastActive = now.Add(-2 * time.Minute)
+    for _, s := range []*session{fresh, idle, old, watched} {
+        if err := m.create(s); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    m.sweep(now)
+
+    for id, want := range map[string]bool{"fresh": true, "idle": false, "old": false, "watched": true} {
+        if _, ok := m.get(id); ok != want {
+            t.Errorf("session %s tracked = %v, want %v", id, ok, want)
+        }
+    }
+    for s, reason := range map[*session]string{idle: "idle", old: "max_lifetime"} {
+        if err := context.Cause(s.ctx); err == nil || !strings.Contains(err.Error(), reason) {
+            t.Errorf("session %s cause = %v, want %s", s.id, err, reason)
+        }
+        if err := s.send(context.Background(), []byte("late")); !errors.Is(err, errSessionClosed) {
+            t.Errorf("send on swept session %s = %v, want errSessionClosed", s.id, err)
+        }
+    }
+    if n := m.perUser["user:u"]; n != 2 {
+        t.Errorf("perUser[user:u] = %d, want 2", n)
+    }
+}
+
+func TestSessionFinishReleasesPerUserSlot(t *testing.T) {
+    m := newSessionManager(sessionLimits{MaxPerUser: 1, CompletedTTL: time.Minute})
+    done := m.newSession("done", "u", "ip:192.0.2.1")
+    if err := m.create(done); err != nil {
+        t.Fatal(err)
+    }
+    if err := m.create(m.newSession("s2", "u", "ip:192.0.2.1")); err == nil {
+        t.Fatal("second stream accepted over MaxPerUser")
+    }
+
+    // a finished stream nobody reads no longer counts, though it stays readable
+    m.finish(done)
+    if err := m.create(m.newSession("s3", "u", "ip:192.0.2.1")); err != nil {
+        t.Fatalf("slot not released on finish: %v", err)
+    }
+    if _, ok := m.get("done"); !ok {
+        t.Fatal("finished session dropped before CompletedTTL")
+    }
+    // removing it later does not free the slot a second time
+    m.finish(done)
+    m.remove("done", "completed")
+    if n := m.perUser["ip:192.0.2.1"]; n != 1 {
+        t.Fatalf("perUser = %d, want 1", n)
+    }
+}
+
+func TestSessionQuotaKey(t *testing.T) {
+    cases := []struct {
+        name    string
+        ident   identity
+        proxies []string
+        xff     string
+        want    string
+    }{
+        {"token user", identity{UserID: "alice", Authenticated: true}, nil, "", "user:alice"},
+        {"anonymous", identity{UserID: "alice"}, nil, "", "ip:192.0.2.1"},
+        {"forwarded header from an untrusted peer", identity{UserID: "alice"}, nil, "198.51.100.7", "ip:192.0.2.1"},
+        {"forwarded header from a trusted proxy", identity{UserID: "alice"}, []string{"192.0.2.0/24"}, "198.51.100.7", "ip:198.51.100.7"},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            router := gin.New()
+            if err := router.SetTrustedProxies(tc.proxies); err != nil {
+                t.Fatal(err)
+            }
+            var got string
+            router.GET("/", func(c *gin.Context) { got = sessionQuotaKey(c, tc.ident) })
+            req := httptest.NewRequest(http.MethodGet, "/", nil)
+            req.RemoteAddr = "192.0.2.1:40000"
+            if tc.xff != "" {
+                req.Header.Set("X-Forwarded-For", tc.xff)
+            }
+            router.ServeHTTP(httptest.NewRecorder(), req)
+            if got != tc.want {
+                t.Fatalf("key = %q, want %q", got, tc.want)
+            }
+        })
+    }
+}
+
+func TestSessionManagerClose(t *testing.T) {
+    m := newSessionManager(sessionLimits{IdleTTL: time.Nanosecond, JanitorInterval: time.Millisecond})
+    open := m.newSession("open", "u", "user:u")
+    if err := m.create(open); err != nil {
+        t.Fatal(err)
+    }
+    m.Close()
+    m.Close()
+    if _, ok := m.get("open"); ok {
+        t.Fatal("session still tracked after Close")
+    }
+    if err := context.Cause(open.ctx); err == nil || !strings.Contains(err.Error(), "shutdown") {
+        t.Fatalf("cause = %v, want shutdown", err)
+    }
+
+    // the janitor is gone: an expired session is no longer swept
+    late := m.newSession("late", "u", "user:u")
+    late.lastActive = time.Now().Add(-time.Hour)
+    if err := m.create(late); err != nil {
+        t.Fatal(err)
+    }
+    time.Sleep(20 * time.Millisecond)
+    if _, ok := m.get("late"); !ok {
+        t.Fatal("janitor still sweeping after Close")
+    }
+}
+
+func TestSessionIdleSince(t *testing.T) {
+    m := newSessionManager(sessionLimits{})
+    s := m.newSession("s", "u", "ip:192.0.2.1")
+    now := time.Now()
+    s.lastActive = now.Add(-time.Minute)
+
+    if got := s.idleSince(now); got != time.Minute {
+        t.Fatalf("idleSince = %s, want 1m", got)
+    }
+    detach := s.subscribe()
+    if got := s.idleSince(now.Add(time.Hour)); got != 0 {
+        t.Fatalf("idleSince with a subscriber = %s, want 0", got)
+    }
+    second := s.subscribe()
+    detach()
+    if got := s.idleSince(now.Add(time.Hour)); got != 0 {
+        t.Fatalf("idleSince with one of two subscribers left = %s, want 0", got)
+    }
+    second()
+    if got := s.idleSince(time.Now().Add(time.Minute)); got < time.Minute-time.Second {
+        t.Fatalf("idleSince after detach = %s, want about 1m", got)
+    }
+}
+
+// serveStream runs streamSSE for id, resuming after lastEventId when given,
+// and returns the response body.
+func serveStream(ctx context.Context, ch *capabilityHandler, id string, lastEventID ...string) string {
+    w := httptest.NewRecorder()
+    c, _ := gin.CreateTestContext(w)
+    target := "/api/translate/stream?streamId=" + id
+    if len(lastEventID) > 0 {
+        target += "&lastEventId=" + lastEventID[0]
+    }
+    c.Request = httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+    ch.streamSSE(c)
+    return w.Body.String()
+}
+
+func TestStreamSSEKeepsCompletedSession(t *testing.T) {
+    ch := &capabilityHandler{cfg: &config.Config{}, sm: newSessionManager(sessionLimits{CompletedTTL: time.Minute})}
+    s := ch.sm.newSession("s1", "u", "user:u")
+    if err := ch.sm.create(s); err != nil {
+        t.Fatal(err)
+    }
+    record := recordTo(s.replay)
+    record(sseReady, sseReadyData{StreamID: "pcas-1"})
+
+    // two tabs follow the stream until it ends
+    bodies := make(chan string, 2)
+    for i := 0; i < 2; i++ {
+        go func() { bodies <- serveStream(context.Background(), ch, "s1") }()
+    }
+    for attached := 0; attached < 2; {
+        time.Sleep(time.Millisecond)
+        s.mu.Lock()
+        attached = s.subscribers
+        s.mu.Unlock()
+    }
+    record(sseDelta, sseDeltaData{Text: "hello"})
+    record(sseDone, sseDoneData{StreamID: "pcas-1", Deltas: 1})
+    s.replay.close()
+    ch.sm.finish(s)
+    for i := 0; i < 2; i++ {
+        body := <-bodies
+        for _, ev := range []string{sseReady, sseDelta, sseDone} {
+            if !strings.Contains(body, "event: "+ev) {
+                t.Fatalf("subscriber %d body = %q, missing %s", i, body, ev)
+            }
+        }
+    }
+
+    // a reconnect after the end still gets the tail after its Last-Event-ID
+    body := serveStream(context.Background(), ch, "s1", "1")
+    if strings.Contains(body, "id: 1\n") || !strings.Contains(body, "id: 2\nevent: "+sseDelta) || !strings.Contains(body, "id: 3\nevent: "+sseDone) {
+        t.Fatalf("late reconnect body = %q", body)
+    }
+
+    // the janitor drops it once the grace period passed
+    ch.sm.sweep(time.Now())
+    if _, ok := ch.sm.get("s1"); !ok {
+        t.Fatal("session removed within its grace period")
+    }
+    ch.sm.sweep(time.Now().Add(2 * time.Minute))
+    if _, ok := ch.sm.get("s1"); ok {
+        t.Fatal("session still tracked after its grace period")
+    }
+    if err := context.Cause(s.ctx); err == nil || !strings.Contains(err.Error(), "completed") {
+        t.Fatalf("cause = %v, want completed", err)
+    }
+    if body := serveStream(context.Background(), ch, "s1"); !strings.Contains(body, "stream not found") {
+        t.Fatalf("after removal: %q", body)
+    }
+}
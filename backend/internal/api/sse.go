@@ -0,0 +1,307 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+// Named SSE events of capability streams. A stream emits one ready, any
+// number of deltas and ends with exactly one done or error; heartbeats are
+// interleaved per connection. Chat answers grounded in session text are
+// preceded by a citations event. A fanout request runs several streams whose
+// events are tagged with their target; each ends with its own done or error.
+const (
+    sseReady     = "ready"
+    sseDelta     = "delta"
+    sseDone      = "done"
+    sseError     = "error"
+    sseHeartbeat = "heartbeat"
+    sseCitations = "citations"
+)
+
+// codeReplayGap is the error code sent to a subscriber whose next event was
+// evicted from the replay buffer before it read it.
+const codeReplayGap = "replay_gap"
+
+type sseReadyData struct {
+    StreamID string `json:"streamId"`
+    // Target is the fanout value of the stream, e.g. its target language.
+    Target string `json:"target,omitempty"`
+}
+
+// sseDeltaData keeps the {"text":...} shape older clients parse from data: lines.
+type sseDeltaData struct {
+    Text   string `json:"text"`
+    Target string `json:"target,omitempty"`
+}
+
+type sseDoneData struct {
+    StreamID string `json:"streamId"`
+    Target   string `json:"target,omitempty"`
+    // ElapsedMs is the time from opening the stream to its end; FirstDeltaMs
+    // the time until the first delta.
+    ElapsedMs    int64 `json:"elapsedMs"`
+    FirstDeltaMs int64 `json:"firstDeltaMs"`
+    Deltas       int   `json:"deltas"`
+}
+
+type sseErrorData struct {
+    Code     string `json:"code"`
+    Message  string `json:"message"`
+    StreamID string `json:"streamId,omitempty"`
+    Target   string `json:"target,omitempty"`
+}
+
+// sseCitationsData numbers the sources sent with the message; answers cite them as [n].
+type sseCitationsData struct {
+    Citations []citation `json:"citations"`
+    // Warning explains sources that could not be consulted.
+    Warning string `json:"warning,omitempty"`
+}
+
+type sseHeartbeatData struct {
+    Ts int64 `json:"ts"`
+}
+
+// runGeneric bridges one PCAS interact stream and reports it through emit as
+// ready, delta and a final done or error event. It returns after the final event.
+func runGeneric(ctx context.Context, pool *pcas.Pool, eventType string, attrs map[string]string, in <-chan []byte, emit func(event string, data any)) {
+    started := time.Now()
+    out := make(chan []byte, 16)
+    result := make(chan error, 1)
+    var streamID string
+
+    go func() {
+        gw := pool.Gateway()
+        defer gw.Close()
+        err := gw.StartGenericStream(ctx, eventType, attrs, in, out, func(id string) {
+            streamID = id
+            emit(sseReady, sseReadyData{StreamID: id})
+        })
+        close(out)
+        result <- err
+    }()
+
+    done := sseDoneData{}
+    for b := range out {
+        if done.Deltas == 0 {
+            done.FirstDeltaMs = time.Since(started).Milliseconds()
+        }
+        done.Deltas++
+        emit(sseDelta, sseDeltaData{Text: string(b)})
+    }
+    err := <-result
+    done.StreamID = streamID
+    done.ElapsedMs = time.Since(started).Milliseconds()
+
+    if err == nil && ctx.Err() != nil {
+        err = ctx.Err()
+    }
+    if err != nil {
+        code, msg := pcas.ErrorCode(err), err.Error()
+        if ctx.Err() != nil {
+            code, msg = pcas.CodeCanceled, context.Cause(ctx).Error()
+        }
+        log.Printf("pcas stream error: eventType=%s stream=%s code=%s: %v", eventType, streamID, code, err)
+        emit(sseError, sseErrorData{Code: code, Message: msg, StreamID: streamID})
+        return
+    }
+    emit(sseDone, done)
+}
+
+// streamTarget is one PCAS stream of a request. Name is its fanout value and
+// empty for requests without fanout.
+type streamTarget struct {
+    name  string
+    attrs map[string]string
+}
+
+// runTargets runs one stream per target, each fed all of in, and reports
+// their events through emit one at a time, tagged with the target. Closing in
+// ends every stream; it returns once all of them finished, without waiting
+// for in to close if they all ended on their own.
+func runTargets(ctx context.Context, pool *pcas.Pool, eventType string, targets []streamTarget, in <-chan []byte, emit func(event string, data any)) {
+    if len(targets) == 1 && targets[0].name == "" {
+        runGeneric(ctx, pool, eventType, targets[0].attrs, in, emit)
+        return
+    }
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    ins := make([]chan []byte, len(targets))
+    ended := make([]chan struct{}, len(targets))
+    for i, t := range targets {
+        ins[i] = make(chan []byte, 16)
+        ended[i] = make(chan struct{})
+        wg.Add(1)
+        go func(t streamTarget, in <-chan []byte, ended chan struct{}) {
+            defer wg.Done()
+            defer close(ended)
+            runGeneric(ctx, pool, eventType, t.attrs, in, func(event string, data any) {
+                mu.Lock()
+                defer mu.Unlock()
+                emit(event, withTarget(data, t.name))
+            })
+        }(t, ins[i], ended[i])
+    }
+    // stop reading in once every stream ended, as a single stream does
+    finished := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(finished)
+    }()
+read:
+    for {
+        select {
+        case b, ok := <-in:
+            if !ok {
+                break read
+            }
+            for i := range ins {
+                // a stream that already ended no longer reads its input
+                select {
+                case ins[i] <- b:
+                case <-ended[i]:
+                case <-ctx.Done():
+                }
+            }
+        case <-finished:
+            break read
+        }
+    }
+    for _, c := range ins {
+        close(c)
+    }
+    <-finished
+}
+
+// withTarget tags the data of a stream event with its target.
+func withTarget(data any, target string) any {
+    switch d := data.(type) {
+    case sseReadyData:
+        d.Target = target
+        return d
+    case sseDeltaData:
+        d.Target = target
+        return d
+    case sseDoneData:
+        d.Target = target
+        return d
+    case sseErrorData:
+        d.Target = target
+        return d
+    }
+    return data
+}
+
+// recordTo returns an emit func that appends events to rb.
+func recordTo(rb *replayBuffer) func(string, any) {
+    return func(event string, data any) {
+        b, _ := json.Marshal(data)
+        rb.append(event, b)
+    }
+}
+
+// startSSE writes the event-stream headers and the opening comment.
+func startSSE(c *gin.Context) {
+    c.Header("Content-Type", "text/event-stream")
+    c.Header("Cache-Control", "no-cache")
+    c.Header("Connection", "keep-alive")
+    c.Header("X-Accel-Buffering", "no")
+
+    // Initial comment to open stream
+    _, _ = c.Writer.Write([]byte(":ok\n\n"))
+    c.Writer.Flush()
+}
+
+// followSSE writes the events of rb after id last until the stream ends or the
+// client goes away, plus a heartbeat event every heartbeat interval so proxies
+// keep the connection open. A last of 0 starts at the oldest retained event.
+// A subscriber that resumes, or falls, behind the oldest retained event gets
+// a replay_gap error instead of a stream with a hole in it. It reports
+// whether the final event was delivered.
+func followSSE(c *gin.Context, rb *replayBuffer, last uint64, heartbeat time.Duration) bool {
+    w := c.Writer
+    var beat <-chan time.Time
+    if heartbeat > 0 {
+        t := time.NewTicker(heartbeat)
+        defer t.Stop()
+        beat = t.C
+    }
+    notify := c.Request.Context().Done()
+    waited := false
+    for {
+        events, gap, done, wake := rb.since(last)
+        // a follower that waited on an empty stream must see it from event 1
+        if waited && last == 0 && len(events) > 0 && events[0].ID > 1 {
+            gap = true
+        }
+        if gap {
+            b, _ := json.Marshal(sseErrorData{
+                Code:    codeReplayGap,
+                Message: fmt.Sprintf("events after %d are no longer retained (oldest is %d); start a new stream", last, events[0].ID),
+            })
+            _, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", sseError, b)
+            w.Flush()
+            return false
+        }
+        for _, ev := range events {
+            _, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Event, ev.Data)
+            last = ev.ID
+        }
+        if len(events) > 0 {
+            w.Flush()
+        }
+        if done {
+            return true
+        }
+        waited = true
+        select {
+        case <-wake:
+        case <-beat:
+            b, _ := json.Marshal(sseHeartbeatData{Ts: time.Now().UnixMilli()})
+            _, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", sseHeartbeat, b)
+            w.Flush()
+        case <-notify:
+            return false
+        }
+    }
+}
+
+// writeSSE writes the events of ch, numbered from 1, until ch is closed or the
+// client goes away, with heartbeats as in followSSE. Unlike a replayBuffer it
+// keeps nothing: the producer waits until each event is written.
+func writeSSE(c *gin.Context, ch <-chan sseEvent, heartbeat time.Duration) {
+    w := c.Writer
+    var beat <-chan time.Time
+    if heartbeat > 0 {
+        t := time.NewTicker(heartbeat)
+        defer t.Stop()
+        beat = t.C
+    }
+    notify := c.Request.Context().Done()
+    var id uint64
+    for {
+        select {
+        case ev, ok := <-ch:
+            if !ok {
+                return
+            }
+            id++
+            _, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, ev.Event, ev.Data)
+            w.Flush()
+        case <-beat:
+            b, _ := json.Marshal(sseHeartbeatData{Ts: time.Now().UnixMilli()})
+            _, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", sseHeartbeat, b)
+            w.Flush()
+        case <-notify:
+            return
+        }
+    }
+}
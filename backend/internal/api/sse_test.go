@@ -0,0 +1,150 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+func TestWriteSSEKeepsEveryEvent(t *testing.T) {
+    // more events than a replay buffer holds, sent faster than they are written
+    const n = 1000
+    events := make(chan sseEvent)
+    go func() {
+        defer close(events)
+        for i := 0; i < n; i++ {
+            events <- sseEvent{Event: sseDelta, Data: []byte(fmt.Sprintf(`{"text":"%d"}`, i))}
+        }
+        events <- sseEvent{Event: sseDone, Data: []byte(`{}`)}
+    }()
+
+    w := httptest.NewRecorder()
+    c, _ := gin.CreateTestContext(w)
+    c.Request = httptest.NewRequest(http.MethodPost, "/run", nil)
+    writeSSE(c, events, 0)
+
+    frames := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+    if len(frames) != n+1 {
+        t.Fatalf("%d events written, want %d", len(frames), n+1)
+    }
+    for i, f := range frames[:n] {
+        want := fmt.Sprintf("id: %d\nevent: delta\ndata: {\"text\":\"%d\"}", i+1, i)
+        if f != want {
+            t.Fatalf("event %d = %q, want %q", i, f, want)
+        }
+    }
+    if want := fmt.Sprintf("id: %d\nevent: done\ndata: {}", n+1); frames[n] != want {
+        t.Fatalf("last event = %q, want %q", frames[n], want)
+    }
+}
+
+func TestWithTarget(t *testing.T) {
+    cases := []struct {
+        in   any
+        want any
+    }{
+        {sseReadyData{StreamID: "s"}, sseReadyData{StreamID: "s", Target: "ja"}},
+        {sseDeltaData{Text: "x"}, sseDeltaData{Text: "x", Target: "ja"}},
+        {sseDoneData{StreamID: "s", Deltas: 2}, sseDoneData{StreamID: "s", Deltas: 2, Target: "ja"}},
+        {sseErrorData{Code: "c"}, sseErrorData{Code: "c", Target: "ja"}},
+        // other events pass through untouched
+        {sseHeartbeatData{Ts: 1}, sseHeartbeatData{Ts: 1}},
+    }
+    for _, tc := range cases {
+        if got := withTarget(tc.in, "ja"); !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("withTarget(%#v) = %#v, want %#v", tc.in, got, tc.want)
+        }
+    }
+}
+
+// targetEvents runs targets against f with text as the whole input and
+// returns the events of each target in order, as "event" or "event:text".
+func targetEvents(t *testing.T, f *echoInteractServer, names ...string) map[string][]string {
+    t.Helper()
+    pool := echoPool(t, f)
+    var targets []streamTarget
+    for _, n := range names {
+        targets = append(targets, streamTarget{name: n, attrs: map[string]string{"target_lang": n}})
+    }
+    in := make(chan []byte, 2)
+    in <- []byte("hello ")
+    in <- []byte("world")
+    close(in)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    got := map[string][]string{}
+    runTargets(ctx, pool, "translate", targets, in, func(event string, data any) {
+        var target string
+        switch d := data.(type) {
+        case sseReadyData:
+            target = d.Target
+        case sseDeltaData:
+            target = d.Target
+            event += ":" + d.Text
+        case sseDoneData:
+            target = d.Target
+        case sseErrorData:
+            target = d.Target
+            event += ":" + d.Code
+        }
+        got[target] = append(got[target], event)
+    })
+    if ctx.Err() != nil {
+        t.Fatal("runTargets did not return after its input closed")
+    }
+    return got
+}
+
+func TestRunTargetsTagsEvents(t *testing.T) {
+    f := &echoInteractServer{}
+    got := targetEvents(t, f, "ja", "en", "de")
+    want := []string{sseReady, sseDelta + ":hello world", sseDone}
+    for _, n := range []string{"ja", "en", "de"} {
+        if !reflect.DeepEqual(got[n], want) {
+            t.Errorf("%s events = %v, want %v", n, got[n], want)
+        }
+    }
+    if len(got) != 3 {
+        t.Errorf("events for targets %v", got)
+    }
+    // closing in ended every stream with the whole input
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if len(f.inputs) != 3 {
+        t.Fatalf("%d streams ended, want 3", len(f.inputs))
+    }
+    for _, in := range f.inputs {
+        if in != "hello world" {
+            t.Fatalf("stream input = %q", in)
+        }
+    }
+}
+
+func TestRunTargetsFailureStaysWithItsTarget(t *testing.T) {
+    f := &echoInteractServer{fail: func(cfg *busv1.StreamConfig) bool { return cfg.GetAttributes()["target_lang"] == "xx" }}
+    got := targetEvents(t, f, "ja", "xx", "en")
+    ok := []string{sseReady, sseDelta + ":hello world", sseDone}
+    if !reflect.DeepEqual(got["ja"], ok) || !reflect.DeepEqual(got["en"], ok) {
+        t.Fatalf("sibling events = %v", got)
+    }
+    if want := []string{sseReady, sseError + ":" + pcas.CodePCASError}; !reflect.DeepEqual(got["xx"], want) {
+        t.Fatalf("failing target events = %v, want %v", got["xx"], want)
+    }
+}
+
+func TestRunTargetsSingleStreamUntagged(t *testing.T) {
+    got := targetEvents(t, &echoInteractServer{}, "")
+    if want := []string{sseReady, sseDelta + ":hello world", sseDone}; len(got) != 1 || !reflect.DeepEqual(got[""], want) {
+        t.Fatalf("events = %v", got)
+    }
+}
@@ -2,13 +2,18 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/pcas/dreams-cli/backend/internal/config"
+	"github.com/pcas/dreams-cli/backend/internal/distiller"
 	"github.com/pcas/dreams-cli/backend/internal/pcas"
 )
 
@@ -16,14 +21,26 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
+	Subprotocols: []string{subprotocolV2},
 }
 
 type Handler struct {
 	config *config.Config
+	pool   *pcas.Pool
+	// refs keeps transcript segments and capability outputs for chat refs.
+	refs *refStore
+	// caps is the capability registry; transcription pipelines run these too.
+	caps []*capability
+	// sessions holds the streams opened via /api/<name>/start.
+	sessions *sessionManager
 }
 
-func RegisterRoutes(router *gin.Engine, cfg *config.Config) {
-    h := &Handler{config: cfg}
+// RegisterRoutes mounts the API on router. Call Close on the returned
+// Handler during shutdown to end its open streams and background work.
+func RegisterRoutes(router *gin.Engine, cfg *config.Config, pool *pcas.Pool) *Handler {
+    h := &Handler{config: cfg, pool: pool}
+    h.refs = newRefStore(cfg.Chat.SessionItems, cfg.Chat.MaxSessions, cfg.Chat.TTL)
+    h.caps = capabilitiesFrom(cfg)
     router.GET("/ws/transcribe", h.HandleTranscription)
     // API routes for capability streams (translate/summarize/chat)
     h.registerCapabilities(router)
@@ -31,9 +48,51 @@ func RegisterRoutes(router *gin.Engine, cfg *config.Config) {
     h.registerDiagnostics(router)
     // Admin management routes (policy add rule)
     h.registerAdmin(router)
+    // Search over the user's memory events
+    h.registerMemories(router)
+    // Events PCAS pushes on its own (hints), relayed over SSE
+    h.registerEvents(router)
+    return h
+}
+
+// Close ends the capability streams still open and stops their janitor.
+func (h *Handler) Close() {
+    if h.sessions != nil {
+        h.sessions.Close()
+    }
 }
 
 func (h *Handler) HandleTranscription(c *gin.Context) {
+	ident, err := h.resolveIdentity(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": gin.H{"message": err.Error()}})
+		return
+	}
+
+	// Optional per-stream segmentation: ?segmenter=cjk|latin|mixed or ?language=en
+	attrs := map[string]string{}
+	if name := c.Query("segmenter"); name != "" {
+		if _, err := distiller.NewSegmenter(name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": err.Error()}})
+			return
+		}
+		attrs["segmenter"] = name
+	}
+	if lang := c.Query("language"); lang != "" {
+		attrs["language"] = lang
+	}
+
+	// Server-side pipelines on the final sentences: ?pipelines=translate,summarize&targetLang=ja
+	pipelines, err := h.bindPipelines(pipelineBodies(c.Request.URL.Query(), h.caps))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": err.Error(), "code": "invalid_pipelines"}})
+		return
+	}
+	if len(pipelines) > 0 && !requestsV2(c) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": errPipelinesNeedV2.Error(), "code": "invalid_pipelines"}})
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
@@ -41,49 +100,119 @@ func (h *Handler) HandleTranscription(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	log.Printf("New WebSocket connection established from %s", c.ClientIP())
+	enc := &transcriptEncoder{protocol: negotiateProtocol(c, conn)}
+	log.Printf("New WebSocket connection established from %s (protocol v%d, user %s, session %s)", c.ClientIP(), enc.protocol, ident.UserID, ident.SessionID)
 
 	audioFromClient := make(chan []byte, 10)
-	textToClient := make(chan []byte, 10)
+	controls := make(chan pcas.StreamControl, 8)
+	events := make(chan pcas.TranscriptEvent, 32)
+	acks := make(chan controlAck, 8)
 
-	gateway, err := pcas.NewGateway(h.config.PCAS.Address)
-	if err != nil {
-		log.Printf("Failed to create PCAS gateway: %v", err)
-		if err := conn.WriteMessage(websocket.TextMessage, []byte("Failed to connect to transcription service")); err != nil {
-			log.Printf("Failed to write error message to client: %v", err)
-		}
-		return
-	}
+	gateway := h.pool.Gateway()
 	defer gateway.Close()
 
 	ctx, cancel := context.WithCancel(c.Request.Context())
 	defer cancel()
+	pipes := newTranscriptPipelines(h)
+	// refs are read back only by token-authenticated users; anonymous ids
+	// could write into anyone's session
+	pipes.keepRefs = ident.Authenticated
 
 	var wg sync.WaitGroup
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		userID := "default-user"
-		if err := gateway.ProcessStream(ctx, h.config.PCAS.EventType, audioFromClient, textToClient, userID); err != nil {
-			log.Printf("PCAS gateway error: %v", err)
-			cancel()
-		}
-	}()
+	opts := pcas.StreamOptions{
+		EventType:  h.config.PCAS.EventType,
+		UserID:     ident.UserID,
+		SessionID:  ident.SessionID,
+		TraceID:    ident.TraceID,
+		// Lecture/meeting context attached to every memory: ?course=&title=&tags=a,b
+		Course:     c.Query("course"),
+		Title:      c.Query("title"),
+		Tags:       parseTags(c.Query("tags")),
+		Attributes: attrs,
+		Flush: distiller.FlushPolicy{
+			MaxRunes:       h.config.Distiller.MaxRunes,
+			MaxIdle:        h.config.Distiller.MaxIdle,
+			SoftBreakRunes: h.config.Distiller.SoftBreakRunes,
+		},
+		Controls: controls,
+	}
+
+	// The PCAS stream starts on the first "start" control message or the first
+	// audio frame, whichever comes first. Only the reader goroutine touches this.
+	started := false
+	startStream := func() {
+		started = true
+		pipes.start(ctx, pipelines, opts.UserID, opts.SessionID)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// events is closed on return; the writer then closes the socket
+			if err := gateway.ProcessStream(ctx, opts, audioFromClient, events); err != nil {
+				log.Printf("PCAS gateway error: %v", err)
+			}
+		}()
+	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		// nil frames have no representation in the negotiated protocol
+		write := func(frame []byte) bool {
+			if frame == nil {
+				return true
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				log.Printf("Failed to write text message: %v", err)
+				cancel()
+				return false
+			}
+			return true
+		}
+		// Pipeline results may still arrive after the transcript ended; the
+		// socket closes once both are done.
+		transcript, results := events, pipes.out
 		for {
+			if transcript == nil && results == nil {
+				// Stream is over: close gracefully so the reader unblocks too
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "stream ended"),
+					time.Now().Add(time.Second))
+				_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				return
+			}
 			select {
-			case text, ok := <-textToClient:
+			case ev, ok := <-transcript:
+				if !ok {
+					log.Println("Transcript event channel closed")
+					transcript = nil
+					pipes.finish()
+					continue
+				}
+				if !write(enc.encode(ev)) {
+					return
+				}
+				if ev.Type == pcas.EventFinal {
+					if pipes.keepRefs {
+						h.refs.record(opts.UserID, opts.SessionID, refItem{Kind: refTranscript, ID: ev.Segment.ID, Text: ev.Segment.Text})
+					}
+					for _, p := range pipes.feed(ev.Segment) {
+						if !write(enc.pipeline(p)) {
+							return
+						}
+					}
+				}
+			case p, ok := <-results:
 				if !ok {
-					log.Println("Text channel closed")
+					results = nil
+					continue
+				}
+				if !write(enc.pipeline(p)) {
 					return
 				}
-				if err := conn.WriteMessage(websocket.TextMessage, text); err != nil {
-					log.Printf("Failed to write text message: %v", err)
-					cancel()
+			case a := <-acks:
+				// encoded here so seq numbers follow wire order
+				if !write(enc.ack(a)) {
 					return
 				}
 			case <-ctx.Done():
@@ -95,8 +224,34 @@ func (h *Handler) HandleTranscription(c *gin.Context) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(audioFromClient)
-		
+		audioClosed := false
+		closeAudio := func() {
+			if !audioClosed {
+				audioClosed = true
+				close(audioFromClient)
+			}
+		}
+		defer closeAudio()
+		paused := false
+
+		reply := func(a controlAck) {
+			select {
+			case acks <- a:
+			case <-ctx.Done():
+			}
+		}
+		control := func(ctrl pcas.StreamControl) error {
+			if !started {
+				return fmt.Errorf("stream not started")
+			}
+			select {
+			case controls <- ctrl:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
 		for {
 			messageType, message, err := conn.ReadMessage()
 			if err != nil {
@@ -108,17 +263,121 @@ func (h *Handler) HandleTranscription(c *gin.Context) {
 			}
 
 			if messageType == websocket.BinaryMessage {
+				// paused: audio is dropped but the PCAS stream stays open
+				if audioClosed || paused {
+					continue
+				}
+				if !started {
+					startStream()
+				}
 				select {
 				case audioFromClient <- message:
 				case <-ctx.Done():
 					return
 				}
-			} else {
-				log.Printf("Received non-binary message type: %d", messageType)
+				continue
+			}
+
+			msg, err := parseControl(message)
+			if err != nil {
+				reply(controlAck{For: msg.Type, ID: msg.ID, Error: err.Error()})
+				continue
 			}
+			var ctrlErr error
+			switch msg.Type {
+			case controlStart:
+				next := pipelines
+				if msg.Pipelines != nil && !started {
+					next, ctrlErr = h.bindPipelines(msg.Pipelines)
+					if ctrlErr == nil && len(next) > 0 && enc.protocol != protocolV2 {
+						ctrlErr = errPipelinesNeedV2
+					}
+				}
+				if ctrlErr == nil {
+					ctrlErr = applyStart(&opts, msg, started, ident.Authenticated)
+				}
+				if ctrlErr == nil {
+					pipelines = next
+					startStream()
+				}
+			case controlPause:
+				paused = true
+			case controlResume:
+				paused = false
+			case controlMark:
+				ctrlErr = control(pcas.StreamControl{Type: pcas.ControlMark, Label: msg.Label})
+			case controlFlush:
+				ctrlErr = control(pcas.StreamControl{Type: pcas.ControlFlush})
+			case controlEnd:
+				// Graceful end: ClientEnd goes to PCAS, final results still flow
+				// back, and the socket closes after the "ended" status.
+				if !started {
+					ctrlErr = fmt.Errorf("stream not started")
+				} else {
+					closeAudio()
+				}
+			}
+			a := controlAck{For: msg.Type, ID: msg.ID, OK: ctrlErr == nil}
+			if ctrlErr != nil {
+				a.Error = ctrlErr.Error()
+			}
+			reply(a)
 		}
 	}()
 
 	wg.Wait()
 	log.Printf("WebSocket connection closed")
 }
+
+// applyStart folds a "start" control message into the stream options.
+func applyStart(opts *pcas.StreamOptions, msg controlMessage, started, authenticated bool) error {
+	if started {
+		return fmt.Errorf("stream already started")
+	}
+	if msg.Segmenter != "" {
+		if _, err := distiller.NewSegmenter(msg.Segmenter); err != nil {
+			return err
+		}
+		opts.Attributes["segmenter"] = msg.Segmenter
+	}
+	if msg.Language != "" {
+		opts.Attributes["language"] = msg.Language
+	}
+	if msg.SampleRate > 0 {
+		opts.Attributes["sample_rate"] = strconv.Itoa(msg.SampleRate)
+	}
+	if msg.UserID != "" && msg.UserID != opts.UserID {
+		if authenticated {
+			return fmt.Errorf("userId is fixed by the auth token")
+		}
+		opts.UserID = msg.UserID
+	}
+	if msg.SessionID != "" {
+		opts.SessionID = msg.SessionID
+	}
+	if msg.Course != "" {
+		opts.Course = msg.Course
+	}
+	if msg.Title != "" {
+		opts.Title = msg.Title
+	}
+	if len(msg.Tags) > 0 {
+		opts.Tags = parseTags(strings.Join(msg.Tags, ","))
+	}
+	return nil
+}
+
+// parseTags splits a comma-separated tag list, dropping blanks and duplicates.
+func parseTags(s string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
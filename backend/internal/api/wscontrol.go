@@ -0,0 +1,64 @@
+package api
+
+import (
+    "encoding/json"
+    "fmt"
+)
+
+// Control message types a client may send as JSON text frames on /ws/transcribe.
+const (
+    controlStart  = "start"
+    controlPause  = "pause"
+    controlResume = "resume"
+    controlMark   = "mark"
+    controlFlush  = "flush"
+    controlEnd    = "end"
+)
+
+// controlMessage is a client text frame, e.g.
+// {"type":"start","language":"en","segmenter":"latin","sampleRate":16000}
+// {"type":"mark","label":"exam hint","id":"m1"}
+type controlMessage struct {
+    Type string `json:"type"`
+    // ID is echoed back in the acknowledgement so clients can correlate.
+    ID string `json:"id,omitempty"`
+
+    // start
+    Language   string   `json:"language,omitempty"`
+    Segmenter  string   `json:"segmenter,omitempty"`
+    UserID     string   `json:"userId,omitempty"`
+    SessionID  string   `json:"sessionId,omitempty"`
+    SampleRate int      `json:"sampleRate,omitempty"`
+    Course     string   `json:"course,omitempty"`
+    Title      string   `json:"title,omitempty"`
+    Tags       []string `json:"tags,omitempty"`
+    // Pipelines maps capability names to start bodies, e.g.
+    // {"translate":{"targetLang":"ja"},"summarize":{"mode":"rolling"}}
+    Pipelines map[string]map[string]any `json:"pipelines,omitempty"`
+
+    // mark
+    Label string `json:"label,omitempty"`
+}
+
+// controlAck acknowledges one control message.
+type controlAck struct {
+    For   string `json:"for"`
+    ID    string `json:"id,omitempty"`
+    OK    bool   `json:"ok"`
+    Error string `json:"error,omitempty"`
+}
+
+func parseControl(b []byte) (controlMessage, error) {
+    var m controlMessage
+    if err := json.Unmarshal(b, &m); err != nil {
+        return m, fmt.Errorf("invalid control message: %w", err)
+    }
+    switch m.Type {
+    case controlStart, controlPause, controlResume, controlMark, controlFlush, controlEnd:
+        return m, nil
+    case "":
+        return m, fmt.Errorf("control message is missing \"type\"")
+    default:
+        return m, fmt.Errorf("unknown control message type %q", m.Type)
+    }
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gorilla/websocket"
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+    "github.com/pcas/dreams-cli/backend/internal/config"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+func TestParseControl(t *testing.T) {
+    cases := []struct {
+        in   string
+        typ  string
+        err  string
+    }{
+        {`{"type":"start","language":"en","sampleRate":16000}`, controlStart, ""},
+        {`{"type":"pause"}`, controlPause, ""},
+        {`{"type":"resume"}`, controlResume, ""},
+        {`{"type":"mark","label":"exam hint","id":"m1"}`, controlMark, ""},
+        {`{"type":"flush"}`, controlFlush, ""},
+        {`{"type":"end"}`, controlEnd, ""},
+        {`{"id":"x"}`, "", `control message is missing "type"`},
+        {`{"type":"rewind","id":"x"}`, "rewind", `unknown control message type "rewind"`},
+        {`hello`, "", "invalid control message: invalid character 'h' looking for beginning of value"},
+    }
+    for _, tc := range cases {
+        m, err := parseControl([]byte(tc.in))
+        if m.Type != tc.typ {
+            t.Errorf("parseControl(%s) type = %q, want %q", tc.in, m.Type, tc.typ)
+        }
+        if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+            t.Errorf("parseControl(%s) error = %v, want %q", tc.in, err, tc.err)
+        }
+    }
+}
+
+func TestApplyStart(t *testing.T) {
+    cases := []struct {
+        name          string
+        msg           string
+        started       bool
+        authenticated bool
+        err           string
+        want          pcas.StreamOptions
+    }{
+        {"fills the options", `{"type":"start","language":"en","segmenter":"latin","sampleRate":16000,"sessionId":"s2","course":"bio","title":"Week 3","tags":["exam"," lab","exam"]}`, false, false, "",
+            pcas.StreamOptions{UserID: "u1", SessionID: "s2", Course: "bio", Title: "Week 3", Tags: []string{"exam", "lab"},
+                Attributes: map[string]string{"language": "en", "segmenter": "latin", "sample_rate": "16000"}}},
+        {"empty start keeps the query options", `{"type":"start"}`, false, false, "",
+            pcas.StreamOptions{UserID: "u1", SessionID: "s1", Attributes: map[string]string{}}},
+        {"anonymous userId", `{"type":"start","userId":"u2"}`, false, false, "",
+            pcas.StreamOptions{UserID: "u2", SessionID: "s1", Attributes: map[string]string{}}},
+        {"same userId with a token", `{"type":"start","userId":"u1"}`, false, true, "",
+            pcas.StreamOptions{UserID: "u1", SessionID: "s1", Attributes: map[string]string{}}},
+        {"userId fixed by the token", `{"type":"start","userId":"u2"}`, false, true, "userId is fixed by the auth token", pcas.StreamOptions{}},
+        {"unknown segmenter", `{"type":"start","segmenter":"klingon"}`, false, false, "unknown segmenter", pcas.StreamOptions{}},
+        {"already started", `{"type":"start"}`, true, false, "stream already started", pcas.StreamOptions{}},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            msg, err := parseControl([]byte(tc.msg))
+            if err != nil {
+                t.Fatal(err)
+            }
+            opts := pcas.StreamOptions{UserID: "u1", SessionID: "s1", Attributes: map[string]string{}}
+            err = applyStart(&opts, msg, tc.started, tc.authenticated)
+            if tc.err != "" {
+                if err == nil || !strings.Contains(err.Error(), tc.err) {
+                    t.Fatalf("error = %v, want %q", err, tc.err)
+                }
+                return
+            }
+            if err != nil || !reflect.DeepEqual(opts, tc.want) {
+                t.Fatalf("options = %+v, %v; want %+v", opts, err, tc.want)
+            }
+        })
+    }
+}
+
+// transcribeEcho plays PCAS for /ws/transcribe: every audio chunk comes back
+// as transcript text and ClientEnd gets ServerEnd.
+type transcribeEcho struct {
+    busv1.UnimplementedEventBusServiceServer
+
+    mu     sync.Mutex
+    chunks []string
+}
+
+func (f *transcribeEcho) InteractStream(stream busv1.EventBusService_InteractStreamServer) error {
+    if _, err := stream.Recv(); err != nil {
+        return err
+    }
+    ready := &busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Ready{Ready: &busv1.StreamReady{StreamId: "pcas-1"}}}
+    if err := stream.Send(ready); err != nil {
+        return err
+    }
+    for {
+        req, err := stream.Recv()
+        if err != nil {
+            return err
+        }
+        if req.GetClientEnd() != nil {
+            return stream.Send(&busv1.InteractResponse{ResponseType: &busv1.InteractResponse_ServerEnd{ServerEnd: &busv1.StreamEnd{}}})
+        }
+        chunk := req.GetData().GetContent()
+        f.mu.Lock()
+        f.chunks = append(f.chunks, string(chunk))
+        f.mu.Unlock()
+        if err := stream.Send(&busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Data{Data: &busv1.StreamData{Content: chunk}}}); err != nil {
+            return err
+        }
+    }
+}
+
+func (f *transcribeEcho) Publish(context.Context, *eventsv1.Event) (*busv1.PublishResponse, error) {
+    return &busv1.PublishResponse{}, nil
+}
+
+// wsFrames reads v2 frames from a transcription socket.
+type wsFrames struct {
+    t    *testing.T
+    conn *websocket.Conn
+}
+
+type wsFrame struct {
+    Type string          `json:"type"`
+    Data json.RawMessage `json:"data"`
+}
+
+// until reads frames until one of type typ satisfies match and returns its data.
+func (r wsFrames) until(typ string, match func(json.RawMessage) bool) json.RawMessage {
+    r.t.Helper()
+    _ = r.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    for {
+        _, b, err := r.conn.ReadMessage()
+        if err != nil {
+            r.t.Fatalf("waiting for %s: %v", typ, err)
+        }
+        var f wsFrame
+        if err := json.Unmarshal(b, &f); err != nil {
+            r.t.Fatalf("frame %s: %v", b, err)
+        }
+        if f.Type == typ && (match == nil || match(f.Data)) {
+            return f.Data
+        }
+    }
+}
+
+// control sends msg and returns its acknowledgement.
+func (r wsFrames) control(msg string) controlAck {
+    r.t.Helper()
+    var m struct {
+        ID string `json:"id"`
+    }
+    _ = json.Unmarshal([]byte(msg), &m)
+    if err := r.conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+        r.t.Fatal(err)
+    }
+    var a controlAck
+    r.until("ack", func(d json.RawMessage) bool {
+        _ = json.Unmarshal(d, &a)
+        return a.ID == m.ID
+    })
+    return a
+}
+
+func TestTranscriptionControls(t *testing.T) {
+    f := &transcribeEcho{}
+    cfg := &config.Config{}
+    cfg.User.ID = "default-user"
+    cfg.PCAS.EventType = "capability.streaming.transcribe.v1"
+    h := &Handler{config: cfg, pool: servePool(t, f), refs: newRefStore(0, 0, 0)}
+    router := gin.New()
+    router.GET("/ws/transcribe", h.HandleTranscription)
+    srv := httptest.NewServer(router)
+    defer srv.Close()
+
+    conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/transcribe?protocol=v2", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    r := wsFrames{t: t, conn: conn}
+    ack := func(msg string, ok bool, errText string) {
+        t.Helper()
+        a := r.control(msg)
+        if a.OK != ok || a.Error != errText {
+            t.Fatalf("%s: ack = %+v, want ok=%t error %q", msg, a, ok, errText)
+        }
+    }
+    audio := func(text string) {
+        t.Helper()
+        if err := conn.WriteMessage(websocket.BinaryMessage, []byte(text)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    status := func(want string) func(json.RawMessage) bool {
+        return func(d json.RawMessage) bool {
+            var s pcas.StreamStatus
+            _ = json.Unmarshal(d, &s)
+            return s.Status == want
+        }
+    }
+
+    // nothing to control before the stream exists
+    ack(`{"type":"mark","id":"1","label":"early"}`, false, "stream not started")
+    ack(`{"type":"flush","id":"2"}`, false, "stream not started")
+    ack(`{"type":"end","id":"3"}`, false, "stream not started")
+    if a := r.control(`{"type":"rewind","id":"4"}`); a.OK || a.For != "rewind" || a.Error != `unknown control message type "rewind"` {
+        t.Fatalf("unknown type ack = %+v", a)
+    }
+
+    ack(`{"type":"start","id":"5","language":"zh"}`, true, "")
+    r.until("status", status("ready"))
+    ack(`{"type":"start","id":"6"}`, false, "stream already started")
+
+    // audio sent while paused never reaches PCAS
+    ack(`{"type":"pause","id":"7"}`, true, "")
+    audio("暂停时说的。")
+    ack(`{"type":"resume","id":"8"}`, true, "")
+    audio("没有句号的一句")
+    r.until("partial", nil)
+
+    ack(`{"type":"mark","id":"9","label":"exam"}`, true, "")
+    var mark pcas.MarkInfo
+    _ = json.Unmarshal(r.until("mark", nil), &mark)
+    if mark.Label != "exam" || mark.At == 0 {
+        t.Fatalf("mark = %+v", mark)
+    }
+
+    ack(`{"type":"flush","id":"10"}`, true, "")
+    var seg pcas.SegmentInfo
+    _ = json.Unmarshal(r.until("final", nil), &seg)
+    if seg.Text != "没有句号的一句" || seg.Reason != "flush" {
+        t.Fatalf("flushed segment = %+v", seg)
+    }
+
+    // end lets the stream finish gracefully
+    ack(`{"type":"end","id":"11"}`, true, "")
+    r.until("status", status("ended"))
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if want := []string{"没有句号的一句"}; !reflect.DeepEqual(f.chunks, want) {
+        t.Fatalf("PCAS got %q, want %q", f.chunks, want)
+    }
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sync/atomic"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gorilla/websocket"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+// Protocol versions of /ws/transcribe. v1 relays raw ASR text frames; v2 wraps
+// everything in a typed JSON envelope.
+const (
+    protocolRaw = 1
+    protocolV2  = 2
+
+    // subprotocolV2 selects the v2 envelope via Sec-WebSocket-Protocol.
+    subprotocolV2 = "dreamscribe.v2"
+    // subprotocolBearer prefixes an auth token offered as a subprotocol next
+    // to subprotocolV2, which is the one the server accepts.
+    subprotocolBearer = "bearer."
+)
+
+// wsEnvelope is the v2 frame: {"v":2,"type":"final","seq":3,"ts":...,"data":{...}}
+type wsEnvelope struct {
+    V    int    `json:"v"`
+    Type string `json:"type"`
+    Seq  uint64 `json:"seq"`
+    Ts   int64  `json:"ts"`
+    Data any    `json:"data,omitempty"`
+}
+
+type wsPartial struct {
+    Text string `json:"text"`
+}
+
+type wsMemoryPublished struct {
+    SegmentID string `json:"segmentId"`
+    EventID   string `json:"eventId"`
+}
+
+type wsError struct {
+    Code    string `json:"code"`
+    Message string `json:"message"`
+}
+
+// wsPipeline is a result of a transcription pipeline. Event is ready, delta,
+// done or error as on the SSE routes; SegmentID links it to the final segment
+// it was produced from (segment mode) or the latest one sent (rolling mode).
+type wsPipeline struct {
+    Pipeline  string `json:"pipeline"`
+    Event     string `json:"event"`
+    SegmentID string `json:"segmentId,omitempty"`
+    // Target is the fanout value, e.g. the language of a translation.
+    Target    string `json:"target,omitempty"`
+    Text      string `json:"text,omitempty"`
+    StreamID  string `json:"streamId,omitempty"`
+    Code      string `json:"code,omitempty"`
+    Message   string `json:"message,omitempty"`
+}
+
+// negotiateProtocol picks the wire protocol from ?protocol=v2 or the
+// subprotocol agreed during the upgrade (see upgrader.Subprotocols).
+func negotiateProtocol(c *gin.Context, conn *websocket.Conn) int {
+    if v := c.Query("protocol"); v == "v2" || v == "2" {
+        return protocolV2
+    }
+    if conn.Subprotocol() == subprotocolV2 {
+        return protocolV2
+    }
+    return protocolRaw
+}
+
+// errPipelinesNeedV2 rejects pipelines on a v1 connection, which has no
+// frame to deliver their results in.
+var errPipelinesNeedV2 = errors.New("pipelines require protocol v2 (?protocol=v2 or subprotocol " + subprotocolV2 + ")")
+
+// requestsV2 reports whether the upgrade request asks for v2, before the
+// connection exists to negotiate it.
+func requestsV2(c *gin.Context) bool {
+    if v := c.Query("protocol"); v == "v2" || v == "2" {
+        return true
+    }
+    for _, p := range websocket.Subprotocols(c.Request) {
+        if p == subprotocolV2 {
+            return true
+        }
+    }
+    return false
+}
+
+// transcriptEncoder turns gateway events into text frames for one connection.
+type transcriptEncoder struct {
+    protocol int
+    seq      atomic.Uint64
+}
+
+// encode returns the frame for ev, or nil if the protocol has no representation for it.
+func (e *transcriptEncoder) encode(ev pcas.TranscriptEvent) []byte {
+    if e.protocol == protocolV2 {
+        return e.envelope(ev.Type, eventData(ev))
+    }
+
+    // Raw (v1) mode: ASR bytes as-is plus the legacy error frame. Older
+    // clients append every text frame to the transcript, so nothing else is sent.
+    switch ev.Type {
+    case pcas.EventPartial:
+        return ev.Raw
+    case pcas.EventError:
+        return []byte(fmt.Sprintf("{\"error\":%q}", ev.Message))
+    }
+    return nil
+}
+
+// ack encodes a control acknowledgement, or returns nil in raw mode.
+func (e *transcriptEncoder) ack(a controlAck) []byte {
+    if e.protocol != protocolV2 {
+        return nil
+    }
+    return e.envelope("ack", a)
+}
+
+// pipeline encodes a pipeline result, or returns nil in raw mode.
+func (e *transcriptEncoder) pipeline(p wsPipeline) []byte {
+    if e.protocol != protocolV2 {
+        return nil
+    }
+    return e.envelope("pipeline", p)
+}
+
+func eventData(ev pcas.TranscriptEvent) any {
+    switch ev.Type {
+    case pcas.EventPartial:
+        return wsPartial{Text: string(ev.Raw)}
+    case pcas.EventFinal:
+        return ev.Segment
+    case pcas.EventMemoryPublished:
+        return wsMemoryPublished{SegmentID: ev.Segment.ID, EventID: ev.EventID}
+    case pcas.EventStatus:
+        return ev.Status
+    case pcas.EventError:
+        return wsError{Code: ev.Code, Message: ev.Message}
+    case pcas.EventMark:
+        return ev.Mark
+    }
+    return nil
+}
+
+func (e *transcriptEncoder) envelope(typ string, data any) []byte {
+    b, _ := json.Marshal(wsEnvelope{
+        V:    protocolV2,
+        Type: typ,
+        Seq:  e.seq.Add(1),
+        Ts:   time.Now().UnixMilli(),
+        Data: data,
+    })
+    return b
+}
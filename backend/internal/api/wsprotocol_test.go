@@ -0,0 +1,83 @@
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pcas/dreams-cli/backend/internal/pcas"
+)
+
+func TestTranscriptEncoderRaw(t *testing.T) {
+    enc := &transcriptEncoder{protocol: protocolRaw}
+    cases := []struct {
+        ev   pcas.TranscriptEvent
+        want string
+    }{
+        {pcas.TranscriptEvent{Type: pcas.EventPartial, Raw: []byte("你好")}, "你好"},
+        {pcas.TranscriptEvent{Type: pcas.EventError, Code: "pcas_error", Message: "boom"}, `{"error":"boom"}`},
+        // everything else would end up in a legacy client's transcript
+        {pcas.TranscriptEvent{Type: pcas.EventFinal, Segment: &pcas.SegmentInfo{ID: "seg-0"}}, ""},
+        {pcas.TranscriptEvent{Type: pcas.EventMemoryPublished, Segment: &pcas.SegmentInfo{ID: "seg-0"}, EventID: "ev-1"}, ""},
+        {pcas.TranscriptEvent{Type: pcas.EventStatus, Status: &pcas.StreamStatus{Status: "ready"}}, ""},
+        {pcas.TranscriptEvent{Type: pcas.EventStatus, Status: &pcas.StreamStatus{Status: "reconnecting"}}, ""},
+        {pcas.TranscriptEvent{Type: pcas.EventStatus, Status: &pcas.StreamStatus{Status: "ended"}}, ""},
+        {pcas.TranscriptEvent{Type: pcas.EventMark, Mark: &pcas.MarkInfo{Label: "x"}}, ""},
+    }
+    for _, tc := range cases {
+        if got := string(enc.encode(tc.ev)); got != tc.want {
+            t.Errorf("encode(%s) = %q, want %q", tc.ev.Type, got, tc.want)
+        }
+    }
+    if b := enc.ack(controlAck{For: "pause", OK: true}); b != nil {
+        t.Errorf("ack = %s, want nothing", b)
+    }
+    if b := enc.pipeline(wsPipeline{Pipeline: "translate", Event: sseDelta, Text: "hi"}); b != nil {
+        t.Errorf("pipeline = %s, want nothing", b)
+    }
+}
+
+func TestTranscriptEncoderV2(t *testing.T) {
+    enc := &transcriptEncoder{protocol: protocolV2}
+    frames := [][]byte{
+        enc.encode(pcas.TranscriptEvent{Type: pcas.EventStatus, Status: &pcas.StreamStatus{Status: "ended"}}),
+        enc.ack(controlAck{For: "pause", OK: true}),
+        enc.pipeline(wsPipeline{Pipeline: "translate", Event: sseDelta, Text: "hi"}),
+    }
+    for i, want := range []string{"status", "ack", "pipeline"} {
+        var env wsEnvelope
+        if err := json.Unmarshal(frames[i], &env); err != nil {
+            t.Fatalf("frame %d: %v", i, err)
+        }
+        if env.V != 2 || env.Type != want || env.Seq != uint64(i+1) {
+            t.Errorf("frame %d = %s, want type %s seq %d", i, frames[i], want, i+1)
+        }
+    }
+}
+
+func TestRequestsV2(t *testing.T) {
+    cases := []struct {
+        url         string
+        subprotocol string
+        want        bool
+    }{
+        {"/ws/transcribe", "", false},
+        {"/ws/transcribe?protocol=v2", "", true},
+        {"/ws/transcribe?protocol=2", "", true},
+        {"/ws/transcribe?protocol=v1", "", false},
+        {"/ws/transcribe", "other, dreamscribe.v2", true},
+        {"/ws/transcribe", "other", false},
+    }
+    for _, tc := range cases {
+        c, _ := gin.CreateTestContext(httptest.NewRecorder())
+        c.Request = httptest.NewRequest(http.MethodGet, tc.url, nil)
+        if tc.subprotocol != "" {
+            c.Request.Header.Set("Sec-WebSocket-Protocol", tc.subprotocol)
+        }
+        if got := requestsV2(c); got != tc.want {
+            t.Errorf("requestsV2(%s, %q) = %v, want %v", tc.url, tc.subprotocol, got, tc.want)
+        }
+    }
+}
@@ -3,18 +3,154 @@ package config
 import (
     "fmt"
     "os"
+    "strings"
+    "time"
+
     "github.com/spf13/viper"
 )
 
 type Config struct {
-	Server ServerConfig `mapstructure:"server"`
-	PCAS   PCASConfig   `mapstructure:"pcas"`
-	User   UserConfig   `mapstructure:"user"`
+	Server    ServerConfig    `mapstructure:"server"`
+	PCAS      PCASConfig      `mapstructure:"pcas"`
+	User      UserConfig      `mapstructure:"user"`
+	Distiller DistillerConfig `mapstructure:"distiller"`
+	Sessions  SessionsConfig  `mapstructure:"sessions"`
+	// Capabilities are the presets served under /api/<name>/*; entries named
+	// translate, summarize or chat override the built-in ones field by field.
+	Capabilities []CapabilityConfig `mapstructure:"capabilities"`
+	Chat         ChatConfig         `mapstructure:"chat"`
+	Search       SearchConfig       `mapstructure:"search"`
+}
+
+// SearchConfig tunes semantic search over past memory events (chat RAG).
+type SearchConfig struct {
+    TopK     int `mapstructure:"topK"`
+    MaxTopK  int `mapstructure:"maxTopK"`
+    // MinScore drops hits below this similarity (0..1).
+    MinScore float64       `mapstructure:"minScore"`
+    Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+// ChatConfig bounds the server-side history of capabilities with history
+// enabled (the built-in chat).
+type ChatConfig struct {
+    MaxTurns     int `mapstructure:"maxTurns"`
+    MaxTurnChars int `mapstructure:"maxTurnChars"`
+    // ContextChars is how much history is sent with each message.
+    ContextChars int           `mapstructure:"contextChars"`
+    MaxSessions  int           `mapstructure:"maxSessions"`
+    TTL          time.Duration `mapstructure:"ttl"`
+    // RefsChars bounds the referenced text sent with a message; SessionItems
+    // is how many transcript segments and outputs each session keeps for refs.
+    RefsChars    int `mapstructure:"refsChars"`
+    SessionItems int `mapstructure:"sessionItems"`
+}
+
+// CapabilityConfig is a named preset for one PCAS streaming capability.
+type CapabilityConfig struct {
+    Name      string `mapstructure:"name"`
+    EventType string `mapstructure:"eventType"`
+    Model     string `mapstructure:"model"`
+    // System is a prompt template: {{var}} is replaced by the stream attribute
+    // var, or by Vars[var] when the request does not set it.
+    System string            `mapstructure:"system"`
+    Vars   map[string]string `mapstructure:"vars"`
+    // Input is the request field with the text of one-shot calls (default "text").
+    Input  string            `mapstructure:"input"`
+    Params []CapabilityParam `mapstructure:"params"`
+    // Attrs declares the attributes clients may set and how they are
+    // validated; attributes without a rule are rejected.
+    Attrs []CapabilityAttr `mapstructure:"attrs"`
+    // Streaming adds /start and /stream routes for incremental input.
+    // The switches below are pointers so an override can turn a built-in
+    // feature off; read them with Enabled.
+    Streaming *bool `mapstructure:"streaming"`
+    // Path replaces the one-shot route /api/<name>/run.
+    Path string `mapstructure:"path"`
+    // History keeps the turns of each request sessionId and sends them with
+    // the next message; adds <path>/history routes.
+    History *bool `mapstructure:"history"`
+    // Refs resolves the request's refs against the session transcript and
+    // recorded outputs and sends the cited text along.
+    Refs *bool `mapstructure:"refs"`
+    // Output is the ref type completed answers are recorded under for the
+    // request's sessionId (e.g. translation, summary); empty records nothing.
+    Output string `mapstructure:"output"`
+    // RAG lets requests search the user's past memory events and send the
+    // top hits along.
+    RAG *bool `mapstructure:"rag"`
+    // Pipeline lets a transcription feed its distilled sentences into this
+    // capability: "segment" runs one stream per sentence, "rolling" sends
+    // every sentence into one long stream. Empty disallows it.
+    Pipeline string `mapstructure:"pipeline"`
+    // Fanout lets one request run a stream per value of a list field, e.g.
+    // one translation per target language.
+    Fanout CapabilityFanout `mapstructure:"fanout"`
+}
+
+// Enabled reads an optional capability switch; unset means off.
+func Enabled(b *bool) bool {
+    return b != nil && *b
+}
+
+// CapabilityAttr is the schema of one client-settable attribute. Name "*"
+// matches any attribute without a rule of its own.
+type CapabilityAttr struct {
+    Name string `mapstructure:"name"`
+    // Type is string (default), int, number or bool.
+    Type      string   `mapstructure:"type"`
+    MaxLength int      `mapstructure:"maxLength"`
+    // Values, when set, is the exhaustive list of accepted values.
+    Values []string `mapstructure:"values"`
+}
+
+// CapabilityParam copies the request body field Field into stream attribute Attr.
+type CapabilityParam struct {
+    Field string `mapstructure:"field"`
+    Attr  string `mapstructure:"attr"`
+}
+
+// CapabilityFanout runs one stream per value of the request list Field, each
+// with one value in stream attribute Attr; at most Max values (default 5).
+type CapabilityFanout struct {
+    Field string `mapstructure:"field"`
+    Attr  string `mapstructure:"attr"`
+    Max   int    `mapstructure:"max"`
+}
+
+// SessionsConfig bounds the translate/summarize streams opened via /api/*/start.
+type SessionsConfig struct {
+    IdleTTL         time.Duration `mapstructure:"idleTTL"`
+    MaxLifetime     time.Duration `mapstructure:"maxLifetime"`
+    JanitorInterval time.Duration `mapstructure:"janitorInterval"`
+    // CompletedTTL keeps a finished stream for late readers (other tabs,
+    // Last-Event-ID reconnects) before it is dropped.
+    CompletedTTL    time.Duration `mapstructure:"completedTTL"`
+    // MaxPerUser counts token-authenticated users by user id and anonymous
+    // callers by client address (see Server.TrustedProxies).
+    MaxPerUser      int           `mapstructure:"maxPerUser"`
+    MaxTotal        int           `mapstructure:"maxTotal"`
+    // ReplayEvents is the per-stream ring buffer used for fan-out and Last-Event-ID replay.
+    ReplayEvents int `mapstructure:"replayEvents"`
+}
+
+// DistillerConfig forces memory segments out when the ASR emits no sentence
+// punctuation. Unset fields get defaults; 0 disables the matching trigger.
+type DistillerConfig struct {
+    MaxRunes       int           `mapstructure:"maxRunes"`
+    MaxIdle        time.Duration `mapstructure:"maxIdle"`
+    SoftBreakRunes int           `mapstructure:"softBreakRunes"`
 }
 
 type ServerConfig struct {
 	Host string `mapstructure:"host"`
 	Port string `mapstructure:"port"`
+	// SSEHeartbeat is how often open SSE responses get a heartbeat event;
+	// 0 disables heartbeats, leaving it unset means 15s.
+	SSEHeartbeat time.Duration `mapstructure:"sseHeartbeat"`
+	// TrustedProxies are the addresses/CIDRs whose X-Forwarded-For header is
+	// believed; empty means the peer address is the client address.
+	TrustedProxies []string `mapstructure:"trustedProxies"`
 }
 
 type PCASConfig struct {
@@ -25,10 +161,89 @@ type PCASConfig struct {
     SummarizeEventType string `mapstructure:"summarizeEventType"`
     ChatEventType      string `mapstructure:"chatEventType"`
     AdminToken         string `mapstructure:"adminToken"`
+    Pool               PoolConfig `mapstructure:"pool"`
+    Reconnect          ReconnectConfig `mapstructure:"reconnect"`
+    TLS                TLSConfig  `mapstructure:"tls"`
+    Outbox             OutboxConfig `mapstructure:"outbox"`
+    Publish            PublishConfig `mapstructure:"publish"`
+    Subscribe          SubscribeConfig `mapstructure:"subscribe"`
+}
+
+// SubscribeConfig controls the Subscribe stream that receives events PCAS
+// pushes on its own, such as hints.
+type SubscribeConfig struct {
+    Enabled bool `mapstructure:"enabled"`
+    // ClientID should stay the same across restarts; defaults to dreamscribe-<hostname>.
+    ClientID       string        `mapstructure:"clientId"`
+    InitialBackoff time.Duration `mapstructure:"initialBackoff"`
+    MaxBackoff     time.Duration `mapstructure:"maxBackoff"`
+    // Buffer is the number of events queued per browser before they are dropped.
+    Buffer int `mapstructure:"buffer"`
+}
+
+// PublishConfig tunes the background worker that publishes memory events.
+type PublishConfig struct {
+    QueueSize    int           `mapstructure:"queueSize"`
+    MaxBatch     int           `mapstructure:"maxBatch"`
+    Concurrency  int           `mapstructure:"concurrency"`
+    Timeout      time.Duration `mapstructure:"timeout"`
+    DrainTimeout time.Duration `mapstructure:"drainTimeout"`
+}
+
+// OutboxConfig is the on-disk queue that keeps memory events across PCAS outages and restarts.
+type OutboxConfig struct {
+    // Dir is resolved against the working directory when relative.
+    Dir            string        `mapstructure:"dir"`
+    InitialBackoff time.Duration `mapstructure:"initialBackoff"`
+    MaxBackoff     time.Duration `mapstructure:"maxBackoff"`
+    PublishTimeout time.Duration `mapstructure:"publishTimeout"`
+}
+
+// TLSConfig secures the gRPC link to PCAS. With enabled, certFile/keyFile add a
+// client certificate for mutual TLS; without it, setting any field is a
+// startup error rather than a silent fallback to plaintext.
+type TLSConfig struct {
+    Enabled            bool   `mapstructure:"enabled"`
+    CAFile             string `mapstructure:"caFile"`
+    CertFile           string `mapstructure:"certFile"`
+    KeyFile            string `mapstructure:"keyFile"`
+    ServerName         string `mapstructure:"serverName"`
+    InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+}
+
+// PoolConfig tunes the shared gRPC connection pool to PCAS.
+type PoolConfig struct {
+    Size int `mapstructure:"size"`
+    // KeepaliveTime must not be below the ping interval PCAS permits
+    // (5m for grpc-go servers unless they lower EnforcementPolicy.MinTime).
+    KeepaliveTime    time.Duration `mapstructure:"keepaliveTime"`
+    KeepaliveTimeout time.Duration `mapstructure:"keepaliveTimeout"`
+    // KeepaliveWithoutStream pings idle connections too; PCAS must set
+    // EnforcementPolicy.PermitWithoutStream.
+    KeepaliveWithoutStream bool          `mapstructure:"keepaliveWithoutStream"`
+    WarmupTimeout          time.Duration `mapstructure:"warmupTimeout"`
+}
+
+// ReconnectConfig controls recovery of a live transcription stream when PCAS drops it.
+type ReconnectConfig struct {
+    MaxAttempts    int           `mapstructure:"maxAttempts"`
+    InitialBackoff time.Duration `mapstructure:"initialBackoff"`
+    MaxBackoff     time.Duration `mapstructure:"maxBackoff"`
+    BufferChunks   int           `mapstructure:"bufferChunks"`
 }
 
 type UserConfig struct {
+	// ID is the fallback identity when a connection supplies none.
 	ID string `mapstructure:"id"`
+	// Tokens maps bearer tokens to user ids (Authorization header or ?token=).
+	Tokens []UserToken `mapstructure:"tokens"`
+	// RequireToken rejects connections that do not present a known token.
+	RequireToken bool `mapstructure:"requireToken"`
+}
+
+type UserToken struct {
+	Token string `mapstructure:"token"`
+	ID    string `mapstructure:"id"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -55,6 +270,158 @@ func LoadConfig(path string) (*Config, error) {
         config.PCAS.ChatEventType = "capability.streaming.chat.v1"
     }
 
+    if !viper.IsSet("server.sseHeartbeat") {
+        config.Server.SSEHeartbeat = 15 * time.Second
+    }
+
+    // Connection pool defaults
+    if config.PCAS.Pool.Size <= 0 {
+        config.PCAS.Pool.Size = 4
+    }
+    if config.PCAS.Pool.KeepaliveTime <= 0 {
+        config.PCAS.Pool.KeepaliveTime = 5 * time.Minute
+    }
+    if config.PCAS.Pool.KeepaliveTimeout <= 0 {
+        config.PCAS.Pool.KeepaliveTimeout = 10 * time.Second
+    }
+    if config.PCAS.Pool.WarmupTimeout <= 0 {
+        config.PCAS.Pool.WarmupTimeout = 5 * time.Second
+    }
+
+    // Live transcription reconnect defaults
+    if config.PCAS.Reconnect.MaxAttempts <= 0 {
+        config.PCAS.Reconnect.MaxAttempts = 5
+    }
+    if config.PCAS.Reconnect.InitialBackoff <= 0 {
+        config.PCAS.Reconnect.InitialBackoff = 500 * time.Millisecond
+    }
+    if config.PCAS.Reconnect.MaxBackoff <= 0 {
+        config.PCAS.Reconnect.MaxBackoff = 8 * time.Second
+    }
+    if config.PCAS.Reconnect.BufferChunks <= 0 {
+        config.PCAS.Reconnect.BufferChunks = 512
+    }
+
+    // Memory outbox defaults
+    if config.PCAS.Outbox.Dir == "" {
+        config.PCAS.Outbox.Dir = "data/outbox"
+    }
+    if config.PCAS.Outbox.InitialBackoff <= 0 {
+        config.PCAS.Outbox.InitialBackoff = time.Second
+    }
+    if config.PCAS.Outbox.MaxBackoff <= 0 {
+        config.PCAS.Outbox.MaxBackoff = time.Minute
+    }
+    if config.PCAS.Outbox.PublishTimeout <= 0 {
+        config.PCAS.Outbox.PublishTimeout = 10 * time.Second
+    }
+
+    // PCAS subscription defaults
+    if config.PCAS.Subscribe.InitialBackoff <= 0 {
+        config.PCAS.Subscribe.InitialBackoff = time.Second
+    }
+    if config.PCAS.Subscribe.MaxBackoff <= 0 {
+        config.PCAS.Subscribe.MaxBackoff = 30 * time.Second
+    }
+    if config.PCAS.Subscribe.Buffer <= 0 {
+        config.PCAS.Subscribe.Buffer = 64
+    }
+
+    // Memory publish worker defaults
+    if config.PCAS.Publish.QueueSize <= 0 {
+        config.PCAS.Publish.QueueSize = 1024
+    }
+    if config.PCAS.Publish.MaxBatch <= 0 {
+        config.PCAS.Publish.MaxBatch = 32
+    }
+    if config.PCAS.Publish.Concurrency <= 0 {
+        config.PCAS.Publish.Concurrency = 8
+    }
+    if config.PCAS.Publish.Timeout <= 0 {
+        config.PCAS.Publish.Timeout = 5 * time.Second
+    }
+    if config.PCAS.Publish.DrainTimeout <= 0 {
+        config.PCAS.Publish.DrainTimeout = 10 * time.Second
+    }
+
+    // Distiller forced-flush defaults; an explicit 0 turns a trigger off
+    if !viper.IsSet("distiller.maxRunes") {
+        config.Distiller.MaxRunes = 200
+    }
+    if !viper.IsSet("distiller.maxIdle") {
+        config.Distiller.MaxIdle = 3 * time.Second
+    }
+    if !viper.IsSet("distiller.softBreakRunes") {
+        config.Distiller.SoftBreakRunes = 80
+    }
+
+    // Capability session limits
+    if config.Sessions.IdleTTL <= 0 {
+        config.Sessions.IdleTTL = 5 * time.Minute
+    }
+    if config.Sessions.MaxLifetime <= 0 {
+        config.Sessions.MaxLifetime = 2 * time.Hour
+    }
+    if config.Sessions.JanitorInterval <= 0 {
+        config.Sessions.JanitorInterval = 30 * time.Second
+    }
+    if config.Sessions.CompletedTTL <= 0 {
+        config.Sessions.CompletedTTL = time.Minute
+    }
+    if config.Sessions.MaxPerUser <= 0 {
+        config.Sessions.MaxPerUser = 8
+    }
+    if config.Sessions.MaxTotal <= 0 {
+        config.Sessions.MaxTotal = 256
+    }
+    if config.Sessions.ReplayEvents <= 0 {
+        config.Sessions.ReplayEvents = 256
+    }
+
+    // Chat history budgets
+    if config.Chat.MaxTurns <= 0 {
+        config.Chat.MaxTurns = 50
+    }
+    if config.Chat.MaxTurnChars <= 0 {
+        config.Chat.MaxTurnChars = 4000
+    }
+    if config.Chat.ContextChars <= 0 {
+        config.Chat.ContextChars = 6000
+    }
+    if config.Chat.MaxSessions <= 0 {
+        config.Chat.MaxSessions = 1000
+    }
+    if config.Chat.TTL <= 0 {
+        config.Chat.TTL = 24 * time.Hour
+    }
+    if config.Chat.RefsChars <= 0 {
+        config.Chat.RefsChars = 8000
+    }
+    if config.Chat.SessionItems <= 0 {
+        config.Chat.SessionItems = 2000
+    }
+
+    // Memory search defaults
+    if config.Search.TopK <= 0 {
+        config.Search.TopK = 5
+    }
+    if config.Search.MaxTopK <= 0 {
+        config.Search.MaxTopK = 50
+    }
+    if config.Search.Timeout <= 0 {
+        config.Search.Timeout = 3 * time.Second
+    }
+
+    caps, err := mergeCapabilities(builtinCapabilities(config.PCAS), config.Capabilities)
+    if err != nil {
+        return nil, err
+    }
+    config.Capabilities = caps
+
+    if config.User.ID == "" {
+        config.User.ID = "default-user"
+    }
+
     // Allow environment override for admin token
     if envTok := os.Getenv("PCAS_ADMIN_TOKEN"); envTok != "" {
         config.PCAS.AdminToken = envTok
@@ -62,3 +429,232 @@ func LoadConfig(path string) (*Config, error) {
 
     return &config, nil
 }
+
+// builtinCapabilities are the presets available without a capabilities section.
+// Their attrs leave out system (and chat's context): replacing the prompt is
+// something an operator opts into by listing it in the capability's attrs.
+func builtinCapabilities(p PCASConfig) []CapabilityConfig {
+    on := func() *bool { b := true; return &b }()
+    return []CapabilityConfig{
+        {
+            Name:      "translate",
+            EventType: p.TranslateEventType,
+            Model:     "gpt-5-mini",
+            System:    "You are a translator. Translate all user input to {{target_lang}}.",
+            Vars:      map[string]string{"target_lang": "English"},
+            Params:    []CapabilityParam{{Field: "targetLang", Attr: "target_lang"}},
+            Attrs: []CapabilityAttr{
+                builtinModelAttr,
+                {Name: "target_lang", MaxLength: 32},
+            },
+            Streaming: on,
+            Output:    "translation",
+            Pipeline:  "segment",
+            Fanout:    CapabilityFanout{Field: "targetLangs", Attr: "target_lang"},
+        },
+        {
+            Name:      "summarize",
+            EventType: p.SummarizeEventType,
+            Model:     "gpt-5-mini",
+            System:    "Summarize the user input in 3 concise bullet points.",
+            Params:    []CapabilityParam{{Field: "mode", Attr: "mode"}},
+            Attrs: []CapabilityAttr{
+                builtinModelAttr,
+                {Name: "mode", Values: []string{"rolling", "final"}},
+            },
+            Streaming: on,
+            Output:    "summary",
+            Pipeline:  "rolling",
+        },
+        {
+            Name:      "chat",
+            EventType: p.ChatEventType,
+            Model:     "gpt-5",
+            System:    "You are a helpful assistant.",
+            Input:     "message",
+            Params:    []CapabilityParam{{Field: "sessionId", Attr: "session_id"}},
+            Attrs: []CapabilityAttr{
+                builtinModelAttr,
+                {Name: "session_id", MaxLength: 128},
+            },
+            Path:      "/api/chat",
+            History:   on,
+            Refs:      on,
+            RAG:       on,
+        },
+    }
+}
+
+// builtinModelAttr is the model allow-list of the built-in capabilities.
+var builtinModelAttr = CapabilityAttr{Name: "model", MaxLength: 64, Values: []string{"gpt-5", "gpt-5-mini", "gpt-5-nano"}}
+
+// reservedCapabilityNames would collide with other /api routes.
+var reservedCapabilityNames = map[string]bool{
+    "admin": true, "capabilities": true, "events": true, "health": true, "memories": true, "streams": true,
+}
+
+// routes lists the "METHOD path" routes the API registers for c.
+func (c CapabilityConfig) routes() []string {
+    run := c.Path
+    if run == "" {
+        run = "/api/" + c.Name + "/run"
+    }
+    routes := []string{"POST " + run}
+    if Enabled(c.Streaming) {
+        routes = append(routes, "POST /api/"+c.Name+"/start", "GET /api/"+c.Name+"/stream")
+    }
+    if Enabled(c.History) {
+        routes = append(routes, "GET "+run+"/history", "GET "+run+"/history/:sessionId", "DELETE "+run+"/history/:sessionId")
+    }
+    return routes
+}
+
+// checkPath validates a capability's own one-shot route: it must live under
+// /api/, outside the routes served by other handlers, and be free of
+// wildcards so it cannot shadow or conflict with another route.
+func checkPath(path string) error {
+    if !strings.HasPrefix(path, "/api/") || strings.ContainsAny(path, ":*?#") || strings.Contains(path, "//") {
+        return fmt.Errorf("path %q must be a plain path under /api/", path)
+    }
+    rest := strings.TrimPrefix(path, "/api/")
+    first, _, _ := strings.Cut(rest, "/")
+    if reservedCapabilityNames[first] {
+        return fmt.Errorf("path %q is served by /api/%s", path, first)
+    }
+    return nil
+}
+
+// mergeCapabilities overlays the configured presets on the built-ins and
+// validates the result.
+func mergeCapabilities(builtin, custom []CapabilityConfig) ([]CapabilityConfig, error) {
+    out := append([]CapabilityConfig(nil), builtin...)
+    index := make(map[string]int, len(out))
+    for i, c := range out {
+        index[c.Name] = i
+    }
+    seen := make(map[string]bool, len(custom))
+    for _, c := range custom {
+        if c.Name == "" {
+            return nil, fmt.Errorf("capabilities: entry without name")
+        }
+        for _, r := range c.Name {
+            if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+                return nil, fmt.Errorf("capabilities: invalid name %q", c.Name)
+            }
+        }
+        if reservedCapabilityNames[c.Name] {
+            return nil, fmt.Errorf("capabilities: name %q is reserved", c.Name)
+        }
+        if seen[c.Name] {
+            return nil, fmt.Errorf("capabilities: duplicate name %q", c.Name)
+        }
+        seen[c.Name] = true
+
+        i, ok := index[c.Name]
+        if !ok {
+            if c.EventType == "" {
+                return nil, fmt.Errorf("capabilities: %s: eventType is required", c.Name)
+            }
+            index[c.Name] = len(out)
+            out = append(out, c)
+            continue
+        }
+        b := &out[i]
+        if c.EventType != "" {
+            b.EventType = c.EventType
+        }
+        if c.Model != "" {
+            b.Model = c.Model
+        }
+        if c.System != "" {
+            b.System = c.System
+        }
+        if c.Input != "" {
+            b.Input = c.Input
+        }
+        if c.Path != "" {
+            b.Path = c.Path
+        }
+        if len(c.Params) > 0 {
+            b.Params = c.Params
+        }
+        if len(c.Attrs) > 0 {
+            b.Attrs = c.Attrs
+        }
+        // an explicit false turns a built-in feature off
+        if c.Streaming != nil {
+            b.Streaming = c.Streaming
+        }
+        if c.History != nil {
+            b.History = c.History
+        }
+        if c.Refs != nil {
+            b.Refs = c.Refs
+        }
+        if c.RAG != nil {
+            b.RAG = c.RAG
+        }
+        if c.Output != "" {
+            b.Output = c.Output
+        }
+        if c.Pipeline != "" {
+            b.Pipeline = c.Pipeline
+        }
+        if c.Fanout.Field != "" {
+            b.Fanout = c.Fanout
+        }
+        if len(c.Vars) > 0 {
+            vars := make(map[string]string, len(b.Vars)+len(c.Vars))
+            for k, v := range b.Vars {
+                vars[k] = v
+            }
+            for k, v := range c.Vars {
+                vars[k] = v
+            }
+            b.Vars = vars
+        }
+    }
+    routes := map[string]string{}
+    for i := range out {
+        if out[i].Input == "" {
+            out[i].Input = "text"
+        }
+        if out[i].Path != "" {
+            if err := checkPath(out[i].Path); err != nil {
+                return nil, fmt.Errorf("capabilities: %s: %w", out[i].Name, err)
+            }
+        }
+        for _, r := range out[i].routes() {
+            if other, ok := routes[r]; ok {
+                return nil, fmt.Errorf("capabilities: %s: route %s is already used by %s", out[i].Name, r, other)
+            }
+            routes[r] = out[i].Name
+        }
+        switch out[i].Pipeline {
+        case "", "segment", "rolling":
+        default:
+            return nil, fmt.Errorf("capabilities: %s: unknown pipeline %q", out[i].Name, out[i].Pipeline)
+        }
+        if f := &out[i].Fanout; f.Field != "" {
+            if f.Attr == "" {
+                return nil, fmt.Errorf("capabilities: %s: fanout needs attr", out[i].Name)
+            }
+            if f.Max <= 0 {
+                f.Max = 5
+            }
+        }
+        for j, a := range out[i].Attrs {
+            switch a.Type {
+            case "":
+                out[i].Attrs[j].Type = "string"
+            case "string", "int", "number", "bool":
+            default:
+                return nil, fmt.Errorf("capabilities: %s: attr %s has unknown type %q", out[i].Name, a.Name, a.Type)
+            }
+            if a.Name == "" {
+                return nil, fmt.Errorf("capabilities: %s: attr without name", out[i].Name)
+            }
+        }
+    }
+    return out, nil
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestMergeCapabilitiesSwitches(t *testing.T) {
+    yes, no := true, false
+    cases := []struct {
+        name     string
+        override CapabilityConfig
+        want     map[string]bool
+    }{
+        {"unset keeps built-in", CapabilityConfig{Name: "chat", Model: "gpt-5-mini"},
+            map[string]bool{"history": true, "refs": true, "rag": true}},
+        {"false turns off", CapabilityConfig{Name: "chat", RAG: &no, Refs: &no},
+            map[string]bool{"history": true, "refs": false, "rag": false}},
+        {"true turns on", CapabilityConfig{Name: "chat", Streaming: &yes},
+            map[string]bool{"streaming": true, "history": true, "refs": true, "rag": true}},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            caps, err := mergeCapabilities(builtinCapabilities(PCASConfig{ChatEventType: "chat"}), []CapabilityConfig{tc.override})
+            if err != nil {
+                t.Fatal(err)
+            }
+            var chat CapabilityConfig
+            for _, c := range caps {
+                if c.Name == "chat" {
+                    chat = c
+                }
+            }
+            got := map[string]bool{
+                "streaming": Enabled(chat.Streaming),
+                "history":   Enabled(chat.History),
+                "refs":      Enabled(chat.Refs),
+                "rag":       Enabled(chat.RAG),
+            }
+            for k, v := range got {
+                if v != tc.want[k] {
+                    t.Errorf("%s = %v, want %v", k, v, tc.want[k])
+                }
+            }
+        })
+    }
+}
+
+func TestMergeCapabilitiesOverrideDoesNotLeak(t *testing.T) {
+    // the built-ins share one "on" value; turning one capability's switch off
+    // must not affect the others
+    no := false
+    caps, err := mergeCapabilities(builtinCapabilities(PCASConfig{}), []CapabilityConfig{{Name: "translate", Streaming: &no}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, c := range caps {
+        want := c.Name == "summarize"
+        if c.Name == "chat" {
+            continue
+        }
+        if Enabled(c.Streaming) != want {
+            t.Errorf("%s streaming = %v, want %v", c.Name, Enabled(c.Streaming), want)
+        }
+    }
+}
+
+func TestMergeCapabilitiesPaths(t *testing.T) {
+    cases := []struct {
+        name    string
+        custom  []CapabilityConfig
+        wantErr bool
+    }{
+        {"own path", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/api/ask"}}, false},
+        {"built-in chat path", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/api/chat"}}, true},
+        {"another capability's run route", []CapabilityConfig{{Name: "chat", Path: "/api/translate/run"}}, true},
+        {"another capability's start route", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/api/translate/start"}}, true},
+        {"same path twice", []CapabilityConfig{
+            {Name: "ask", EventType: "ask", Path: "/api/ask"},
+            {Name: "quiz", EventType: "quiz", Path: "/api/ask"},
+        }, true},
+        {"history routes of another capability", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/api/ask", History: boolPtr(true)},
+            {Name: "quiz", EventType: "quiz", Path: "/api/quiz", History: boolPtr(true)}}, false},
+        {"memory search", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/api/memories/search"}}, true},
+        {"streams", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/api/streams/ask"}}, true},
+        {"capabilities list", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/api/capabilities"}}, true},
+        {"outside /api", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/ws/transcribe"}}, true},
+        {"wildcard", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/api/ask/:id"}}, true},
+        {"catch-all", []CapabilityConfig{{Name: "ask", EventType: "ask", Path: "/api/*rest"}}, true},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            _, err := mergeCapabilities(builtinCapabilities(PCASConfig{}), tc.custom)
+            if (err != nil) != tc.wantErr {
+                t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+            }
+        })
+    }
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+// loadYAML writes body to a temporary config file and loads it.
+func loadYAML(t *testing.T, body string) *Config {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return cfg
+}
+
+func TestLoadConfigSSEHeartbeat(t *testing.T) {
+    cases := []struct {
+        name string
+        yaml string
+        want time.Duration
+    }{
+        {"unset", "server:\n  port: \"8080\"\n", 15 * time.Second},
+        {"set", "server:\n  sseHeartbeat: \"5s\"\n", 5 * time.Second},
+        {"zero disables", "server:\n  sseHeartbeat: \"0s\"\n", 0},
+        {"bare zero disables", "server:\n  sseHeartbeat: 0\n", 0},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            if got := loadYAML(t, tc.yaml).Server.SSEHeartbeat; got != tc.want {
+                t.Fatalf("SSEHeartbeat = %v, want %v", got, tc.want)
+            }
+        })
+    }
+}
+
+func TestLoadConfigDistillerTriggers(t *testing.T) {
+    cases := []struct {
+        name string
+        yaml string
+        want DistillerConfig
+    }{
+        {"unset", "server:\n  port: \"8080\"\n", DistillerConfig{MaxRunes: 200, MaxIdle: 3 * time.Second, SoftBreakRunes: 80}},
+        {"all off", "distiller:\n  maxRunes: 0\n  maxIdle: \"0s\"\n  softBreakRunes: 0\n", DistillerConfig{}},
+        {"one off", "distiller:\n  softBreakRunes: 0\n", DistillerConfig{MaxRunes: 200, MaxIdle: 3 * time.Second}},
+        {"set", "distiller:\n  maxRunes: 120\n  maxIdle: \"1s\"\n  softBreakRunes: 60\n", DistillerConfig{MaxRunes: 120, MaxIdle: time.Second, SoftBreakRunes: 60}},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            if got := loadYAML(t, tc.yaml).Distiller; got != tc.want {
+                t.Fatalf("Distiller = %+v, want %+v", got, tc.want)
+            }
+        })
+    }
+}
@@ -3,36 +3,215 @@ package distiller
 import (
 	"strings"
 	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// FlushPolicy forces text out of the buffer when the ASR never emits
+// sentence-ending punctuation. Zero fields disable the matching trigger.
+type FlushPolicy struct {
+	// MaxRunes is a hard cap on buffered runes.
+	MaxRunes int
+	// MaxIdle flushes the buffer when no new text arrived for this long (see FlushIdle).
+	MaxIdle time.Duration
+	// SoftBreakRunes cuts at the last clause boundary (，, 、 ;) once the buffer is this long.
+	SoftBreakRunes int
+}
+
+// Segment is one piece of text cut from the stream. Start and End are rune
+// offsets within everything fed to the distiller so far.
+type Segment struct {
+	Index  int
+	Text   string
+	Start  int
+	End    int
+	Reason string
+}
+
+// Reasons a segment was cut.
+const (
+	ReasonSentence  = "sentence"
+	ReasonSoftBreak = "soft_break"
+	ReasonMaxRunes  = "max_runes"
+	ReasonIdle      = "idle"
+	ReasonTrailing  = "trailing"
+	ReasonFlush     = "flush"
+)
+
+// Options configures a Distiller.
+type Options struct {
+	Segmenter Segmenter
+	Flush     FlushPolicy
+}
+
 type Distiller struct {
-	buffer strings.Builder
-	mu     sync.Mutex
+	buffer    strings.Builder
+	segmenter Segmenter
+	flush     FlushPolicy
+	lastInput time.Time
+	// consumed counts runes already cut into segments; next is the next segment index.
+	consumed int
+	next     int
+	mu       sync.Mutex
 }
 
 func NewDistiller() *Distiller {
-	return &Distiller{}
+	return NewDistillerWithOptions(Options{})
 }
 
-func (d *Distiller) Process(text string) string {
+// NewDistillerWithOptions creates a distiller with a custom segmenter and flush policy.
+// A nil Segmenter falls back to DefaultSegmenter.
+func NewDistillerWithOptions(opts Options) *Distiller {
+	if opts.Segmenter == nil {
+		opts.Segmenter, _ = NewSegmenter(DefaultSegmenter)
+	}
+	return &Distiller{segmenter: opts.Segmenter, flush: opts.Flush}
+}
+
+// Process appends text and returns the next segment if one is complete.
+// A zero Segment (empty Text) means nothing is ready yet. One chunk can
+// complete several segments; call Next until it returns a zero Segment to
+// drain the rest.
+func (d *Distiller) Process(text string) Segment {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	d.buffer.WriteString(text)
-	content := d.buffer.String()
+	d.lastInput = time.Now()
+	return d.cut()
+}
+
+// Next returns the next segment already complete in the buffer, or a zero
+// Segment once no sentence end or flush trigger is left.
+func (d *Distiller) Next() Segment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.cut()
+}
+
+// cut takes the first complete segment off the buffer, skipping cuts that
+// hold only whitespace.
+func (d *Distiller) cut() Segment {
+	for {
+		runes := []rune(d.buffer.String())
+		reason := ReasonSentence
+		cut := d.segmenter.Cut(runes)
+		if cut == 0 && d.flush.SoftBreakRunes > 0 && len(runes) >= d.flush.SoftBreakRunes {
+			cut, reason = lastClauseBreak(runes), ReasonSoftBreak
+		}
+		if cut == 0 && d.flush.MaxRunes > 0 && len(runes) >= d.flush.MaxRunes {
+			cut, reason = hardBreak(runes, d.flush.MaxRunes), ReasonMaxRunes
+		}
+		if cut == 0 {
+			return Segment{}
+		}
+		if seg := d.take(runes, cut, reason); seg.Text != "" {
+			return seg
+		}
+	}
+}
+
+// FlushIdle returns and clears the buffered text if nothing arrived for
+// FlushPolicy.MaxIdle. It is meant to be polled from a timer.
+func (d *Distiller) FlushIdle(now time.Time) Segment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.flush.MaxIdle <= 0 || d.buffer.Len() == 0 || now.Sub(d.lastInput) < d.flush.MaxIdle {
+		return Segment{}
+	}
+	runes := []rune(d.buffer.String())
+	return d.take(runes, len(runes), ReasonIdle)
+}
 
-	runes := []rune(content)
+// Flush returns and clears whatever is still buffered, e.g. when the stream ends
+// in the middle of a sentence.
+func (d *Distiller) Flush() Segment {
+	return d.FlushWithReason(ReasonTrailing)
+}
+
+// FlushWithReason is Flush with an explicit reason, e.g. ReasonFlush for a
+// client-requested flush.
+func (d *Distiller) FlushWithReason(reason string) Segment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	runes := []rune(d.buffer.String())
+	if len(runes) == 0 {
+		return Segment{}
+	}
+	return d.take(runes, len(runes), reason)
+}
+
+// Position returns the rune offset of the end of all text received so far
+// and the index the next segment will get.
+func (d *Distiller) Position() (offset, nextIndex int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.consumed + utf8.RuneCountInString(d.buffer.String()), d.next
+}
+
+// take cuts runes[:cut] into a segment and keeps the rest buffered.
+// Surrounding whitespace is trimmed from the text and excluded from the offsets.
+func (d *Distiller) take(runes []rune, cut int, reason string) Segment {
+	start, end := 0, cut
+	for start < end && unicode.IsSpace(runes[start]) {
+		start++
+	}
+	for end > start && unicode.IsSpace(runes[end-1]) {
+		end--
+	}
+
+	seg := Segment{
+		Text:   string(runes[start:end]),
+		Start:  d.consumed + start,
+		End:    d.consumed + end,
+		Reason: reason,
+	}
+	d.consumed += cut
+	d.buffer.Reset()
+	if cut < len(runes) {
+		d.buffer.WriteString(string(runes[cut:]))
+	}
+	if seg.Text == "" {
+		return Segment{}
+	}
+	seg.Index = d.next
+	d.next++
+	return seg
+}
+
+func isClauseBreak(r rune) bool {
+	switch r {
+	case '，', '、', '；', '：', ',', ';', ':':
+		return true
+	}
+	return false
+}
+
+// lastClauseBreak returns the index past the last clause punctuation, or 0.
+func lastClauseBreak(runes []rune) int {
 	for i := len(runes) - 1; i >= 0; i-- {
-		if runes[i] == '。' || runes[i] == '？' || runes[i] == '！' {
-			sentenceRunes := runes[:i+1]
-			sentence := string(sentenceRunes)
-			d.buffer.Reset()
-			if i+1 < len(runes) {
-				d.buffer.WriteString(string(runes[i+1:]))
-			}
-			return sentence
+		if isClauseBreak(runes[i]) {
+			return i + 1
 		}
 	}
+	return 0
+}
 
-	return ""
-}
\ No newline at end of file
+// hardBreak cuts at max runes, backing off to the last space in the second
+// half of the window so Latin words are not split.
+func hardBreak(runes []rune, max int) int {
+	if max > len(runes) {
+		max = len(runes)
+	}
+	for i := max - 1; i >= max/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			return i + 1
+		}
+	}
+	return max
+}
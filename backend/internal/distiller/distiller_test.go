@@ -0,0 +1,148 @@
+package distiller
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessSoftBreak(t *testing.T) {
+	d := NewDistillerWithOptions(Options{Flush: FlushPolicy{SoftBreakRunes: 10}})
+
+	if seg := d.Process("短句，未完"); seg.Text != "" {
+		t.Fatalf("below SoftBreakRunes: got %+v", seg)
+	}
+	seg := d.Process("这是很长的句子，还没有结束")
+	want := Segment{Index: 0, Text: "短句，未完这是很长的句子，", Start: 0, End: 13, Reason: ReasonSoftBreak}
+	if seg != want {
+		t.Fatalf("soft break: got %+v, want %+v", seg, want)
+	}
+	seg = d.Process("。")
+	want = Segment{Index: 1, Text: "还没有结束。", Start: 13, End: 19, Reason: ReasonSentence}
+	if seg != want {
+		t.Fatalf("after soft break: got %+v, want %+v", seg, want)
+	}
+}
+
+func TestProcessSoftBreakWithoutClause(t *testing.T) {
+	// no clause punctuation: the soft break finds nothing and MaxRunes decides
+	d := NewDistillerWithOptions(Options{Flush: FlushPolicy{SoftBreakRunes: 4, MaxRunes: 6}})
+	if seg := d.Process("一二三四五"); seg.Text != "" {
+		t.Fatalf("below MaxRunes: got %+v", seg)
+	}
+	seg := d.Process("六七八")
+	want := Segment{Index: 0, Text: "一二三四五六", Start: 0, End: 6, Reason: ReasonMaxRunes}
+	if seg != want {
+		t.Fatalf("got %+v, want %+v", seg, want)
+	}
+	if off, next := d.Position(); off != 8 || next != 1 {
+		t.Fatalf("Position() = %d, %d; want 8, 1", off, next)
+	}
+}
+
+func TestProcessMaxRunes(t *testing.T) {
+	d := NewDistillerWithOptions(Options{Segmenter: LatinSegmenter{}, Flush: FlushPolicy{MaxRunes: 20}})
+
+	// backs off to the last space so "jumps" is not split
+	seg := d.Process("the quick brown fox jumps over")
+	want := Segment{Index: 0, Text: "the quick brown fox", Start: 0, End: 19, Reason: ReasonMaxRunes}
+	if seg != want {
+		t.Fatalf("got %+v, want %+v", seg, want)
+	}
+	if rest := d.Flush(); rest.Text != "jumps over" || rest.Start != 20 || rest.Reason != ReasonTrailing {
+		t.Fatalf("remainder: got %+v", rest)
+	}
+}
+
+func TestProcessSentenceBeforeFlushPolicy(t *testing.T) {
+	d := NewDistillerWithOptions(Options{Segmenter: LatinSegmenter{}, Flush: FlushPolicy{SoftBreakRunes: 5, MaxRunes: 8}})
+	seg := d.Process("Hi there, friend. And")
+	want := Segment{Index: 0, Text: "Hi there, friend.", Start: 0, End: 17, Reason: ReasonSentence}
+	if seg != want {
+		t.Fatalf("got %+v, want %+v", seg, want)
+	}
+}
+
+func TestFlushIdle(t *testing.T) {
+	d := NewDistillerWithOptions(Options{Flush: FlushPolicy{MaxIdle: time.Second}})
+
+	if seg := d.FlushIdle(time.Now().Add(time.Hour)); seg.Text != "" {
+		t.Fatalf("empty buffer: got %+v", seg)
+	}
+	d.Process("第一句。然后呢 ")
+	last := time.Now()
+	if seg := d.FlushIdle(last.Add(500 * time.Millisecond)); seg.Text != "" {
+		t.Fatalf("before MaxIdle: got %+v", seg)
+	}
+	seg := d.FlushIdle(last.Add(time.Second))
+	want := Segment{Index: 1, Text: "然后呢", Start: 4, End: 7, Reason: ReasonIdle}
+	if seg != want {
+		t.Fatalf("after MaxIdle: got %+v, want %+v", seg, want)
+	}
+	if seg := d.FlushIdle(last.Add(time.Hour)); seg.Text != "" {
+		t.Fatalf("flushed twice: got %+v", seg)
+	}
+
+	// new text restarts the idle clock and continues the offsets
+	d.Process("继续")
+	if seg := d.FlushIdle(last.Add(time.Second)); seg.Text != "" {
+		t.Fatalf("idle measured from older input: got %+v", seg)
+	}
+	seg = d.FlushIdle(time.Now().Add(time.Second))
+	want = Segment{Index: 2, Text: "继续", Start: 8, End: 10, Reason: ReasonIdle}
+	if seg != want {
+		t.Fatalf("second idle flush: got %+v, want %+v", seg, want)
+	}
+}
+
+func TestFlushIdleDisabled(t *testing.T) {
+	d := NewDistiller()
+	d.Process("没有标点的文本")
+	if seg := d.FlushIdle(time.Now().Add(time.Hour)); seg.Text != "" {
+		t.Fatalf("MaxIdle unset: got %+v", seg)
+	}
+	if seg := d.Flush(); seg.Text != "没有标点的文本" || seg.Reason != ReasonTrailing {
+		t.Fatalf("Flush: got %+v", seg)
+	}
+}
+
+func TestProcessDrainsLongChunk(t *testing.T) {
+	d := NewDistillerWithOptions(Options{Flush: FlushPolicy{MaxRunes: 200}})
+
+	chunk := strings.Repeat("字", 500)
+	var got []Segment
+	for seg := d.Process(chunk); seg.Text != ""; seg = d.Next() {
+		got = append(got, seg)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d segments, want 2: %+v", len(got), got)
+	}
+	for i, seg := range got {
+		if n := len([]rune(seg.Text)); n != 200 || seg.Index != i || seg.Start != i*200 || seg.Reason != ReasonMaxRunes {
+			t.Errorf("segment %d: %d runes, index %d, %d-%d, %s", i, n, seg.Index, seg.Start, seg.End, seg.Reason)
+		}
+	}
+	// only the tail below MaxRunes stays buffered
+	if rest := d.Flush(); len([]rune(rest.Text)) != 100 || rest.Start != 400 || rest.End != 500 {
+		t.Fatalf("remainder: %d runes from %d to %d", len([]rune(rest.Text)), rest.Start, rest.End)
+	}
+}
+
+func TestProcessDrainsAfterSentence(t *testing.T) {
+	d := NewDistillerWithOptions(Options{Flush: FlushPolicy{MaxRunes: 200}})
+
+	// the sentence cut leaves a tail that is itself over MaxRunes
+	var got []Segment
+	for seg := d.Process("第一句。" + strings.Repeat("字", 250)); seg.Text != ""; seg = d.Next() {
+		got = append(got, seg)
+	}
+	if len(got) != 2 || got[0].Text != "第一句。" || got[0].Reason != ReasonSentence {
+		t.Fatalf("got %d segments, want the sentence then a max_runes cut", len(got))
+	}
+	if seg := got[1]; len([]rune(seg.Text)) != 200 || seg.Index != 1 || seg.Start != 4 || seg.End != 204 || seg.Reason != ReasonMaxRunes {
+		t.Fatalf("max_runes cut: %d runes, index %d, %d-%d, %s", len([]rune(seg.Text)), seg.Index, seg.Start, seg.End, seg.Reason)
+	}
+	if off, next := d.Position(); off != 254 || next != 2 {
+		t.Fatalf("Position() = %d, %d; want 254, 2", off, next)
+	}
+}
@@ -0,0 +1,250 @@
+package distiller
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Segmenter finds sentence boundaries in buffered transcript text.
+type Segmenter interface {
+	// Cut returns the rune index just past the last complete sentence in text,
+	// or 0 if the buffer holds no complete sentence yet.
+	Cut(text []rune) int
+}
+
+// Built-in segmenter names accepted by NewSegmenter.
+const (
+	SegmenterCJK   = "cjk"
+	SegmenterLatin = "latin"
+	SegmenterMixed = "mixed"
+)
+
+// DefaultSegmenter keeps the original CJK-only behaviour.
+const DefaultSegmenter = SegmenterCJK
+
+// NewSegmenter returns the built-in strategy registered under name.
+// An empty name selects DefaultSegmenter.
+func NewSegmenter(name string) (Segmenter, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "":
+		return NewSegmenter(DefaultSegmenter)
+	case SegmenterCJK:
+		return CJKSegmenter{}, nil
+	case SegmenterLatin:
+		return LatinSegmenter{}, nil
+	case SegmenterMixed:
+		return MixedSegmenter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown segmenter %q", name)
+	}
+}
+
+// SegmenterForLanguage picks a strategy from a BCP 47-ish language tag
+// such as "zh-CN" or "en". Unknown or empty languages get the mixed strategy.
+func SegmenterForLanguage(lang string) Segmenter {
+	base := strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(base, "-_"); i >= 0 {
+		base = base[:i]
+	}
+	switch base {
+	case "zh", "ja", "ko", "yue":
+		return CJKSegmenter{}
+	case "en", "fr", "de", "es", "it", "pt", "nl", "sv", "da", "no", "fi", "pl", "cs", "ro", "tr", "id", "vi":
+		return LatinSegmenter{}
+	default:
+		return MixedSegmenter{}
+	}
+}
+
+// CJKSegmenter splits on full-width sentence punctuation (。？！).
+type CJKSegmenter struct{}
+
+func (CJKSegmenter) Cut(text []rune) int {
+	last := 0
+	for i := 0; i < len(text); i++ {
+		if end, ok := cjkBoundary(text, i); ok {
+			last = end
+			i = end - 1
+		}
+	}
+	return last
+}
+
+// LatinSegmenter splits on ". ? !" followed by whitespace, skipping common
+// abbreviations, decimal numbers and ellipses that continue the sentence.
+type LatinSegmenter struct{}
+
+func (LatinSegmenter) Cut(text []rune) int {
+	last := 0
+	for i := 0; i < len(text); i++ {
+		if end, ok := latinBoundary(text, i, false); ok {
+			last = end
+			i = end - 1
+		}
+	}
+	return last
+}
+
+// MixedSegmenter accepts both CJK and Latin punctuation, e.g. for Japanese
+// transcripts with ASCII punctuation or code-switched lectures.
+type MixedSegmenter struct{}
+
+func (MixedSegmenter) Cut(text []rune) int {
+	last := 0
+	for i := 0; i < len(text); i++ {
+		end, ok := cjkBoundary(text, i)
+		if !ok {
+			end, ok = latinBoundary(text, i, true)
+		}
+		if ok {
+			last = end
+			i = end - 1
+		}
+	}
+	return last
+}
+
+func isCJKTerminal(r rune) bool {
+	return r == '。' || r == '？' || r == '！'
+}
+
+func isLatinTerminal(r rune) bool {
+	return r == '.' || r == '?' || r == '!' || r == '…'
+}
+
+// isCloser reports quotes and brackets that belong to the sentence they close.
+func isCloser(r rune) bool {
+	switch r {
+	case '"', '\'', ')', ']', '”', '’', '」', '』', '）', '】', '》', '〉':
+		return true
+	}
+	return false
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// skipClosers advances past closing quotes/brackets starting at i.
+func skipClosers(text []rune, i int) int {
+	for i < len(text) && isCloser(text[i]) {
+		i++
+	}
+	return i
+}
+
+// cjkBoundary reports whether a sentence ends at text[i], returning the index
+// past the terminal run (e.g. "？！") and any closing quotes.
+func cjkBoundary(text []rune, i int) (int, bool) {
+	if !isCJKTerminal(text[i]) {
+		return 0, false
+	}
+	j := i + 1
+	for j < len(text) && (isCJKTerminal(text[j]) || text[j] == '…') {
+		j++
+	}
+	return skipClosers(text, j), true
+}
+
+// latinBoundary reports whether a sentence ends at text[i]. allowCJKNext lets a
+// boundary be followed directly by a CJK character instead of whitespace.
+func latinBoundary(text []rune, i int, allowCJKNext bool) (int, bool) {
+	// Only the first rune of a terminal run ("...", "?!") decides
+	if !isLatinTerminal(text[i]) || (i > 0 && isLatinTerminal(text[i-1])) {
+		return 0, false
+	}
+	j := i
+	dots := 0
+	ellipsis := false
+	for j < len(text) && isLatinTerminal(text[j]) {
+		switch text[j] {
+		case '.':
+			dots++
+		case '…':
+			ellipsis = true
+		}
+		j++
+	}
+	if dots >= 2 && dots == j-i {
+		ellipsis = true
+	}
+	end := skipClosers(text, j)
+	single := j-i == 1 && text[i] == '.'
+
+	if end == len(text) {
+		// Streaming: more text may follow, so only commit when unambiguous
+		if ellipsis {
+			return 0, false
+		}
+		if single && (isAbbreviation(text, i) || (i > 0 && unicode.IsDigit(text[i-1]))) {
+			return 0, false
+		}
+		return end, true
+	}
+
+	next := text[end]
+	if !unicode.IsSpace(next) {
+		if allowCJKNext && isCJK(next) && !ellipsis {
+			return end, true
+		}
+		// "3.14", "e.g.x", "example.com"
+		return 0, false
+	}
+	if ellipsis {
+		// An ellipsis ends the sentence only when a new one visibly starts
+		k := end
+		for k < len(text) && unicode.IsSpace(text[k]) {
+			k++
+		}
+		if k == len(text) || !(unicode.IsUpper(text[k]) || (allowCJKNext && isCJK(text[k]))) {
+			return 0, false
+		}
+		return end, true
+	}
+	if single && isAbbreviation(text, i) {
+		return 0, false
+	}
+	return end, true
+}
+
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true,
+	"vs": true, "etc": true, "e.g": true, "i.e": true, "cf": true, "vol": true, "approx": true,
+	"inc": true, "ltd": true, "corp": true, "jan": true, "feb": true, "apr": true, "jun": true,
+	"jul": true, "aug": true, "sep": true, "oct": true, "nov": true, "u.s": true, "u.k": true,
+}
+
+// wordAbbreviations are also ordinary words ("the answer is no."). They only
+// count as abbreviations before a number ("No. 5", "fig. 3") or, written
+// capitalised, before a capitalised word ("St. Louis").
+var wordAbbreviations = map[string]bool{
+	"no": true, "co": true, "st": true, "fig": true, "mar": true, "dec": true, "sept": true,
+}
+
+// isAbbreviation reports whether the word ending just before the '.' at text[i]
+// is a known abbreviation or a single-letter initial ("J. Smith").
+func isAbbreviation(text []rune, i int) bool {
+	k := i
+	for k > 0 && (unicode.IsLetter(text[k-1]) || text[k-1] == '.') {
+		k--
+	}
+	word := string(text[k:i])
+	if word == "" {
+		return false
+	}
+	if r := []rune(word); len(r) == 1 && unicode.IsUpper(r[0]) {
+		return true
+	}
+	lower := strings.ToLower(word)
+	if wordAbbreviations[lower] {
+		n := i + 1
+		for n < len(text) && unicode.IsSpace(text[n]) {
+			n++
+		}
+		// at the end of the buffer the next word decides later
+		return n == len(text) || unicode.IsDigit(text[n]) ||
+			(unicode.IsUpper(text[k]) && unicode.IsUpper(text[n]))
+	}
+	return abbreviations[lower]
+}
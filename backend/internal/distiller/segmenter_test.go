@@ -0,0 +1,131 @@
+package distiller
+
+import (
+	"reflect"
+	"testing"
+)
+
+type cutCase struct {
+	name string
+	in   string
+	// want is the prefix of in that Cut commits as complete sentences.
+	want string
+}
+
+func runCutCases(t *testing.T, s Segmenter, cases []cutCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := s.Cut([]rune(tc.in))
+			if want := len([]rune(tc.want)); got != want {
+				t.Fatalf("Cut(%q) = %d (%q), want %d (%q)", tc.in, got, string([]rune(tc.in)[:got]), want, tc.want)
+			}
+		})
+	}
+}
+
+func TestCJKSegmenter(t *testing.T) {
+	runCutCases(t, CJKSegmenter{}, []cutCase{
+		{"period", "你好。世界", "你好。"},
+		{"several sentences", "第一句。第二句！第三", "第一句。第二句！"},
+		{"terminal run", "真的？！好吧", "真的？！"},
+		{"closing quote", "他说：“走吧。”然后", "他说：“走吧。”"},
+		{"closing bracket", "（见上文。）下面", "（见上文。）"},
+		{"no punctuation", "还没有说完", ""},
+		{"latin punctuation ignored", "Hello. World", ""},
+	})
+}
+
+func TestLatinSegmenter(t *testing.T) {
+	runCutCases(t, LatinSegmenter{}, []cutCase{
+		{"period", "Hello world. This", "Hello world."},
+		{"several sentences", "One. Two! Three? Fou", "One. Two! Three?"},
+		{"end of buffer", "It is done.", "It is done."},
+		{"title abbreviation", "Mr. Smith is here", ""},
+		{"title abbreviation at end", "Talk to Mr.", ""},
+		{"e.g.", "We use e.g. apples", ""},
+		{"U.S.", "The U.S. economy grew. Next", "The U.S. economy grew."},
+		{"initial", "J. Smith wrote it. Then", "J. Smith wrote it."},
+		{"decimal", "Pi is 3.14 roughly", ""},
+		{"number at end", "It costs 3.", ""},
+		{"domain", "See example.com for more", ""},
+		{"ellipsis at end", "Wait...", ""},
+		{"unicode ellipsis at end", "Wait…", ""},
+		{"ellipsis then capital", "Wait... Then it went", "Wait..."},
+		{"ellipsis then lower case", "wait... and then", ""},
+		{"terminal run", "What?! Really", "What?!"},
+		{"closing quote", `He said "stop." Then`, `He said "stop."`},
+		{"closing bracket", "(See above.) Next", "(See above.)"},
+		{"cjk after period", "Hello.世界", ""},
+		{"word no", "The answer is no. Next question", "The answer is no."},
+		{"word no before lower case", "I said no. and left", "I said no."},
+		{"No. before number", "See No. 5 below", ""},
+		{"no. before number", "item no. 5 is late. Next", "item no. 5 is late."},
+		{"word co", "We co. Then", "We co."},
+		{"Co. before name", "Smith & Co. Ltd sold it", ""},
+		{"word st", "Go to st. Then", "Go to st."},
+		{"St. before name", "He lives on St. James street", ""},
+		{"word fig", "I ate a fig. Then I left", "I ate a fig."},
+		{"fig. before number", "see fig. 3 for details", ""},
+		{"word mar", "Scratches mar. The", "Scratches mar."},
+		{"Mar. before number", "Due Mar. 5 at noon", ""},
+		{"Dec. before number", "On Dec. 25 we rest. Then", "On Dec. 25 we rest."},
+		{"word abbreviation at end", "The answer is no.", ""},
+	})
+}
+
+func TestMixedSegmenter(t *testing.T) {
+	runCutCases(t, MixedSegmenter{}, []cutCase{
+		{"cjk period", "你好。世界", "你好。"},
+		{"cjk terminal run", "真的？！好吧", "真的？！"},
+		{"latin period", "Hello world. This", "Hello world."},
+		{"latin then cjk", "Hello.世界", "Hello."},
+		{"ascii period in japanese", "これはペンです.次に", "これはペンです."},
+		{"question then cjk", "Really?真的", "Really?"},
+		{"cjk then latin", "你好。Hello world. Next", "你好。Hello world."},
+		{"ellipsis then cjk", "Wait...然后", ""},
+		{"ellipsis, space, cjk", "Wait... 然后", "Wait..."},
+		{"abbreviation", "Mr. Smith 来了", ""},
+		{"decimal", "版本 3.14很好", ""},
+	})
+}
+
+func TestSegmenterForLanguage(t *testing.T) {
+	cases := map[string]Segmenter{
+		"zh":    CJKSegmenter{},
+		"zh-CN": CJKSegmenter{},
+		"JA":    CJKSegmenter{},
+		"yue":   CJKSegmenter{},
+		"en":    LatinSegmenter{},
+		"en_US": LatinSegmenter{},
+		" de ":  LatinSegmenter{},
+		"":      MixedSegmenter{},
+		"xx":    MixedSegmenter{},
+	}
+	for lang, want := range cases {
+		if got := SegmenterForLanguage(lang); !reflect.DeepEqual(got, want) {
+			t.Errorf("SegmenterForLanguage(%q) = %T, want %T", lang, got, want)
+		}
+	}
+}
+
+func TestNewSegmenter(t *testing.T) {
+	cases := map[string]Segmenter{
+		"":       CJKSegmenter{},
+		"cjk":    CJKSegmenter{},
+		"Latin":  LatinSegmenter{},
+		" mixed": MixedSegmenter{},
+	}
+	for name, want := range cases {
+		got, err := NewSegmenter(name)
+		if err != nil {
+			t.Fatalf("NewSegmenter(%q): %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("NewSegmenter(%q) = %T, want %T", name, got, want)
+		}
+	}
+	if _, err := NewSegmenter("bogus"); err == nil {
+		t.Error("NewSegmenter(bogus) succeeded")
+	}
+}
@@ -2,15 +2,17 @@ package pcas
 
 import (
     "context"
+    "errors"
     "fmt"
     "io"
     "log"
     "sync"
+    "sync/atomic"
+    "time"
 
     "github.com/pcas/dreams-cli/backend/internal/distiller"
     busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
     "google.golang.org/grpc"
-    "google.golang.org/grpc/credentials/insecure"
 )
 
 type Gateway struct {
@@ -19,159 +21,461 @@ type Gateway struct {
     client    busv1.EventBusServiceClient
     publisher *Publisher
     distiller *distiller.Distiller
+    reconnect ReconnectPolicy
+    // queue, when set, publishes memory events off the receive loop;
+    // publishing tracks the ones whose outcome is still to be reported.
+    queue      *publishQueue
+    publishing sync.WaitGroup
+    // streamID is the id PCAS assigned to the current transcription stream.
+    streamID atomic.Value
+    // owned is true when the gateway dialed its own connection and must close it.
+    owned bool
 }
 
-func NewGateway(address string) (*Gateway, error) {
-	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewGateway dials a dedicated connection. Handlers should prefer Pool.Gateway.
+func NewGateway(address string, tlsOpts TLSOptions) (*Gateway, error) {
+	creds, err := transportCredentials(tlsOpts)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to PCAS: %w", err)
 	}
+	return newGateway(address, conn, true), nil
+}
 
+func newGateway(address string, conn *grpc.ClientConn, owned bool) *Gateway {
 	return &Gateway{
 		address:   address,
 		conn:      conn,
 		client:    busv1.NewEventBusServiceClient(conn),
 		publisher: NewPublisher(conn),
 		distiller: distiller.NewDistiller(),
-	}, nil
+		reconnect: DefaultReconnectPolicy,
+		owned:     owned,
+	}
 }
 
 func (g *Gateway) Close() error {
-	if g.conn != nil {
+	if g.owned && g.conn != nil {
 		return g.conn.Close()
 	}
 	return nil
 }
 
-func (g *Gateway) ProcessStream(ctx context.Context, eventType string, audioFromClient <-chan []byte, textToClient chan<- []byte, userID string) error {
-	stream, err := g.client.InteractStream(ctx)
+// StreamOptions describes one live transcription stream.
+type StreamOptions struct {
+	EventType string
+	UserID    string
+	// SessionID groups everything from one connection; TraceID follows it through PCAS.
+	SessionID string
+	TraceID   string
+	// Course, Title and Tags describe the lecture/meeting; they end up in the
+	// memory payload and attributes.
+	Course string
+	Title  string
+	Tags   []string
+	// Attributes are forwarded in the StreamConfig. "segmenter" (cjk|latin|mixed)
+	// or, failing that, "language" selects the distiller's sentence segmentation.
+	Attributes map[string]string
+	// Flush forces segments out when no sentence-ending punctuation arrives.
+	Flush distiller.FlushPolicy
+	// Controls optionally carries client flush/mark requests. It may stay open.
+	Controls <-chan StreamControl
+}
+
+func (o StreamOptions) streamAttributes() map[string]string {
+	attrs := map[string]string{"source": "dreamscribe"}
+	for k, v := range o.Attributes {
+		attrs[k] = v
+	}
+	if o.UserID != "" {
+		attrs["user_id"] = o.UserID
+	}
+	if o.SessionID != "" {
+		attrs["session_id"] = o.SessionID
+	}
+	if o.TraceID != "" {
+		attrs["trace_id"] = o.TraceID
+	}
+	return attrs
+}
+
+func (o StreamOptions) segmenter() (distiller.Segmenter, error) {
+	if name := o.Attributes["segmenter"]; name != "" {
+		return distiller.NewSegmenter(name)
+	}
+	if lang := o.Attributes["language"]; lang != "" {
+		return distiller.SegmenterForLanguage(lang), nil
+	}
+	return distiller.NewSegmenter(distiller.DefaultSegmenter)
+}
+
+// ProcessStream bridges client audio to a PCAS transcription stream and emits
+// typed transcript events (partial text, distilled finals, published memories,
+// status and errors) on events. If the stream drops mid-session it is
+// re-established with backoff while incoming audio is buffered; the client is
+// told via "reconnecting"/"resumed" status events. events is closed on return.
+func (g *Gateway) ProcessStream(ctx context.Context, opts StreamOptions, audioFromClient <-chan []byte, events chan<- TranscriptEvent) error {
+	defer close(events)
+
+	seg, err := opts.segmenter()
 	if err != nil {
-		return fmt.Errorf("failed to create interact stream: %w", err)
+		return err
+	}
+	g.distiller = distiller.NewDistillerWithOptions(distiller.Options{Segmenter: seg, Flush: opts.Flush})
+
+	// A stream that ended normally (not cancelled, no error) reports "ended"
+	// after its trailing segment so the client knows all results are in.
+	graceful := false
+	defer func() {
+		if graceful {
+			emit(ctx, events, statusEvent(StreamStatus{Status: "ended"}))
+		}
+	}()
+
+	// Memory events publish asynchronously; report their outcome before "ended"
+	// and before events is closed.
+	defer g.publishing.Wait()
+
+	// Whatever is still buffered when the stream ends (EOF, ServerEnd, error or
+	// cancellation) is published as a trailing segment.
+	defer g.flushTrailing(ctx, opts, events)
+
+	// Timer-driven flush and client controls. The loop must stop before the
+	// trailing flush and before events is closed.
+	loopCtx, stopLoop := context.WithCancel(ctx)
+	loopDone := make(chan struct{})
+	defer func() {
+		stopLoop()
+		<-loopDone
+	}()
+	go func() {
+		defer close(loopDone)
+		g.controlLoop(loopCtx, opts, events)
+	}()
+
+	policy := g.reconnect.withDefaults()
+	pending := newAudioBuffer(policy.BufferChunks)
+
+	// Pump client audio into the buffer so it keeps being accepted while reconnecting
+	go func() {
+		defer pending.closeInput()
+		for {
+			select {
+			case audio, ok := <-audioFromClient:
+				if !ok {
+					return
+				}
+				pending.push(audio)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	connected := false
+	attempt := 0
+	for {
+		opened := time.Now()
+		established, err := g.transcribeOnce(ctx, opts, pending, events, connected)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			graceful = true
+			return nil
+		}
+		if !connected && !established {
+			// Never got a working stream: fail fast as before
+			emit(ctx, events, errorEvent(CodePCASUnavailable, err.Error()))
+			return err
+		}
+		if errors.Is(err, errStreamTerminal) {
+			return err
+		}
+		if established {
+			connected = true
+			// only a stream that stayed up past the longest backoff earns a
+			// fresh set of attempts; one PCAS accepts and drops right away
+			// keeps counting towards MaxAttempts
+			if time.Since(opened) >= policy.MaxBackoff {
+				attempt = 0
+			}
+		}
+		attempt++
+		if attempt > policy.MaxAttempts {
+			emit(ctx, events, errorEvent(CodeReconnectFailed, fmt.Sprintf("reconnect failed: %v", err)))
+			return fmt.Errorf("giving up after %d reconnect attempts: %w", policy.MaxAttempts, err)
+		}
+		delay := policy.backoff(attempt)
+		log.Printf("PCAS stream lost (%v), reconnecting in %s (attempt %d/%d)", err, delay, attempt, policy.MaxAttempts)
+		emit(ctx, events, statusEvent(StreamStatus{
+			Status:    "reconnecting",
+			Attempt:   attempt,
+			RetryInMs: delay.Milliseconds(),
+			Reason:    err.Error(),
+		}))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// transcribeOnce runs a single InteractStream until it ends or fails. established
+// reports whether the stream got past the Ready handshake.
+func (g *Gateway) transcribeOnce(ctx context.Context, opts StreamOptions, pending *audioBuffer, events chan<- TranscriptEvent, resumed bool) (bool, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := g.client.InteractStream(streamCtx)
+	if err != nil {
+		return false, fmt.Errorf("failed to create interact stream: %w", err)
 	}
 
 	configReq := &busv1.InteractRequest{
 		RequestType: &busv1.InteractRequest_Config{
 			Config: &busv1.StreamConfig{
-				EventType: eventType,
-				Attributes: map[string]string{
-					"source": "dreamscribe",
-				},
+				EventType:  opts.EventType,
+				Attributes: opts.streamAttributes(),
 			},
 		},
 	}
 	if err := stream.Send(configReq); err != nil {
-		return fmt.Errorf("failed to send config: %w", err)
+		return false, fmt.Errorf("failed to send config: %w", err)
 	}
 
 	resp, err := stream.Recv()
 	if err != nil {
-		return fmt.Errorf("failed to receive ready response: %w", err)
+		return false, fmt.Errorf("failed to receive ready response: %w", err)
 	}
 
 	ready, ok := resp.ResponseType.(*busv1.InteractResponse_Ready)
 	if !ok {
-		return fmt.Errorf("expected ready response, got %T", resp.ResponseType)
+		return false, fmt.Errorf("expected ready response, got %T", resp.ResponseType)
 	}
-	log.Printf("Stream established with ID: %s", ready.Ready.StreamId)
-
-	var wg sync.WaitGroup
-	errChan := make(chan error, 2)
+	log.Printf("Stream established with ID: %s (session %s)", ready.Ready.StreamId, opts.SessionID)
+	g.streamID.Store(ready.Ready.StreamId)
+	status := "ready"
+	if resumed {
+		status = "resumed"
+	}
+	emit(ctx, events, statusEvent(StreamStatus{
+		Status:    status,
+		StreamID:  ready.Ready.StreamId,
+		SessionID: opts.SessionID,
+		Dropped:   pending.takeDropped(),
+	}))
 
-	wg.Add(1)
+	sendErr := make(chan error, 1)
+	senderDone := make(chan struct{})
 	go func() {
-		defer wg.Done()
+		defer close(senderDone)
 		for {
-			select {
-			case audio, ok := <-audioFromClient:
-				if !ok {
-					if err := stream.Send(&busv1.InteractRequest{
-						RequestType: &busv1.InteractRequest_ClientEnd{
-							ClientEnd: &busv1.StreamEnd{},
-						},
-					}); err != nil {
-						errChan <- fmt.Errorf("failed to send client end: %w", err)
-						return
-					}
-					log.Println("Client audio stream closed, sent end signal to PCAS")
+			audio, ok, err := pending.next(streamCtx)
+			if err != nil {
+				return
+			}
+			if !ok {
+				if err := stream.Send(&busv1.InteractRequest{
+					RequestType: &busv1.InteractRequest_ClientEnd{
+						ClientEnd: &busv1.StreamEnd{},
+					},
+				}); err != nil {
+					sendErr <- fmt.Errorf("failed to send client end: %w", err)
+					cancel()
 					return
 				}
+				log.Println("Client audio stream closed, sent end signal to PCAS")
+				return
+			}
 
-				dataReq := &busv1.InteractRequest{
-					RequestType: &busv1.InteractRequest_Data{
-						Data: &busv1.StreamData{
-							Content: audio,
-						},
+			dataReq := &busv1.InteractRequest{
+				RequestType: &busv1.InteractRequest_Data{
+					Data: &busv1.StreamData{
+						Content: audio,
 					},
-				}
-				if err := stream.Send(dataReq); err != nil {
-					errChan <- fmt.Errorf("failed to send audio data: %w", err)
-					return
-				}
-			case <-ctx.Done():
+				},
+			}
+			if err := stream.Send(dataReq); err != nil {
+				// keep the chunk for the next stream
+				pending.unshift(audio)
+				sendErr <- fmt.Errorf("failed to send audio data: %w", err)
+				cancel()
 				return
 			}
 		}
 	}()
+	defer func() {
+		cancel()
+		<-senderDone
+	}()
 
-	wg.Add(1)
-    go func() {
-        defer wg.Done()
-        defer close(textToClient)
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			log.Println("PCAS stream ended")
+			return true, nil
+		}
+		if err != nil {
+			select {
+			case e := <-sendErr:
+				return true, e
+			default:
+			}
+			return true, fmt.Errorf("failed to receive from PCAS: %w", err)
+		}
 
-        for {
-            resp, err := stream.Recv()
-            if err == io.EOF {
-                log.Println("PCAS stream ended")
-                return
-            }
-            if err != nil {
-                // surface error to client stream as JSON, then report upstream
-                select {
-                case textToClient <- []byte(fmt.Sprintf("{\"error\":%q}", fmt.Sprintf("recv error: %v", err))):
-                default:
-                }
-                errChan <- fmt.Errorf("failed to receive from PCAS: %w", err)
-                return
-            }
+		switch resp := resp.ResponseType.(type) {
+		case *busv1.InteractResponse_Data:
+			text := string(resp.Data.Content)
+			emit(ctx, events, TranscriptEvent{Type: EventPartial, Raw: resp.Data.Content})
 
-            switch resp := resp.ResponseType.(type) {
-            case *busv1.InteractResponse_Data:
-                text := string(resp.Data.Content)
-                
-                if sentence := g.distiller.Process(text); sentence != "" {
-                    if err := g.publisher.PublishMemory(ctx, sentence, userID); err != nil {
-                        log.Printf("Failed to publish memory: %v", err)
-                    } else {
-                        log.Printf("Published memory event: %s", sentence)
-                    }
-                }
-                
-                textToClient <- resp.Data.Content
-            case *busv1.InteractResponse_Error:
-                // forward error to client stream as JSON for visibility
-                select {
-                case textToClient <- []byte(fmt.Sprintf("{\"error\":%q}", resp.Error.Message)):
-                default:
-                }
-                errChan <- fmt.Errorf("PCAS error: %s", resp.Error.Message)
-                return
-            case *busv1.InteractResponse_ServerEnd:
-                log.Println("PCAS server ended stream")
-                return
-            }
-        }
-    }()
+			for seg := g.distiller.Process(text); seg.Text != ""; seg = g.distiller.Next() {
+				g.publishSegment(ctx, opts, seg, events)
+			}
+		case *busv1.InteractResponse_Error:
+			// forward error to client for visibility
+			select {
+			case events <- errorEvent(CodePCASError, resp.Error.Message):
+			default:
+			}
+			return true, fmt.Errorf("%w: %s", errStreamTerminal, resp.Error.Message)
+		case *busv1.InteractResponse_ServerEnd:
+			log.Println("PCAS server ended stream")
+			return true, nil
+		}
+	}
+}
 
-	wg.Wait()
+// controlLoop polls the distiller for idle flushes (after the speaker went quiet
+// for FlushPolicy.MaxIdle) and applies client controls until ctx is done.
+func (g *Gateway) controlLoop(ctx context.Context, opts StreamOptions, events chan<- TranscriptEvent) {
+	var tick <-chan time.Time
+	if opts.Flush.MaxIdle > 0 {
+		interval := opts.Flush.MaxIdle / 2
+		if interval < 100*time.Millisecond {
+			interval = 100 * time.Millisecond
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	controls := opts.Controls
+	for {
+		select {
+		case now := <-tick:
+			if seg := g.distiller.FlushIdle(now); seg.Text != "" {
+				g.publishSegment(ctx, opts, seg, events)
+			}
+		case ctrl, ok := <-controls:
+			if !ok {
+				controls = nil
+				continue
+			}
+			switch ctrl.Type {
+			case ControlFlush:
+				if seg := g.distiller.FlushWithReason(distiller.ReasonFlush); seg.Text != "" {
+					g.publishSegment(ctx, opts, seg, events)
+				}
+			case ControlMark:
+				offset, index := g.distiller.Position()
+				emit(ctx, events, TranscriptEvent{Type: EventMark, Mark: &MarkInfo{
+					Label:        ctrl.Label,
+					Offset:       offset,
+					SegmentIndex: index,
+					At:           time.Now().UnixMilli(),
+				}})
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-	select {
-	case err := <-errChan:
-		return err
-	default:
-		return nil
+// flushTrailing publishes the distiller's leftover text as a partial final segment.
+func (g *Gateway) flushTrailing(ctx context.Context, opts StreamOptions, events chan<- TranscriptEvent) {
+	seg := g.distiller.Flush()
+	if seg.Text == "" {
+		return
 	}
+	emit(ctx, events, TranscriptEvent{Type: EventFinal, Segment: segmentInfo(seg)})
+	g.publish(ctx, opts, seg, events, map[string]string{
+		"segment": "trailing",
+		"partial": "true",
+	})
+}
+
+// publishSegment reports a distilled segment to the client and publishes it as a memory.
+func (g *Gateway) publishSegment(ctx context.Context, opts StreamOptions, seg distiller.Segment, events chan<- TranscriptEvent) {
+	emit(ctx, events, TranscriptEvent{Type: EventFinal, Segment: segmentInfo(seg)})
+	g.publish(ctx, opts, seg, events, nil)
 }
 
+// publish hands the memory event to the pool's publish queue and reports
+// success on events once PCAS accepted it. Gateways without a pool publish inline.
+func (g *Gateway) publish(ctx context.Context, opts StreamOptions, seg distiller.Segment, events chan<- TranscriptEvent, attrs map[string]string) {
+	streamID, _ := g.streamID.Load().(string)
+	event, err := MemoryEvent(Memory{
+		Text:            seg.Text,
+		UserID:          opts.UserID,
+		SessionID:       opts.SessionID,
+		TraceID:         opts.TraceID,
+		StreamID:        streamID,
+		SourceEventType: opts.EventType,
+		Language:        opts.Attributes["language"],
+		SegmentIndex:    seg.Index,
+		StartOffset:     seg.Start,
+		EndOffset:       seg.End,
+		Reason:          seg.Reason,
+		Course:          opts.Course,
+		Title:           opts.Title,
+		Tags:            opts.Tags,
+		Attributes:      attrs,
+	})
+	if err != nil {
+		log.Printf("Failed to publish memory: %v", err)
+		return
+	}
+
+	done := func(err error) {
+		switch {
+		case errors.Is(err, ErrQueued):
+			log.Printf("Memory event %s held in outbox: %v", event.Id, err)
+		case err != nil:
+			log.Printf("Failed to publish memory: %v", err)
+		default:
+			log.Printf("Published memory event: %s", seg.Text)
+			emit(ctx, events, TranscriptEvent{Type: EventMemoryPublished, Segment: segmentInfo(seg), EventID: event.Id})
+		}
+	}
+	if g.queue == nil {
+		pubCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done(g.publisher.Publish(pubCtx, event))
+		return
+	}
+
+	g.publishing.Add(1)
+	err = g.queue.Enqueue(event, func(err error) {
+		defer g.publishing.Done()
+		done(err)
+	})
+	if err != nil {
+		g.publishing.Done()
+		done(err)
+	}
+}
+
+// errStreamTerminal marks a StreamError from PCAS; those are not retried.
+var errStreamTerminal = errors.New("PCAS error")
+
+// errStreamSetup marks failures before PCAS confirmed the stream.
+var errStreamSetup = errors.New("PCAS unavailable")
+
 // CheckReady dials InteractStream, sends a StreamConfig for the given event type,
 // and waits for a Ready response. Returns error on failure.
 func (g *Gateway) CheckReady(ctx context.Context, eventType string, attributes map[string]string) error {
@@ -203,10 +507,13 @@ func (g *Gateway) PublishAdminPolicyAddRule(ctx context.Context, adminToken, eve
 
 // StartGenericStream launches a generic interact stream with PCAS and bridges bytes
 // from 'in' to PCAS and from PCAS to 'out'. It does not perform distillation or publishing.
-func (g *Gateway) StartGenericStream(ctx context.Context, eventType string, attributes map[string]string, in <-chan []byte, out chan<- []byte) error {
+// It returns once the stream is over; out is left open for the caller to close.
+// onReady, if set, receives the PCAS stream id before any data is relayed.
+// Use ErrorCode to classify the returned error.
+func (g *Gateway) StartGenericStream(ctx context.Context, eventType string, attributes map[string]string, in <-chan []byte, out chan<- []byte, onReady func(streamID string)) error {
     stream, err := g.client.InteractStream(ctx)
     if err != nil {
-        return fmt.Errorf("failed to create interact stream: %w", err)
+        return fmt.Errorf("%w: failed to create interact stream: %v", errStreamSetup, err)
     }
 
     cfg := &busv1.InteractRequest{
@@ -218,21 +525,32 @@ func (g *Gateway) StartGenericStream(ctx context.Context, eventType string, attr
         },
     }
     if err := stream.Send(cfg); err != nil {
-        return fmt.Errorf("failed to send config: %w", err)
+        return fmt.Errorf("%w: failed to send config: %v", errStreamSetup, err)
     }
 
-    if _, err := stream.Recv(); err != nil {
-        return fmt.Errorf("failed to receive ready response: %w", err)
+    first, err := stream.Recv()
+    if err != nil {
+        return fmt.Errorf("%w: failed to receive ready response: %v", errStreamSetup, err)
+    }
+    if e := first.GetError(); e != nil {
+        return fmt.Errorf("%w: %s", errStreamTerminal, e.Message)
+    }
+    if onReady != nil {
+        onReady(first.GetReady().GetStreamId())
     }
 
     var wg sync.WaitGroup
     errCh := make(chan error, 2)
+    // recvDone stops the sender once PCAS ended the stream
+    recvDone := make(chan struct{})
 
     wg.Add(1)
     go func() {
         defer wg.Done()
         for {
             select {
+            case <-recvDone:
+                return
             case b, ok := <-in:
                 if !ok {
                     // client end
@@ -252,7 +570,7 @@ func (g *Gateway) StartGenericStream(ctx context.Context, eventType string, attr
     wg.Add(1)
     go func() {
         defer wg.Done()
-        defer close(out)
+        defer close(recvDone)
         for {
             resp, err := stream.Recv()
             if err == io.EOF {
@@ -264,9 +582,13 @@ func (g *Gateway) StartGenericStream(ctx context.Context, eventType string, attr
             }
             switch r := resp.ResponseType.(type) {
             case *busv1.InteractResponse_Data:
-                out <- r.Data.Content
+                select {
+                case out <- r.Data.Content:
+                case <-ctx.Done():
+                    return
+                }
             case *busv1.InteractResponse_Error:
-                errCh <- fmt.Errorf("PCAS error: %s", r.Error.Message)
+                errCh <- fmt.Errorf("%w: %s", errStreamTerminal, r.Error.Message)
                 return
             case *busv1.InteractResponse_ServerEnd:
                 return
@@ -0,0 +1,390 @@
+package pcas
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/status"
+)
+
+// transcribeServer plays PCAS for ProcessStream: every stream echoes the audio
+// chunks it receives back as transcript text. Stream n is dropped after
+// dropAfter[n] chunks; streams from refuseFrom on fail before Ready. end
+// decides how a stream that saw ClientEnd finishes.
+type transcribeServer struct {
+    busv1.UnimplementedEventBusServiceServer
+    dropAfter []int
+    // refuseFrom is ignored when 0.
+    refuseFrom int
+    end        func(stream busv1.EventBusService_InteractStreamServer) error
+
+    mu        sync.Mutex
+    configs   []*busv1.StreamConfig
+    chunks    [][]string
+    clientEnd []bool
+    published []*eventsv1.Event
+}
+
+func (s *transcribeServer) InteractStream(stream busv1.EventBusService_InteractStreamServer) error {
+    req, err := stream.Recv()
+    if err != nil {
+        return err
+    }
+    s.mu.Lock()
+    n := len(s.configs)
+    s.configs = append(s.configs, req.GetConfig())
+    s.chunks = append(s.chunks, nil)
+    s.clientEnd = append(s.clientEnd, false)
+    s.mu.Unlock()
+    if s.refuseFrom > 0 && n >= s.refuseFrom {
+        return status.Error(codes.Unavailable, "PCAS is down")
+    }
+    ready := &busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Ready{Ready: &busv1.StreamReady{StreamId: "pcas-stream"}}}
+    if err := stream.Send(ready); err != nil {
+        return err
+    }
+    for received := 0; ; received++ {
+        if n < len(s.dropAfter) && received == s.dropAfter[n] {
+            return status.Error(codes.Unavailable, "connection reset")
+        }
+        req, err := stream.Recv()
+        if err != nil {
+            return err
+        }
+        if req.GetClientEnd() != nil {
+            s.mu.Lock()
+            s.clientEnd[n] = true
+            s.mu.Unlock()
+            if s.end != nil {
+                return s.end(stream)
+            }
+            return stream.Send(&busv1.InteractResponse{ResponseType: &busv1.InteractResponse_ServerEnd{ServerEnd: &busv1.StreamEnd{}}})
+        }
+        chunk := req.GetData().GetContent()
+        s.mu.Lock()
+        s.chunks[n] = append(s.chunks[n], string(chunk))
+        s.mu.Unlock()
+        if err := stream.Send(&busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Data{Data: &busv1.StreamData{Content: chunk}}}); err != nil {
+            return err
+        }
+    }
+}
+
+func (s *transcribeServer) Publish(_ context.Context, ev *eventsv1.Event) (*busv1.PublishResponse, error) {
+    s.mu.Lock()
+    s.published = append(s.published, ev)
+    s.mu.Unlock()
+    return &busv1.PublishResponse{}, nil
+}
+
+// testGateway serves s on a local port and returns a gateway dialing it.
+func testGateway(t *testing.T, s *transcribeServer, policy ReconnectPolicy) *Gateway {
+    t.Helper()
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := grpc.NewServer()
+    busv1.RegisterEventBusServiceServer(srv, s)
+    go func() { _ = srv.Serve(lis) }()
+    t.Cleanup(srv.Stop)
+    conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    g := newGateway(lis.Addr().String(), conn, true)
+    g.reconnect = policy
+    t.Cleanup(func() { _ = g.Close() })
+    return g
+}
+
+var testStreamOptions = StreamOptions{
+    EventType:  "capability.streaming.transcribe.v1",
+    UserID:     "alice",
+    SessionID:  "s1",
+    TraceID:    "trace-1",
+    Attributes: map[string]string{"language": "zh"},
+}
+
+// runStream runs ProcessStream in the background; react sees every event as
+// it arrives. It returns the events and ProcessStream's error.
+func runStream(t *testing.T, ctx context.Context, g *Gateway, audio chan []byte, react func(TranscriptEvent)) ([]TranscriptEvent, error) {
+    t.Helper()
+    events := make(chan TranscriptEvent, 64)
+    result := make(chan error, 1)
+    go func() { result <- g.ProcessStream(ctx, testStreamOptions, audio, events) }()
+    var got []TranscriptEvent
+    timeout := time.After(10 * time.Second)
+    for {
+        select {
+        case ev, ok := <-events:
+            if !ok {
+                return got, <-result
+            }
+            got = append(got, ev)
+            if react != nil {
+                react(ev)
+            }
+        case <-timeout:
+            t.Fatal("ProcessStream did not end")
+        }
+    }
+}
+
+// statuses lists the status and error events as "status:<name>" and "error:<code>".
+func statuses(events []TranscriptEvent) []string {
+    var out []string
+    for _, ev := range events {
+        switch ev.Type {
+        case EventStatus:
+            out = append(out, "status:"+ev.Status.Status)
+        case EventError:
+            out = append(out, "error:"+ev.Code)
+        }
+    }
+    return out
+}
+
+func TestProcessStreamReconnects(t *testing.T) {
+    // the first stream drops after one chunk
+    s := &transcribeServer{dropAfter: []int{1}}
+    g := testGateway(t, s, ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 200 * time.Millisecond, MaxBackoff: time.Second, BufferChunks: 16})
+
+    audio := make(chan []byte, 8)
+    audio <- []byte("第一句。")
+    events, err := runStream(t, context.Background(), g, audio, func(ev TranscriptEvent) {
+        // audio sent while reconnecting is held for the next stream
+        if ev.Type == EventStatus && ev.Status.Status == "reconnecting" {
+            audio <- []byte("第二句。")
+            audio <- []byte("第三句。")
+            close(audio)
+        }
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := []string{"status:ready", "status:reconnecting", "status:resumed", "status:ended"}
+    if got := statuses(events); strings.Join(got, " ") != strings.Join(want, " ") {
+        t.Fatalf("statuses = %v, want %v", got, want)
+    }
+    for _, ev := range events {
+        if ev.Type == EventStatus && ev.Status.Status == "reconnecting" {
+            if ev.Status.Attempt != 1 || ev.Status.RetryInMs != 200 || ev.Status.Reason == "" {
+                t.Fatalf("reconnecting = %+v", ev.Status)
+            }
+        }
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if len(s.configs) != 2 {
+        t.Fatalf("%d streams opened, want 2", len(s.configs))
+    }
+    // the resumed stream is configured like the first one
+    for i, cfg := range s.configs {
+        attrs := cfg.GetAttributes()
+        if cfg.GetEventType() != testStreamOptions.EventType || attrs["session_id"] != "s1" || attrs["user_id"] != "alice" || attrs["trace_id"] != "trace-1" || attrs["language"] != "zh" {
+            t.Fatalf("stream %d config = %v", i, cfg)
+        }
+    }
+    if got := strings.Join(s.chunks[0], "|"); got != "第一句。" {
+        t.Fatalf("first stream got %q", got)
+    }
+    if got := strings.Join(s.chunks[1], "|"); got != "第二句。|第三句。" {
+        t.Fatalf("resumed stream got %q, want the buffered audio", got)
+    }
+    if !s.clientEnd[1] {
+        t.Fatal("resumed stream did not get ClientEnd")
+    }
+}
+
+func TestProcessStreamReconnectFailed(t *testing.T) {
+    // after the first stream drops PCAS refuses every new one
+    s := &transcribeServer{dropAfter: []int{1}, refuseFrom: 1}
+    g := testGateway(t, s, ReconnectPolicy{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, BufferChunks: 16})
+
+    audio := make(chan []byte, 8)
+    audio <- []byte("第一句。")
+    events, err := runStream(t, context.Background(), g, audio, nil)
+    if err == nil {
+        t.Fatal("ProcessStream succeeded")
+    }
+    want := []string{"status:ready", "status:reconnecting", "status:reconnecting", "error:" + CodeReconnectFailed}
+    if got := statuses(events); strings.Join(got, " ") != strings.Join(want, " ") {
+        t.Fatalf("statuses = %v, want %v", got, want)
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if len(s.configs) != 3 {
+        t.Fatalf("%d streams opened, want the first and 2 attempts", len(s.configs))
+    }
+}
+
+func TestProcessStreamReadyThenDropGivesUp(t *testing.T) {
+    // PCAS accepts every stream and drops it right after Ready
+    s := &transcribeServer{dropAfter: []int{0, 0, 0, 0, 0, 0, 0, 0}}
+    g := testGateway(t, s, ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 5 * time.Millisecond, MaxBackoff: time.Second, BufferChunks: 16})
+
+    audio := make(chan []byte)
+    defer close(audio)
+    events, err := runStream(t, context.Background(), g, audio, nil)
+    if err == nil {
+        t.Fatal("ProcessStream succeeded")
+    }
+    want := []string{
+        "status:ready", "status:reconnecting",
+        "status:resumed", "status:reconnecting",
+        "status:resumed", "status:reconnecting",
+        "status:resumed", "error:" + CodeReconnectFailed,
+    }
+    if got := statuses(events); strings.Join(got, " ") != strings.Join(want, " ") {
+        t.Fatalf("statuses = %v, want %v", got, want)
+    }
+    var attempts []int
+    for _, ev := range events {
+        if ev.Type == EventStatus && ev.Status.Status == "reconnecting" {
+            attempts = append(attempts, ev.Status.Attempt)
+        }
+    }
+    if fmt.Sprint(attempts) != "[1 2 3]" {
+        t.Fatalf("attempts = %v, want [1 2 3]", attempts)
+    }
+}
+
+func TestProcessStreamHealthyStreamResetsAttempts(t *testing.T) {
+    // each of the first two streams drops after one chunk; the second stayed
+    // up past MaxBackoff, so its drop starts counting from 1 again
+    s := &transcribeServer{dropAfter: []int{1, 1}}
+    g := testGateway(t, s, ReconnectPolicy{MaxAttempts: 1, InitialBackoff: 5 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, BufferChunks: 16})
+
+    audio := make(chan []byte, 8)
+    audio <- []byte("第一句。")
+    resumed := 0
+    events, err := runStream(t, context.Background(), g, audio, func(ev TranscriptEvent) {
+        if ev.Type != EventStatus || ev.Status.Status != "resumed" {
+            return
+        }
+        if resumed++; resumed == 1 {
+            go func() {
+                time.Sleep(100 * time.Millisecond)
+                audio <- []byte("第二句。")
+            }()
+        } else {
+            close(audio)
+        }
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"status:ready", "status:reconnecting", "status:resumed", "status:reconnecting", "status:resumed", "status:ended"}
+    if got := statuses(events); strings.Join(got, " ") != strings.Join(want, " ") {
+        t.Fatalf("statuses = %v, want %v", got, want)
+    }
+}
+
+func TestProcessStreamUnavailable(t *testing.T) {
+    // a stream that never got ready fails without retrying
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := lis.Addr().String()
+    lis.Close()
+    conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    g := newGateway(addr, conn, true)
+    defer g.Close()
+
+    audio := make(chan []byte)
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    events, err := runStream(t, ctx, g, audio, nil)
+    if err == nil {
+        t.Fatal("ProcessStream succeeded without PCAS")
+    }
+    if got := statuses(events); len(got) != 1 || got[0] != "error:"+CodePCASUnavailable {
+        t.Fatalf("statuses = %v", got)
+    }
+}
+
+func TestProcessStreamFlushesTrailing(t *testing.T) {
+    const tail = "没有句号的最后一句"
+    cases := []struct {
+        name string
+        end  func(busv1.EventBusService_InteractStreamServer) error
+        // cancel ends the session from the client side instead of closing the audio
+        cancel bool
+        // last is the final status or error event of the session
+        last string
+    }{
+        {"EOF", func(busv1.EventBusService_InteractStreamServer) error { return nil }, false, "status:ended"},
+        {"ServerEnd", nil, false, "status:ended"},
+        {"stream error", func(stream busv1.EventBusService_InteractStreamServer) error {
+            return stream.Send(&busv1.InteractResponse{ResponseType: &busv1.InteractResponse_Error{Error: &busv1.StreamError{Code: 500, Message: "boom"}}})
+        }, false, "error:" + CodePCASError},
+        {"context canceled", nil, true, ""},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            s := &transcribeServer{end: tc.end}
+            g := testGateway(t, s, ReconnectPolicy{MaxAttempts: 1, InitialBackoff: 10 * time.Millisecond})
+
+            ctx, cancel := context.WithCancel(context.Background())
+            defer cancel()
+            audio := make(chan []byte, 2)
+            audio <- []byte("第一句。" + tail)
+            if !tc.cancel {
+                close(audio)
+            }
+            events, _ := runStream(t, ctx, g, audio, func(ev TranscriptEvent) {
+                if tc.cancel && ev.Type == EventPartial {
+                    cancel()
+                }
+            })
+
+            s.mu.Lock()
+            defer s.mu.Unlock()
+            var trailing *eventsv1.Event
+            for _, ev := range s.published {
+                if ev.GetSubject() == tail {
+                    trailing = ev
+                }
+            }
+            if trailing == nil {
+                t.Fatalf("trailing text not published; published %d events", len(s.published))
+            }
+            if attrs := trailing.GetAttributes(); attrs["segment"] != "trailing" || attrs["partial"] != "true" || attrs["session_id"] != "s1" {
+                t.Fatalf("trailing attributes = %v", attrs)
+            }
+            if tc.cancel {
+                // the client is gone; only the memory matters
+                return
+            }
+            found := false
+            for _, ev := range events {
+                if ev.Type == EventFinal && ev.Segment.Text == tail {
+                    found = true
+                }
+            }
+            if !found {
+                t.Fatalf("no final event for the trailing text: %v", statuses(events))
+            }
+            if got := statuses(events); got[len(got)-1] != tc.last {
+                t.Fatalf("statuses = %v, want %s last", got, tc.last)
+            }
+        })
+    }
+}
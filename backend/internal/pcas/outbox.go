@@ -0,0 +1,444 @@
+package pcas
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+    "google.golang.org/protobuf/proto"
+)
+
+// OutboxOptions configures an Outbox. Zero values fall back to sane defaults.
+type OutboxOptions struct {
+    // Dir holds the outbox log; it is created if missing.
+    Dir            string
+    InitialBackoff time.Duration
+    MaxBackoff     time.Duration
+    // PublishTimeout bounds each retry attempt of the background worker.
+    PublishTimeout time.Duration
+    // CompactAfter rewrites the log once this many acknowledgements piled up,
+    // CompactBytes once it grew this large with at least one of them.
+    CompactAfter int
+    CompactBytes int64
+}
+
+// ErrQueued reports that an event was stored but not published yet; the
+// outbox worker will deliver it later.
+var ErrQueued = errors.New("event queued in outbox")
+
+const outboxFile = "outbox.log"
+
+// outboxRecord is one line of the append-only log. An event is pending from
+// its "put" record until a matching "ack" record.
+type outboxRecord struct {
+    Op string `json:"op"`
+    ID string `json:"id"`
+    At int64  `json:"at,omitempty"`
+    // Event is the proto-encoded eventsv1.Event.
+    Event []byte `json:"event,omitempty"`
+}
+
+type outboxEntry struct {
+    id        string
+    queuedAt  time.Time
+    event     *eventsv1.Event
+    attempts  int
+    lastError string
+    // inflight marks the entry currently being published by someone.
+    inflight bool
+}
+
+// Outbox persists memory events on disk before they are published so that a
+// PCAS outage or a restart does not lose them. Pending events are retried in
+// order by a background worker (see Pool.AttachOutbox).
+type Outbox struct {
+    opts OutboxOptions
+    path string
+
+    mu      sync.Mutex
+    f       *os.File
+    pending []*outboxEntry
+    // acked counts ack records and size the bytes in the log since the last compaction.
+    acked int
+    size  int64
+
+    publish func(ctx context.Context, event *eventsv1.Event) error
+    wake    chan struct{}
+}
+
+// OutboxEntryInfo describes one pending event. It carries no transcript text
+// or session id since it is served without authentication.
+type OutboxEntryInfo struct {
+    ID        string    `json:"id"`
+    Type      string    `json:"type"`
+    QueuedAt  time.Time `json:"queuedAt"`
+    Attempts  int       `json:"attempts"`
+    LastError string    `json:"lastError,omitempty"`
+}
+
+// OutboxStats is a snapshot of the outbox.
+type OutboxStats struct {
+    Depth  int              `json:"depth"`
+    Oldest *OutboxEntryInfo `json:"oldest,omitempty"`
+}
+
+// OpenOutbox opens (or creates) the outbox in opts.Dir and loads every event
+// that was not acknowledged before the last shutdown.
+func OpenOutbox(opts OutboxOptions) (*Outbox, error) {
+    if opts.Dir == "" {
+        return nil, errors.New("outbox directory is not set")
+    }
+    if opts.InitialBackoff <= 0 {
+        opts.InitialBackoff = time.Second
+    }
+    if opts.MaxBackoff <= 0 {
+        opts.MaxBackoff = time.Minute
+    }
+    if opts.PublishTimeout <= 0 {
+        opts.PublishTimeout = 10 * time.Second
+    }
+    if opts.CompactAfter <= 0 {
+        opts.CompactAfter = 1000
+    }
+    if opts.CompactBytes <= 0 {
+        opts.CompactBytes = 16 << 20
+    }
+    if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+        return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+    }
+
+    o := &Outbox{
+        opts: opts,
+        path: filepath.Join(opts.Dir, outboxFile),
+        wake: make(chan struct{}, 1),
+    }
+    if err := o.load(); err != nil {
+        return nil, err
+    }
+    // Start from a clean log holding only what is still pending
+    if err := o.compact(); err != nil {
+        return nil, err
+    }
+    if n := len(o.pending); n > 0 {
+        log.Printf("[outbox] %d pending event(s) will be replayed", n)
+    }
+    return o, nil
+}
+
+// load replays the log. A torn last line (crash mid-write) is skipped.
+func (o *Outbox) load() error {
+    f, err := os.Open(o.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to open outbox: %w", err)
+    }
+    defer f.Close()
+
+    index := map[string]*outboxEntry{}
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for line := 1; sc.Scan(); line++ {
+        var rec outboxRecord
+        if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+            log.Printf("[outbox] skipping unreadable record at line %d: %v", line, err)
+            continue
+        }
+        switch rec.Op {
+        case "put":
+            ev := &eventsv1.Event{}
+            if err := proto.Unmarshal(rec.Event, ev); err != nil {
+                log.Printf("[outbox] skipping undecodable event %s: %v", rec.ID, err)
+                continue
+            }
+            e := &outboxEntry{id: rec.ID, queuedAt: time.UnixMilli(rec.At), event: ev}
+            index[rec.ID] = e
+            o.pending = append(o.pending, e)
+        case "ack":
+            delete(index, rec.ID)
+        }
+    }
+    if err := sc.Err(); err != nil {
+        return fmt.Errorf("failed to read outbox: %w", err)
+    }
+    kept := o.pending[:0]
+    for _, e := range o.pending {
+        if index[e.id] == e {
+            kept = append(kept, e)
+        }
+    }
+    o.pending = kept
+    return nil
+}
+
+// compact rewrites the log with only the pending events and reopens it for appending.
+// Callers other than OpenOutbox must hold o.mu.
+func (o *Outbox) compact() error {
+    tmp := o.path + ".tmp"
+    f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+    if err != nil {
+        return fmt.Errorf("failed to compact outbox: %w", err)
+    }
+    w := bufio.NewWriter(f)
+    var size int64
+    for _, e := range o.pending {
+        b, err := encodePut(e)
+        if err == nil {
+            _, err = w.Write(b)
+            size += int64(len(b))
+        }
+        if err != nil {
+            f.Close()
+            return fmt.Errorf("failed to compact outbox: %w", err)
+        }
+    }
+    if err := w.Flush(); err != nil {
+        f.Close()
+        return fmt.Errorf("failed to compact outbox: %w", err)
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return fmt.Errorf("failed to compact outbox: %w", err)
+    }
+    f.Close()
+    if err := os.Rename(tmp, o.path); err != nil {
+        return fmt.Errorf("failed to compact outbox: %w", err)
+    }
+
+    if o.f != nil {
+        o.f.Close()
+    }
+    o.f, err = os.OpenFile(o.path, os.O_APPEND|os.O_WRONLY, 0o644)
+    if err != nil {
+        return fmt.Errorf("failed to reopen outbox: %w", err)
+    }
+    o.acked, o.size = 0, size
+    return nil
+}
+
+func encodePut(e *outboxEntry) ([]byte, error) {
+    data, err := proto.Marshal(e.event)
+    if err != nil {
+        return nil, err
+    }
+    return encodeRecord(outboxRecord{Op: "put", ID: e.id, At: e.queuedAt.UnixMilli(), Event: data})
+}
+
+func encodeRecord(rec outboxRecord) ([]byte, error) {
+    b, err := json.Marshal(rec)
+    if err != nil {
+        return nil, err
+    }
+    return append(b, '\n'), nil
+}
+
+// store persists events with a single fsync. With claim set it returns the
+// entries the caller should publish right away; while older events wait for a
+// retry, new ones are left to the outbox worker instead so the backlog drains
+// first. Entries that are not returned belong to the worker.
+func (o *Outbox) store(events []*eventsv1.Event, claim bool) ([]*outboxEntry, error) {
+    now := time.Now()
+    entries := make([]*outboxEntry, len(events))
+    var buf []byte
+    for i, ev := range events {
+        entries[i] = &outboxEntry{id: ev.Id, queuedAt: now, event: ev}
+        b, err := encodePut(entries[i])
+        if err != nil {
+            return nil, fmt.Errorf("failed to encode event %s: %w", ev.Id, err)
+        }
+        buf = append(buf, b...)
+    }
+
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if err := o.appendLocked(buf, true); err != nil {
+        return nil, fmt.Errorf("failed to persist events: %w", err)
+    }
+    backlog := false
+    for _, e := range o.pending {
+        if !e.inflight {
+            backlog = true
+            break
+        }
+    }
+    o.pending = append(o.pending, entries...)
+    if !claim || backlog {
+        o.kick()
+        return nil, nil
+    }
+    for _, e := range entries {
+        e.inflight = true
+    }
+    return entries, nil
+}
+
+// appendLocked writes one record; put records are fsynced before returning.
+func (o *Outbox) appendLocked(b []byte, sync bool) error {
+    if o.f == nil {
+        return errors.New("outbox is closed")
+    }
+    n, err := o.f.Write(b)
+    o.size += int64(n)
+    if err != nil {
+        return err
+    }
+    if sync {
+        return o.f.Sync()
+    }
+    return nil
+}
+
+// ack records a successful delivery and drops the entry.
+func (o *Outbox) ack(e *outboxEntry) {
+    b, _ := encodeRecord(outboxRecord{Op: "ack", ID: e.id})
+
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    for i, p := range o.pending {
+        if p == e {
+            o.pending = append(o.pending[:i], o.pending[i+1:]...)
+            break
+        }
+    }
+    if len(o.pending) > 0 {
+        o.kick()
+    }
+    if err := o.appendLocked(b, false); err != nil {
+        // Worst case the event is published again after a restart
+        log.Printf("[outbox] failed to record ack for %s: %v", e.id, err)
+        return
+    }
+    // Acks are cheap appends; the rewrite and its fsync only pay off once
+    // enough dead records piled up
+    o.acked++
+    if o.acked >= o.opts.CompactAfter || o.size >= o.opts.CompactBytes {
+        if err := o.compact(); err != nil {
+            log.Printf("[outbox] %v", err)
+        }
+    }
+}
+
+// release returns a failed entry to the worker.
+func (o *Outbox) release(e *outboxEntry, err error) {
+    o.mu.Lock()
+    e.inflight = false
+    e.attempts++
+    e.lastError = err.Error()
+    o.mu.Unlock()
+    o.kick()
+}
+
+func (o *Outbox) kick() {
+    select {
+    case o.wake <- struct{}{}:
+    default:
+    }
+}
+
+// head claims the oldest pending entry, or returns nil if there is none or
+// it is already being published.
+func (o *Outbox) head() *outboxEntry {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if len(o.pending) == 0 || o.pending[0].inflight {
+        return nil
+    }
+    e := o.pending[0]
+    e.inflight = true
+    return e
+}
+
+// run delivers pending events in order until ctx is done, backing off while
+// PCAS keeps failing.
+func (o *Outbox) run(ctx context.Context) {
+    attempt := 0
+    for {
+        e := o.head()
+        if e == nil {
+            select {
+            case <-o.wake:
+                continue
+            case <-ctx.Done():
+                return
+            }
+        }
+
+        pubCtx, cancel := context.WithTimeout(ctx, o.opts.PublishTimeout)
+        err := o.publish(pubCtx, e.event)
+        cancel()
+        if err == nil {
+            o.ack(e)
+            if attempt > 0 {
+                log.Printf("[outbox] delivered %s after PCAS recovered", e.id)
+            }
+            attempt = 0
+            continue
+        }
+
+        o.mu.Lock()
+        e.inflight = false
+        e.attempts++
+        e.lastError = err.Error()
+        depth := len(o.pending)
+        o.mu.Unlock()
+
+        attempt++
+        delay := o.backoff(attempt)
+        log.Printf("[outbox] publish of %s failed (%d pending, retry in %s): %v", e.id, depth, delay, err)
+        select {
+        case <-time.After(delay):
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+func (o *Outbox) backoff(attempt int) time.Duration {
+    d := o.opts.InitialBackoff
+    for i := 1; i < attempt; i++ {
+        d *= 2
+        if d >= o.opts.MaxBackoff {
+            return o.opts.MaxBackoff
+        }
+    }
+    return d
+}
+
+// Stats reports the number of pending events and the oldest of them.
+func (o *Outbox) Stats() OutboxStats {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    st := OutboxStats{Depth: len(o.pending)}
+    if len(o.pending) > 0 {
+        e := o.pending[0]
+        st.Oldest = &OutboxEntryInfo{
+            ID:        e.id,
+            Type:      e.event.GetType(),
+            QueuedAt:  e.queuedAt,
+            Attempts:  e.attempts,
+            LastError: e.lastError,
+        }
+    }
+    return st
+}
+
+// Close closes the log file. Pending events stay on disk for the next start.
+func (o *Outbox) Close() error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if o.f == nil {
+        return nil
+    }
+    err := o.f.Close()
+    o.f = nil
+    return err
+}
@@ -0,0 +1,172 @@
+package pcas
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+)
+
+func testEvents(ids ...string) []*eventsv1.Event {
+    events := make([]*eventsv1.Event, len(ids))
+    for i, id := range ids {
+        events[i] = &eventsv1.Event{Id: id, Type: "pcas.memory.create.v1", Subject: "text of " + id}
+    }
+    return events
+}
+
+func openTestOutbox(t *testing.T, opts OutboxOptions) *Outbox {
+    t.Helper()
+    o, err := OpenOutbox(opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { _ = o.Close() })
+    return o
+}
+
+func pendingIDs(o *Outbox) []string {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    ids := []string{}
+    for _, e := range o.pending {
+        ids = append(ids, e.id)
+    }
+    return ids
+}
+
+// logLines returns the number of records in the outbox log.
+func logLines(t *testing.T, dir string) int {
+    t.Helper()
+    b, err := os.ReadFile(filepath.Join(dir, outboxFile))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return bytes.Count(b, []byte("\n"))
+}
+
+func TestOutboxReplayOrder(t *testing.T) {
+    dir := t.TempDir()
+    o := openTestOutbox(t, OutboxOptions{Dir: dir})
+    claimed, err := o.store(testEvents("ev-1", "ev-2"), true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := o.store(testEvents("ev-3", "ev-4"), false); err != nil {
+        t.Fatal(err)
+    }
+    o.ack(claimed[1])
+    if err := o.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    reopened := openTestOutbox(t, OutboxOptions{Dir: dir})
+    if got, want := pendingIDs(reopened), []string{"ev-1", "ev-3", "ev-4"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("pending after reopen = %v, want %v", got, want)
+    }
+    e := reopened.head()
+    if e == nil || e.id != "ev-1" || e.event.GetSubject() != "text of ev-1" {
+        t.Fatalf("head = %+v, want ev-1 with its event", e)
+    }
+    // the reopened log holds only what is pending
+    if n := logLines(t, dir); n != 3 {
+        t.Fatalf("%d records after reopen, want 3", n)
+    }
+}
+
+func TestOutboxTruncatedTrailingLine(t *testing.T) {
+    dir := t.TempDir()
+    o := openTestOutbox(t, OutboxOptions{Dir: dir})
+    if _, err := o.store(testEvents("ev-1", "ev-2"), false); err != nil {
+        t.Fatal(err)
+    }
+    if err := o.Close(); err != nil {
+        t.Fatal(err)
+    }
+    // crash in the middle of writing ev-2
+    path := filepath.Join(dir, outboxFile)
+    b, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(path, b[:len(b)-10], 0o644); err != nil {
+        t.Fatal(err)
+    }
+
+    o = openTestOutbox(t, OutboxOptions{Dir: dir})
+    if got, want := pendingIDs(o), []string{"ev-1"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("pending = %v, want %v", got, want)
+    }
+    // records written after the torn line are readable again
+    if _, err := o.store(testEvents("ev-3"), false); err != nil {
+        t.Fatal(err)
+    }
+    if err := o.Close(); err != nil {
+        t.Fatal(err)
+    }
+    o = openTestOutbox(t, OutboxOptions{Dir: dir})
+    if got, want := pendingIDs(o), []string{"ev-1", "ev-3"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("pending after second reopen = %v, want %v", got, want)
+    }
+}
+
+func TestOutboxAckCompaction(t *testing.T) {
+    t.Run("after CompactAfter acks", func(t *testing.T) {
+        dir := t.TempDir()
+        o := openTestOutbox(t, OutboxOptions{Dir: dir, CompactAfter: 3})
+        claimed, err := o.store(testEvents("ev-1", "ev-2", "ev-3", "ev-4"), true)
+        if err != nil {
+            t.Fatal(err)
+        }
+        o.ack(claimed[0])
+        o.ack(claimed[1])
+        // below the threshold acks are only appended
+        if n := logLines(t, dir); n != 6 {
+            t.Fatalf("%d records after 2 acks, want 6", n)
+        }
+        o.ack(claimed[2])
+        if n := logLines(t, dir); n != 1 {
+            t.Fatalf("%d records after compaction, want 1", n)
+        }
+        if got := pendingIDs(o); !reflect.DeepEqual(got, []string{"ev-4"}) {
+            t.Fatalf("pending = %v", got)
+        }
+    })
+
+    t.Run("emptied outbox is not rewritten", func(t *testing.T) {
+        dir := t.TempDir()
+        o := openTestOutbox(t, OutboxOptions{Dir: dir, CompactAfter: 10})
+        for _, id := range []string{"ev-1", "ev-2"} {
+            claimed, err := o.store(testEvents(id), true)
+            if err != nil {
+                t.Fatal(err)
+            }
+            o.ack(claimed[0])
+        }
+        if n := logLines(t, dir); n != 4 {
+            t.Fatalf("%d records, want 4", n)
+        }
+        if err := o.Close(); err != nil {
+            t.Fatal(err)
+        }
+        if got := pendingIDs(openTestOutbox(t, OutboxOptions{Dir: dir})); len(got) != 0 {
+            t.Fatalf("acked events replayed: %v", got)
+        }
+    })
+
+    t.Run("after CompactBytes", func(t *testing.T) {
+        dir := t.TempDir()
+        o := openTestOutbox(t, OutboxOptions{Dir: dir, CompactBytes: 1})
+        claimed, err := o.store(testEvents("ev-1", "ev-2"), true)
+        if err != nil {
+            t.Fatal(err)
+        }
+        o.ack(claimed[0])
+        if n := logLines(t, dir); n != 1 {
+            t.Fatalf("%d records, want 1", n)
+        }
+    })
+}
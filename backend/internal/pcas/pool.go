@@ -0,0 +1,275 @@
+package pcas
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/connectivity"
+    "google.golang.org/grpc/keepalive"
+)
+
+// DefaultKeepaliveTime matches the minimum ping interval grpc-go servers
+// enforce by default (keepalive.EnforcementPolicy.MinTime); pinging more often
+// gets the connection closed with GOAWAY too_many_pings.
+const DefaultKeepaliveTime = 5 * time.Minute
+
+// PoolOptions configures a Pool. Zero values fall back to sane defaults.
+type PoolOptions struct {
+    Size             int
+    KeepaliveTime    time.Duration
+    KeepaliveTimeout time.Duration
+    // KeepaliveWithoutStream also pings idle connections. PCAS must permit it
+    // (EnforcementPolicy.PermitWithoutStream), which grpc-go servers do not by default.
+    KeepaliveWithoutStream bool
+    Reconnect        ReconnectPolicy
+    TLS              TLSOptions
+    Publish          PublishOptions
+}
+
+// Pool is a set of long-lived gRPC connections to PCAS shared by all handlers.
+// Gateways borrowed from the pool never close the underlying connection.
+type Pool struct {
+    address   string
+    conns     []*grpc.ClientConn
+    next      atomic.Uint32
+    reconnect ReconnectPolicy
+    outbox    *Outbox
+    queue     *publishQueue
+    sub       *Subscriber
+
+    mu     sync.RWMutex
+    states []connectivity.State
+    closed bool
+
+    ctx    context.Context
+    cancel context.CancelFunc
+    wg     sync.WaitGroup
+}
+
+// ConnState reports the connectivity state of one pooled connection.
+type ConnState struct {
+    Index int    `json:"index"`
+    State string `json:"state"`
+}
+
+func NewPool(address string, opts PoolOptions) (*Pool, error) {
+    if opts.Size <= 0 {
+        opts.Size = 1
+    }
+    if opts.KeepaliveTime <= 0 {
+        opts.KeepaliveTime = DefaultKeepaliveTime
+    }
+    if opts.KeepaliveTimeout <= 0 {
+        opts.KeepaliveTimeout = 10 * time.Second
+    }
+
+    creds, err := transportCredentials(opts.TLS)
+    if err != nil {
+        return nil, err
+    }
+    dialOpts := []grpc.DialOption{
+        grpc.WithTransportCredentials(creds),
+        grpc.WithKeepaliveParams(keepalive.ClientParameters{
+            Time:                opts.KeepaliveTime,
+            Timeout:             opts.KeepaliveTimeout,
+            PermitWithoutStream: opts.KeepaliveWithoutStream,
+        }),
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    p := &Pool{
+        address:   address,
+        conns:     make([]*grpc.ClientConn, 0, opts.Size),
+        reconnect: opts.Reconnect.withDefaults(),
+        states:    make([]connectivity.State, opts.Size),
+        ctx:       ctx,
+        cancel:    cancel,
+    }
+    for i := 0; i < opts.Size; i++ {
+        conn, err := grpc.NewClient(address, dialOpts...)
+        if err != nil {
+            cancel()
+            for _, c := range p.conns {
+                _ = c.Close()
+            }
+            return nil, fmt.Errorf("failed to connect to PCAS: %w", err)
+        }
+        p.conns = append(p.conns, conn)
+        p.states[i] = conn.GetState()
+    }
+    for i := range p.conns {
+        p.wg.Add(1)
+        go p.watch(i)
+    }
+    p.queue = newPublishQueue(opts.Publish, p.publishEvent)
+    return p, nil
+}
+
+// watch records state transitions of one connection until the pool closes.
+func (p *Pool) watch(i int) {
+    defer p.wg.Done()
+    conn := p.conns[i]
+    state := conn.GetState()
+    for {
+        p.mu.Lock()
+        prev := p.states[i]
+        p.states[i] = state
+        p.mu.Unlock()
+        if prev != state {
+            log.Printf("[pcas-pool] conn=%d %s -> %s", i, prev, state)
+        }
+        if !conn.WaitForStateChange(p.ctx, state) {
+            return
+        }
+        state = conn.GetState()
+    }
+}
+
+// Warmup kicks every connection out of IDLE and waits until at least one is READY.
+func (p *Pool) Warmup(ctx context.Context) error {
+    for _, conn := range p.conns {
+        conn.Connect()
+    }
+    for {
+        ready := false
+        for _, conn := range p.conns {
+            s := conn.GetState()
+            if s == connectivity.Ready {
+                ready = true
+            } else if s == connectivity.Idle {
+                conn.Connect()
+            }
+        }
+        if ready {
+            return nil
+        }
+        select {
+        case <-ctx.Done():
+            return fmt.Errorf("PCAS warm-up at %s: %w", p.address, ctx.Err())
+        case <-time.After(50 * time.Millisecond):
+        }
+    }
+}
+
+// Conn picks a connection round-robin, preferring ones that are READY.
+func (p *Pool) Conn() *grpc.ClientConn {
+    n := len(p.conns)
+    start := int(p.next.Add(1))
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+    for i := 0; i < n; i++ {
+        idx := (start + i) % n
+        if p.states[idx] == connectivity.Ready {
+            return p.conns[idx]
+        }
+    }
+    return p.conns[start%n]
+}
+
+// Gateway returns a gateway bound to a pooled connection. Closing it is a no-op
+// for the connection itself.
+func (p *Pool) Gateway() *Gateway {
+    g := newGateway(p.address, p.Conn(), false)
+    g.reconnect = p.reconnect
+    g.queue = p.queue
+    return g
+}
+
+// publishEvent publishes one event over a pooled connection.
+func (p *Pool) publishEvent(ctx context.Context, event *eventsv1.Event) error {
+    _, err := busv1.NewEventBusServiceClient(p.Conn()).Publish(ctx, event)
+    return err
+}
+
+// AttachOutbox makes memory publishing durable: queued events are stored in o
+// before they are sent, and its retry worker publishes over the pooled
+// connections. It must be called before the pool hands out gateways; the
+// outbox is closed together with the pool.
+func (p *Pool) AttachOutbox(o *Outbox) {
+    o.publish = p.publishEvent
+    p.outbox = o
+    p.queue.outbox = o
+    p.wg.Add(1)
+    go func() {
+        defer p.wg.Done()
+        o.run(p.ctx)
+    }()
+}
+
+// AttachSubscriber starts s on the pooled connections; it runs until the
+// pool is closed, which also closes its listeners.
+func (p *Pool) AttachSubscriber(s *Subscriber) {
+    s.client = func() busv1.EventBusServiceClient {
+        return busv1.NewEventBusServiceClient(p.Conn())
+    }
+    p.sub = s
+    p.wg.Add(1)
+    go func() {
+        defer p.wg.Done()
+        s.run(p.ctx)
+    }()
+}
+
+// Subscriber returns the attached subscriber, or nil.
+func (p *Pool) Subscriber() *Subscriber {
+    return p.sub
+}
+
+// PublishStats reports the memory publish queue metrics.
+func (p *Pool) PublishStats() PublishStats {
+    return p.queue.Stats()
+}
+
+// Outbox returns the attached outbox, or nil.
+func (p *Pool) Outbox() *Outbox {
+    return p.outbox
+}
+
+// States snapshots the connectivity state of every pooled connection.
+func (p *Pool) States() []ConnState {
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+    out := make([]ConnState, len(p.states))
+    for i, s := range p.states {
+        out[i] = ConnState{Index: i, State: s.String()}
+    }
+    return out
+}
+
+// Close stops state tracking and closes every pooled connection.
+func (p *Pool) Close() error {
+    p.mu.Lock()
+    if p.closed {
+        p.mu.Unlock()
+        return nil
+    }
+    p.closed = true
+    p.mu.Unlock()
+
+    // Drain queued memory events while the connections are still up
+    var errs []error
+    if err := p.queue.Close(); err != nil {
+        errs = append(errs, err)
+    }
+    p.cancel()
+    for _, conn := range p.conns {
+        if err := conn.Close(); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    p.wg.Wait()
+    if p.outbox != nil {
+        if err := p.outbox.Close(); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    return errors.Join(errs...)
+}
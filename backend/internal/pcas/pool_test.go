@@ -0,0 +1,235 @@
+package pcas
+
+import (
+    "context"
+    "net"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/connectivity"
+    "google.golang.org/grpc/credentials/insecure"
+)
+
+// publishServer records the ids of published events, taking delay for each.
+type publishServer struct {
+    busv1.UnimplementedEventBusServiceServer
+    delay time.Duration
+
+    mu  sync.Mutex
+    ids []string
+}
+
+func (s *publishServer) Publish(ctx context.Context, ev *eventsv1.Event) (*busv1.PublishResponse, error) {
+    select {
+    case <-time.After(s.delay):
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+    s.mu.Lock()
+    s.ids = append(s.ids, ev.Id)
+    s.mu.Unlock()
+    return &busv1.PublishResponse{}, nil
+}
+
+func (s *publishServer) published() []string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    ids := append([]string(nil), s.ids...)
+    sort.Strings(ids)
+    return ids
+}
+
+// startPublishServer serves s on a local port and returns its address.
+func startPublishServer(t *testing.T, s *publishServer) string {
+    t.Helper()
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := grpc.NewServer()
+    busv1.RegisterEventBusServiceServer(srv, s)
+    go func() { _ = srv.Serve(lis) }()
+    t.Cleanup(srv.Stop)
+    return lis.Addr().String()
+}
+
+func TestPoolWarmup(t *testing.T) {
+    addr := startPublishServer(t, &publishServer{})
+    p, err := NewPool(addr, PoolOptions{Size: 3})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer p.Close()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := p.Warmup(ctx); err != nil {
+        t.Fatal(err)
+    }
+    // Warmup connects every connection, not just the first ready one
+    waitFor(t, "every connection READY", func() bool {
+        for _, s := range p.States() {
+            if s.State != connectivity.Ready.String() {
+                return false
+            }
+        }
+        return true
+    })
+    if states := p.States(); len(states) != 3 || states[2].Index != 2 {
+        t.Fatalf("states = %+v", states)
+    }
+}
+
+func TestPoolWarmupTimeout(t *testing.T) {
+    // nothing listens on a port that was just released
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := lis.Addr().String()
+    lis.Close()
+
+    p, err := NewPool(addr, PoolOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer p.Close()
+    ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+    defer cancel()
+    if err := p.Warmup(ctx); err == nil {
+        t.Fatal("Warmup succeeded without a server")
+    }
+    waitFor(t, "a failed connection state", func() bool {
+        s := p.States()[0].State
+        return s == connectivity.TransientFailure.String() || s == connectivity.Connecting.String()
+    })
+}
+
+func TestPoolStateTracking(t *testing.T) {
+    s := &publishServer{}
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := grpc.NewServer()
+    busv1.RegisterEventBusServiceServer(srv, s)
+    go func() { _ = srv.Serve(lis) }()
+
+    p, err := NewPool(lis.Addr().String(), PoolOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer p.Close()
+    if got := p.States()[0].State; got != connectivity.Idle.String() {
+        t.Fatalf("state before warm-up = %s, want IDLE", got)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := p.Warmup(ctx); err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "READY", func() bool { return p.States()[0].State == connectivity.Ready.String() })
+
+    // the watcher follows the connection when PCAS goes away
+    srv.Stop()
+    waitFor(t, "leaving READY", func() bool { return p.States()[0].State != connectivity.Ready.String() })
+}
+
+func TestPoolConnRoundRobin(t *testing.T) {
+    var conns []*grpc.ClientConn
+    for i := 0; i < 3; i++ {
+        // never connected: the test only looks at which one is picked
+        conn, err := grpc.NewClient("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        conns = append(conns, conn)
+    }
+    index := func(c *grpc.ClientConn) int {
+        for i, conn := range conns {
+            if conn == c {
+                return i
+            }
+        }
+        return -1
+    }
+    pick := func(p *Pool, n int) []int {
+        var got []int
+        for i := 0; i < n; i++ {
+            got = append(got, index(p.Conn()))
+        }
+        return got
+    }
+
+    cases := []struct {
+        name   string
+        states []connectivity.State
+        want   []int
+    }{
+        {"all ready", []connectivity.State{connectivity.Ready, connectivity.Ready, connectivity.Ready}, []int{1, 2, 0, 1, 2, 0}},
+        {"skips connections that are not ready", []connectivity.State{connectivity.Ready, connectivity.TransientFailure, connectivity.Ready}, []int{2, 2, 0, 2, 2, 0}},
+        {"one ready", []connectivity.State{connectivity.Connecting, connectivity.Ready, connectivity.Idle}, []int{1, 1, 1, 1}},
+        // without a ready connection it keeps rotating so all get tried
+        {"none ready", []connectivity.State{connectivity.Idle, connectivity.TransientFailure, connectivity.Connecting}, []int{1, 2, 0, 1}},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            p := &Pool{conns: conns, states: tc.states}
+            got := pick(p, len(tc.want))
+            for i := range got {
+                if got[i] != tc.want[i] {
+                    t.Fatalf("picked %v, want %v", got, tc.want)
+                }
+            }
+        })
+    }
+}
+
+func TestPoolCloseDrainsPublishQueue(t *testing.T) {
+    s := &publishServer{delay: 20 * time.Millisecond}
+    addr := startPublishServer(t, s)
+    p, err := NewPool(addr, PoolOptions{Publish: PublishOptions{Concurrency: 1, DrainTimeout: 5 * time.Second}})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ids := []string{"ev-0", "ev-1", "ev-2", "ev-3", "ev-4"}
+    var mu sync.Mutex
+    var outcomes []error
+    for _, ev := range testEvents(ids...) {
+        err := p.queue.Enqueue(ev, func(err error) {
+            mu.Lock()
+            outcomes = append(outcomes, err)
+            mu.Unlock()
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := p.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if got := s.published(); len(got) != len(ids) {
+        t.Fatalf("published %v before close returned, want %v", got, ids)
+    }
+    mu.Lock()
+    defer mu.Unlock()
+    if len(outcomes) != len(ids) {
+        t.Fatalf("%d outcomes reported, want %d", len(outcomes), len(ids))
+    }
+    for _, err := range outcomes {
+        if err != nil {
+            t.Fatalf("publish failed: %v", err)
+        }
+    }
+    // a second Close is a no-op
+    if err := p.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
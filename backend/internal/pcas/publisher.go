@@ -2,6 +2,8 @@ package pcas
 
 import (
     "context"
+    "fmt"
+    "strings"
 
     "github.com/google/uuid"
     busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
@@ -22,18 +24,126 @@ func NewPublisher(conn *grpc.ClientConn) *Publisher {
 	}
 }
 
-func (p *Publisher) PublishMemory(ctx context.Context, text, userID string) error {
-    eventID := uuid.New().String()
-    event := &eventsv1.Event{
-        Id:          eventID,
-        Specversion: "1.0",
-        Type:        "pcas.memory.create.v1",
-        Source:      "/d-app/dreamscribe",
-        Subject:     text,
-        Time:        timestamppb.Now(),
-        UserId:      userID,
+// Memory is one distilled transcript segment to be stored by PCAS.
+type Memory struct {
+    Text string
+    // Identity and grouping: all memories of one lecture share SessionID and TraceID.
+    UserID    string
+    SessionID string
+    TraceID   string
+    // StreamID is the PCAS interact stream that produced the text; it doubles
+    // as the event's correlation id.
+    StreamID        string
+    SourceEventType string
+
+    Language     string
+    SegmentIndex int
+    // StartOffset/EndOffset are rune offsets within the session transcript.
+    StartOffset int
+    EndOffset   int
+    Reason      string
+
+    // Client-supplied context, copied into Attributes for search filters.
+    Course string
+    Title  string
+    Tags   []string
+    // Attributes are extra markers such as segment=trailing.
+    Attributes map[string]string
+}
+
+// attributes flattens the searchable context. Each tag is also stored as
+// "tag:<name>"="true" so a single tag can be matched with an exact filter.
+func (m Memory) attributes() map[string]string {
+    attrs := map[string]string{}
+    for k, v := range m.Attributes {
+        attrs[k] = v
+    }
+    if m.SessionID != "" {
+        attrs["session_id"] = m.SessionID
+    }
+    if m.Course != "" {
+        attrs["course"] = m.Course
+    }
+    if m.Title != "" {
+        attrs["title"] = m.Title
+    }
+    if m.Language != "" {
+        attrs["language"] = m.Language
+    }
+    if len(m.Tags) > 0 {
+        attrs["tags"] = strings.Join(m.Tags, ",")
+        for _, t := range m.Tags {
+            attrs["tag:"+t] = "true"
+        }
+    }
+    return attrs
+}
+
+// payload builds the JSON struct carried in Event.Data.
+func (m Memory) payload() (*anypb.Any, error) {
+    tags := make([]any, len(m.Tags))
+    for i, t := range m.Tags {
+        tags[i] = t
+    }
+    st, err := structpb.NewStruct(map[string]any{
+        "text":              m.Text,
+        "language":          m.Language,
+        "segment_index":     m.SegmentIndex,
+        "start_offset":      m.StartOffset,
+        "end_offset":        m.EndOffset,
+        "reason":            m.Reason,
+        "source_event_type": m.SourceEventType,
+        "stream_id":         m.StreamID,
+        "session_id":        m.SessionID,
+        "course":            m.Course,
+        "title":             m.Title,
+        "tags":              tags,
+    })
+    if err != nil {
+        return nil, err
     }
+    // Same Value-wrapped Struct shape as the admin rule payload
+    return anypb.New(structpb.NewStructValue(st))
+}
+
+// MemoryEvent builds the pcas.memory.create.v1 event for m with a fresh id.
+// The text stays in Subject for consumers that only read the envelope.
+func MemoryEvent(m Memory) (*eventsv1.Event, error) {
+    data, err := m.payload()
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode memory payload: %w", err)
+    }
+    return &eventsv1.Event{
+        Id:              uuid.New().String(),
+        Specversion:     "1.0",
+        Type:            "pcas.memory.create.v1",
+        Source:          "/d-app/dreamscribe",
+        Subject:         m.Text,
+        Time:            timestamppb.Now(),
+        UserId:          m.UserID,
+        SessionId:       m.SessionID,
+        TraceId:         m.TraceID,
+        CorrelationId:   m.StreamID,
+        Attributes:      m.attributes(),
+        Datacontenttype: "application/json",
+        Data:            data,
+    }, nil
+}
+
+// PublishMemory emits a pcas.memory.create.v1 event and returns its id.
+func (p *Publisher) PublishMemory(ctx context.Context, m Memory) (string, error) {
+    event, err := MemoryEvent(m)
+    if err != nil {
+        return "", err
+    }
+    if err := p.Publish(ctx, event); err != nil {
+        return "", err
+    }
+    return event.Id, nil
+}
 
+// Publish sends a prebuilt event as is.
+func (p *Publisher) Publish(ctx context.Context, event *eventsv1.Event) error {
     _, err := p.client.Publish(ctx, event)
     return err
 }
@@ -0,0 +1,120 @@
+package pcas
+
+import (
+    "reflect"
+    "testing"
+
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestMemoryEvent(t *testing.T) {
+    full := Memory{
+        Text:            "光合作用发生在叶绿体中。",
+        UserID:          "alice",
+        SessionID:       "s1",
+        TraceID:         "trace-1",
+        StreamID:        "pcas-7",
+        SourceEventType: "capability.streaming.transcribe.v1",
+        Language:        "zh",
+        SegmentIndex:    3,
+        StartOffset:     40,
+        EndOffset:       52,
+        Reason:          "sentence",
+        Course:          "bio",
+        Title:           "Week 3",
+        Tags:            []string{"exam", "lab"},
+        Attributes:      map[string]string{"segment": "trailing", "partial": "true"},
+    }
+    cases := []struct {
+        name    string
+        memory  Memory
+        attrs   map[string]string
+        payload map[string]any
+    }{
+        {"every field", full,
+            map[string]string{
+                "session_id": "s1",
+                "course":     "bio",
+                "title":      "Week 3",
+                "language":   "zh",
+                "tags":       "exam,lab",
+                "tag:exam":   "true",
+                "tag:lab":    "true",
+                "segment":    "trailing",
+                "partial":    "true",
+            },
+            map[string]any{
+                "text":              "光合作用发生在叶绿体中。",
+                "language":          "zh",
+                "segment_index":     3.0,
+                "start_offset":      40.0,
+                "end_offset":        52.0,
+                "reason":            "sentence",
+                "source_event_type": "capability.streaming.transcribe.v1",
+                "stream_id":         "pcas-7",
+                "session_id":        "s1",
+                "course":            "bio",
+                "title":             "Week 3",
+                "tags":              []any{"exam", "lab"},
+            }},
+        // empty context adds no attributes, but the payload keeps its shape
+        {"text only", Memory{Text: "hello."},
+            map[string]string{},
+            map[string]any{
+                "text":              "hello.",
+                "language":          "",
+                "segment_index":     0.0,
+                "start_offset":      0.0,
+                "end_offset":        0.0,
+                "reason":            "",
+                "source_event_type": "",
+                "stream_id":         "",
+                "session_id":        "",
+                "course":            "",
+                "title":             "",
+                "tags":              []any{},
+            }},
+        // the memory's own context wins over extra markers of the same name
+        {"context overrides markers", Memory{Text: "x", SessionID: "s1", Attributes: map[string]string{"session_id": "forged", "segment": "trailing"}},
+            map[string]string{"session_id": "s1", "segment": "trailing"}, nil},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            ev, err := MemoryEvent(tc.memory)
+            if err != nil {
+                t.Fatal(err)
+            }
+            m := tc.memory
+            if ev.Id == "" || ev.Type != "pcas.memory.create.v1" || ev.Specversion != "1.0" || ev.Source != "/d-app/dreamscribe" || ev.Time == nil {
+                t.Fatalf("envelope = %v", ev)
+            }
+            if ev.Subject != m.Text || ev.UserId != m.UserID || ev.SessionId != m.SessionID || ev.TraceId != m.TraceID || ev.CorrelationId != m.StreamID {
+                t.Fatalf("subject %q user %q session %q trace %q correlation %q", ev.Subject, ev.UserId, ev.SessionId, ev.TraceId, ev.CorrelationId)
+            }
+            if !reflect.DeepEqual(ev.Attributes, tc.attrs) {
+                t.Fatalf("attributes = %v, want %v", ev.Attributes, tc.attrs)
+            }
+            if tc.payload == nil {
+                return
+            }
+            if ev.Datacontenttype != "application/json" {
+                t.Fatalf("datacontenttype = %q", ev.Datacontenttype)
+            }
+            // the payload is a Struct wrapped in a Value, like the admin rule payload
+            var v structpb.Value
+            if err := ev.Data.UnmarshalTo(&v); err != nil {
+                t.Fatal(err)
+            }
+            if got := v.GetStructValue().AsMap(); !reflect.DeepEqual(got, tc.payload) {
+                t.Fatalf("payload = %v, want %v", got, tc.payload)
+            }
+        })
+    }
+
+    // every event gets a fresh id
+    a, _ := MemoryEvent(full)
+    b, _ := MemoryEvent(full)
+    if a.Id == b.Id {
+        t.Fatal("event ids repeat")
+    }
+}
@@ -0,0 +1,251 @@
+package pcas
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+)
+
+// PublishOptions configures the asynchronous memory publisher. Zero values
+// fall back to sane defaults.
+type PublishOptions struct {
+    // QueueSize bounds the events waiting to be picked up by the worker.
+    QueueSize int
+    // MaxBatch caps how many queued events are stored in the outbox with one
+    // write.
+    MaxBatch int
+    // Concurrency is the number of Publish RPCs in flight at once.
+    Concurrency int
+    // Timeout is the deadline of each individual Publish RPC.
+    Timeout time.Duration
+    // DrainTimeout bounds how long Close waits for queued events.
+    DrainTimeout time.Duration
+}
+
+func (o PublishOptions) withDefaults() PublishOptions {
+    if o.QueueSize <= 0 {
+        o.QueueSize = 1024
+    }
+    if o.MaxBatch <= 0 {
+        o.MaxBatch = 32
+    }
+    if o.Concurrency <= 0 {
+        o.Concurrency = 8
+    }
+    if o.Timeout <= 0 {
+        o.Timeout = 5 * time.Second
+    }
+    if o.DrainTimeout <= 0 {
+        o.DrainTimeout = 10 * time.Second
+    }
+    return o
+}
+
+// ErrQueueFull reports that an event was dropped because the publish queue
+// was full and no outbox could take it.
+var ErrQueueFull = errors.New("memory publish queue is full")
+
+// PublishStats is a snapshot of the publish queue metrics.
+type PublishStats struct {
+    Capacity  int    `json:"capacity"`
+    Depth     int    `json:"depth"`
+    InFlight  int64  `json:"inFlight"`
+    Enqueued  uint64 `json:"enqueued"`
+    Batches   uint64 `json:"batches"`
+    Published uint64 `json:"published"`
+    Failed    uint64 `json:"failed"`
+    Spilled   uint64 `json:"spilled"`
+    Dropped   uint64 `json:"dropped"`
+}
+
+type publishJob struct {
+    event *eventsv1.Event
+    // done is called once with nil, ErrQueued (handed to the outbox) or the publish error.
+    done func(error)
+}
+
+// publishQueue takes memory events off the transcription path: Enqueue never
+// blocks, and a single dispatcher collects whatever is queued into batches
+// that are stored in the outbox with one write and published concurrently.
+type publishQueue struct {
+    opts    PublishOptions
+    publish func(ctx context.Context, event *eventsv1.Event) error
+    outbox  *Outbox
+
+    mu     sync.RWMutex
+    closed bool
+    jobs   chan publishJob
+    sem    chan struct{}
+    wg     sync.WaitGroup
+    done   chan struct{}
+
+    inFlight  atomic.Int64
+    enqueued  atomic.Uint64
+    batches   atomic.Uint64
+    published atomic.Uint64
+    failed    atomic.Uint64
+    spilled   atomic.Uint64
+    dropped   atomic.Uint64
+}
+
+func newPublishQueue(opts PublishOptions, publish func(context.Context, *eventsv1.Event) error) *publishQueue {
+    opts = opts.withDefaults()
+    q := &publishQueue{
+        opts:    opts,
+        publish: publish,
+        jobs:    make(chan publishJob, opts.QueueSize),
+        sem:     make(chan struct{}, opts.Concurrency),
+        done:    make(chan struct{}),
+    }
+    go q.dispatch()
+    return q
+}
+
+// Enqueue hands an event to the worker without waiting. If the queue is full
+// the event is spilled straight into the outbox (returning ErrQueued) or,
+// without one, dropped (ErrQueueFull); done is not called in either case.
+func (q *publishQueue) Enqueue(event *eventsv1.Event, done func(error)) error {
+    q.mu.RLock()
+    if !q.closed {
+        select {
+        case q.jobs <- publishJob{event: event, done: done}:
+            q.mu.RUnlock()
+            q.enqueued.Add(1)
+            return nil
+        default:
+        }
+    }
+    q.mu.RUnlock()
+
+    if q.outbox != nil {
+        if _, err := q.outbox.store([]*eventsv1.Event{event}, false); err == nil {
+            q.spilled.Add(1)
+            return ErrQueued
+        }
+    }
+    q.dropped.Add(1)
+    return ErrQueueFull
+}
+
+// dispatch collects batches until the queue is closed and drained.
+func (q *publishQueue) dispatch() {
+    defer close(q.done)
+    for {
+        job, ok := <-q.jobs
+        if !ok {
+            break
+        }
+        batch := []publishJob{job}
+    collect:
+        for len(batch) < q.opts.MaxBatch {
+            select {
+            case job, ok := <-q.jobs:
+                if !ok {
+                    break collect
+                }
+                batch = append(batch, job)
+            default:
+                break collect
+            }
+        }
+        q.send(batch)
+    }
+    q.wg.Wait()
+}
+
+// send stores the batch in the outbox and starts one Publish per event,
+// bounded by Concurrency. Every event is published on its own; batching only
+// saves outbox writes.
+func (q *publishQueue) send(jobs []publishJob) {
+    q.batches.Add(1)
+    entries := make([]*outboxEntry, len(jobs))
+    if q.outbox != nil {
+        events := make([]*eventsv1.Event, len(jobs))
+        for i, j := range jobs {
+            events[i] = j.event
+        }
+        claimed, err := q.outbox.store(events, true)
+        switch {
+        case err != nil:
+            log.Printf("[publisher] %v; publishing %d event(s) without outbox", err, len(jobs))
+        case claimed == nil:
+            // The outbox worker is retrying older events; these follow in order
+            for _, j := range jobs {
+                j.done(ErrQueued)
+            }
+            return
+        default:
+            entries = claimed
+        }
+    }
+
+    for i, j := range jobs {
+        q.sem <- struct{}{}
+        q.wg.Add(1)
+        q.inFlight.Add(1)
+        go func(j publishJob, e *outboxEntry) {
+            defer q.wg.Done()
+            ctx, cancel := context.WithTimeout(context.Background(), q.opts.Timeout)
+            err := q.publish(ctx, j.event)
+            cancel()
+            q.inFlight.Add(-1)
+            <-q.sem
+
+            if err == nil {
+                q.published.Add(1)
+                if e != nil {
+                    q.outbox.ack(e)
+                }
+                j.done(nil)
+                return
+            }
+            q.failed.Add(1)
+            if e != nil {
+                q.outbox.release(e, err)
+                err = fmt.Errorf("%w: %v", ErrQueued, err)
+            }
+            j.done(err)
+        }(j, entries[i])
+    }
+}
+
+// Stats snapshots the queue metrics.
+func (q *publishQueue) Stats() PublishStats {
+    return PublishStats{
+        Capacity:  q.opts.QueueSize,
+        Depth:     len(q.jobs),
+        InFlight:  q.inFlight.Load(),
+        Enqueued:  q.enqueued.Load(),
+        Batches:   q.batches.Load(),
+        Published: q.published.Load(),
+        Failed:    q.failed.Load(),
+        Spilled:   q.spilled.Load(),
+        Dropped:   q.dropped.Load(),
+    }
+}
+
+// Close stops accepting events and waits up to DrainTimeout for queued and
+// in-flight ones. Anything stored in the outbox is retried after a restart.
+func (q *publishQueue) Close() error {
+    q.mu.Lock()
+    if q.closed {
+        q.mu.Unlock()
+        return nil
+    }
+    q.closed = true
+    close(q.jobs)
+    q.mu.Unlock()
+
+    select {
+    case <-q.done:
+        return nil
+    case <-time.After(q.opts.DrainTimeout):
+        return fmt.Errorf("publish queue drain timed out with %d queued, %d in flight", len(q.jobs), q.inFlight.Load())
+    }
+}
@@ -0,0 +1,249 @@
+package pcas
+
+import (
+    "context"
+    "errors"
+    "reflect"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+)
+
+// fakePublish records published event ids. Publishing blocks until gate is
+// closed, so tests can hold the queue's workers busy.
+type fakePublish struct {
+    gate    chan struct{}
+    started chan string
+
+    mu  sync.Mutex
+    ids []string
+}
+
+func newFakePublish() *fakePublish {
+    return &fakePublish{gate: make(chan struct{}), started: make(chan string, 64)}
+}
+
+func (f *fakePublish) publish(ctx context.Context, ev *eventsv1.Event) error {
+    f.started <- ev.Id
+    select {
+    case <-f.gate:
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+    f.mu.Lock()
+    f.ids = append(f.ids, ev.Id)
+    f.mu.Unlock()
+    return nil
+}
+
+func (f *fakePublish) published() []string {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    ids := append([]string(nil), f.ids...)
+    sort.Strings(ids)
+    return ids
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(time.Second)
+    for !cond() {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s", what)
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+// busyQueue returns a queue with one worker that is publishing ev-0 and a
+// dispatcher that holds ev-1 waiting for that worker, so further events stay
+// in the queue until f.gate is closed.
+func busyQueue(t *testing.T, opts PublishOptions, f *fakePublish) *publishQueue {
+    t.Helper()
+    opts.Concurrency = 1
+    q := newPublishQueue(opts, f.publish)
+    occupy(t, q, f)
+    return q
+}
+
+// occupy enqueues ev-0 and, once it is being published, ev-1, and waits until
+// the dispatcher took ev-1 into a batch of its own.
+func occupy(t *testing.T, q *publishQueue, f *fakePublish) {
+    t.Helper()
+    if err := q.Enqueue(testEvents("ev-0")[0], func(error) {}); err != nil {
+        t.Fatal(err)
+    }
+    <-f.started
+    if err := q.Enqueue(testEvents("ev-1")[0], func(error) {}); err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "the dispatcher to pick up ev-1", func() bool { return q.Stats().Batches == 2 })
+}
+
+func TestPublishQueueDefaults(t *testing.T) {
+    q := newPublishQueue(PublishOptions{}, newFakePublish().publish)
+    defer q.Close()
+    want := PublishOptions{QueueSize: 1024, MaxBatch: 32, Concurrency: 8, Timeout: 5 * time.Second, DrainTimeout: 10 * time.Second}
+    if q.opts != want {
+        t.Fatalf("opts = %+v, want %+v", q.opts, want)
+    }
+    if cap(q.jobs) != 1024 || cap(q.sem) != 8 || q.Stats().Capacity != 1024 {
+        t.Fatalf("jobs %d, sem %d, capacity %d", cap(q.jobs), cap(q.sem), q.Stats().Capacity)
+    }
+
+    set := PublishOptions{QueueSize: 4, MaxBatch: 2, Concurrency: 1, Timeout: time.Second, DrainTimeout: time.Second}
+    q2 := newPublishQueue(set, newFakePublish().publish)
+    defer q2.Close()
+    if q2.opts != set {
+        t.Fatalf("opts = %+v, want %+v", q2.opts, set)
+    }
+}
+
+func TestPublishQueueBatches(t *testing.T) {
+    f := newFakePublish()
+    q := busyQueue(t, PublishOptions{QueueSize: 16, MaxBatch: 2}, f)
+
+    var mu sync.Mutex
+    var results []error
+    for _, ev := range testEvents("ev-2", "ev-3", "ev-4", "ev-5", "ev-6") {
+        err := q.Enqueue(ev, func(err error) {
+            mu.Lock()
+            results = append(results, err)
+            mu.Unlock()
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    if depth := q.Stats().Depth; depth != 5 {
+        t.Fatalf("depth = %d, want 5", depth)
+    }
+    close(f.gate)
+    if err := q.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    // ev-0 and ev-1 went alone; the five queued events in batches of 2, 2 and 1
+    st := q.Stats()
+    if st.Batches != 5 || st.Enqueued != 7 || st.Published != 7 || st.InFlight != 0 || st.Depth != 0 {
+        t.Fatalf("stats = %+v", st)
+    }
+    if got, want := f.published(), []string{"ev-0", "ev-1", "ev-2", "ev-3", "ev-4", "ev-5", "ev-6"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("published %v, want %v", got, want)
+    }
+    if len(results) != 5 {
+        t.Fatalf("done called %d times, want 5", len(results))
+    }
+    for _, err := range results {
+        if err != nil {
+            t.Fatalf("done(%v), want nil", err)
+        }
+    }
+}
+
+func TestPublishQueueOverflowDrops(t *testing.T) {
+    f := newFakePublish()
+    q := busyQueue(t, PublishOptions{QueueSize: 2, MaxBatch: 1}, f)
+    defer close(f.gate)
+
+    for _, ev := range testEvents("ev-2", "ev-3") {
+        if err := q.Enqueue(ev, func(error) {}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    called := false
+    if err := q.Enqueue(testEvents("ev-4")[0], func(error) { called = true }); !errors.Is(err, ErrQueueFull) {
+        t.Fatalf("Enqueue on a full queue = %v, want ErrQueueFull", err)
+    }
+    if st := q.Stats(); st.Dropped != 1 || st.Spilled != 0 || st.Enqueued != 4 {
+        t.Fatalf("stats = %+v", st)
+    }
+    if called {
+        t.Fatal("done called for a dropped event")
+    }
+}
+
+func TestPublishQueueOverflowSpillsToOutbox(t *testing.T) {
+    f := newFakePublish()
+    o := openTestOutbox(t, OutboxOptions{Dir: t.TempDir()})
+    q := newPublishQueue(PublishOptions{QueueSize: 2, MaxBatch: 1, Concurrency: 1}, f.publish)
+    q.outbox = o
+    occupy(t, q, f)
+    waitFor(t, "ev-1 to be stored", func() bool { return len(pendingIDs(o)) == 2 })
+    for _, ev := range testEvents("ev-2", "ev-3") {
+        if err := q.Enqueue(ev, func(error) {}); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if err := q.Enqueue(testEvents("ev-4")[0], func(error) {}); !errors.Is(err, ErrQueued) {
+        t.Fatalf("Enqueue on a full queue = %v, want ErrQueued", err)
+    }
+    if st := q.Stats(); st.Spilled != 1 || st.Dropped != 0 {
+        t.Fatalf("stats = %+v", st)
+    }
+    // ev-0 and ev-1 were claimed by the queue; the spilled event waits for the
+    // outbox worker
+    if got, want := pendingIDs(o), []string{"ev-0", "ev-1", "ev-4"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("pending = %v, want %v", got, want)
+    }
+
+    close(f.gate)
+    if err := q.Close(); err != nil {
+        t.Fatal(err)
+    }
+    // ev-2 and ev-3 queued behind the backlog and were left to the outbox too
+    if got, want := f.published(), []string{"ev-0", "ev-1"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("published %v, want %v", got, want)
+    }
+    if got, want := pendingIDs(o), []string{"ev-4", "ev-2", "ev-3"}; !reflect.DeepEqual(got, want) {
+        t.Fatalf("pending after close = %v, want %v", got, want)
+    }
+}
+
+func TestPublishQueueCloseDrains(t *testing.T) {
+    f := newFakePublish()
+    q := busyQueue(t, PublishOptions{QueueSize: 8, DrainTimeout: 5 * time.Second}, f)
+    for _, ev := range testEvents("ev-2", "ev-3") {
+        if err := q.Enqueue(ev, func(error) {}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    time.AfterFunc(20*time.Millisecond, func() { close(f.gate) })
+    if err := q.Close(); err != nil {
+        t.Fatalf("Close = %v", err)
+    }
+    if got := f.published(); len(got) != 4 {
+        t.Fatalf("published %v before Close returned, want all 4", got)
+    }
+
+    // closed: new events no longer enter the queue
+    if err := q.Enqueue(testEvents("ev-5")[0], func(error) {}); !errors.Is(err, ErrQueueFull) {
+        t.Fatalf("Enqueue after Close = %v, want ErrQueueFull", err)
+    }
+    if err := q.Close(); err != nil {
+        t.Fatalf("second Close = %v", err)
+    }
+}
+
+func TestPublishQueueCloseTimeout(t *testing.T) {
+    f := newFakePublish()
+    q := busyQueue(t, PublishOptions{QueueSize: 8, DrainTimeout: 50 * time.Millisecond}, f)
+    defer close(f.gate)
+
+    start := time.Now()
+    err := q.Close()
+    if err == nil {
+        t.Fatal("Close returned nil with a publish still blocked")
+    }
+    if d := time.Since(start); d < 50*time.Millisecond || d > time.Second {
+        t.Fatalf("Close returned after %v, want about DrainTimeout", d)
+    }
+    if got := f.published(); len(got) != 0 {
+        t.Fatalf("published %v", got)
+    }
+}
@@ -0,0 +1,135 @@
+package pcas
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// ReconnectPolicy controls how ProcessStream re-establishes a dropped PCAS stream.
+type ReconnectPolicy struct {
+    MaxAttempts    int
+    InitialBackoff time.Duration
+    MaxBackoff     time.Duration
+    // BufferChunks bounds the audio held while reconnecting; oldest chunks are dropped first.
+    BufferChunks int
+}
+
+// DefaultReconnectPolicy is used by gateways created without explicit options.
+var DefaultReconnectPolicy = ReconnectPolicy{
+    MaxAttempts:    5,
+    InitialBackoff: 500 * time.Millisecond,
+    MaxBackoff:     8 * time.Second,
+    BufferChunks:   512,
+}
+
+func (p ReconnectPolicy) withDefaults() ReconnectPolicy {
+    if p.MaxAttempts <= 0 {
+        p.MaxAttempts = DefaultReconnectPolicy.MaxAttempts
+    }
+    if p.InitialBackoff <= 0 {
+        p.InitialBackoff = DefaultReconnectPolicy.InitialBackoff
+    }
+    if p.MaxBackoff <= 0 {
+        p.MaxBackoff = DefaultReconnectPolicy.MaxBackoff
+    }
+    if p.BufferChunks <= 0 {
+        p.BufferChunks = DefaultReconnectPolicy.BufferChunks
+    }
+    return p
+}
+
+// backoff returns the delay before the given (1-based) reconnect attempt.
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+    d := p.InitialBackoff
+    for i := 1; i < attempt; i++ {
+        d *= 2
+        if d >= p.MaxBackoff {
+            return p.MaxBackoff
+        }
+    }
+    return d
+}
+
+// audioBuffer decouples the client audio channel from the PCAS send loop so that
+// audio keeps being accepted while the stream is being re-established.
+type audioBuffer struct {
+    mu      sync.Mutex
+    chunks  [][]byte
+    limit   int
+    closed  bool
+    dropped int
+    notify  chan struct{}
+}
+
+func newAudioBuffer(limit int) *audioBuffer {
+    return &audioBuffer{limit: limit, notify: make(chan struct{}, 1)}
+}
+
+func (b *audioBuffer) signal() {
+    select {
+    case b.notify <- struct{}{}:
+    default:
+    }
+}
+
+// push appends a chunk, dropping the oldest one when the buffer is full.
+func (b *audioBuffer) push(chunk []byte) {
+    b.mu.Lock()
+    if len(b.chunks) >= b.limit {
+        b.chunks = b.chunks[1:]
+        b.dropped++
+    }
+    b.chunks = append(b.chunks, chunk)
+    b.mu.Unlock()
+    b.signal()
+}
+
+// unshift puts back a chunk whose send failed so the next stream delivers it first.
+func (b *audioBuffer) unshift(chunk []byte) {
+    b.mu.Lock()
+    b.chunks = append([][]byte{chunk}, b.chunks...)
+    b.mu.Unlock()
+    b.signal()
+}
+
+// closeInput marks the end of client audio; next reports ok=false once drained.
+func (b *audioBuffer) closeInput() {
+    b.mu.Lock()
+    b.closed = true
+    b.mu.Unlock()
+    b.signal()
+}
+
+// next blocks until a chunk is available, the input is closed and drained
+// (ok=false), or ctx is done.
+func (b *audioBuffer) next(ctx context.Context) (chunk []byte, ok bool, err error) {
+    for {
+        b.mu.Lock()
+        if len(b.chunks) > 0 {
+            chunk = b.chunks[0]
+            b.chunks = b.chunks[1:]
+            b.mu.Unlock()
+            return chunk, true, nil
+        }
+        closed := b.closed
+        b.mu.Unlock()
+        if closed {
+            return nil, false, nil
+        }
+        select {
+        case <-b.notify:
+        case <-ctx.Done():
+            return nil, false, ctx.Err()
+        }
+    }
+}
+
+// takeDropped returns and resets the number of chunks dropped due to overflow.
+func (b *audioBuffer) takeDropped() int {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    n := b.dropped
+    b.dropped = 0
+    return n
+}
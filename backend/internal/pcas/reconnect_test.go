@@ -0,0 +1,154 @@
+package pcas
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestReconnectPolicyBackoff(t *testing.T) {
+    p := ReconnectPolicy{InitialBackoff: 500 * time.Millisecond, MaxBackoff: 3 * time.Second}
+    cases := []struct {
+        attempt int
+        want    time.Duration
+    }{
+        {1, 500 * time.Millisecond},
+        {2, time.Second},
+        {3, 2 * time.Second},
+        {4, 3 * time.Second}, // 4s capped
+        {10, 3 * time.Second},
+        {100, 3 * time.Second}, // no overflow on long outages
+    }
+    for _, tc := range cases {
+        if got := p.backoff(tc.attempt); got != tc.want {
+            t.Errorf("backoff(%d) = %v, want %v", tc.attempt, got, tc.want)
+        }
+    }
+
+    // a cap below the initial delay still applies from the second attempt
+    low := ReconnectPolicy{InitialBackoff: time.Second, MaxBackoff: 1500 * time.Millisecond}
+    if got := low.backoff(2); got != 1500*time.Millisecond {
+        t.Errorf("backoff(2) = %v, want 1.5s", got)
+    }
+}
+
+func TestReconnectPolicyDefaults(t *testing.T) {
+    if got := (ReconnectPolicy{}).withDefaults(); got != DefaultReconnectPolicy {
+        t.Fatalf("withDefaults() = %+v, want %+v", got, DefaultReconnectPolicy)
+    }
+    set := ReconnectPolicy{MaxAttempts: 2, InitialBackoff: time.Second, MaxBackoff: time.Minute, BufferChunks: 8}
+    if got := set.withDefaults(); got != set {
+        t.Fatalf("withDefaults() = %+v, want %+v", got, set)
+    }
+}
+
+// drain reads the chunks available right now.
+func drain(t *testing.T, b *audioBuffer) []string {
+    t.Helper()
+    var got []string
+    for {
+        b.mu.Lock()
+        n := len(b.chunks)
+        b.mu.Unlock()
+        if n == 0 {
+            return got
+        }
+        chunk, ok, err := b.next(context.Background())
+        if !ok || err != nil {
+            t.Fatalf("next = %v, %v", ok, err)
+        }
+        got = append(got, string(chunk))
+    }
+}
+
+func TestAudioBufferDropsOldest(t *testing.T) {
+    b := newAudioBuffer(3)
+    for _, c := range []string{"a", "b", "c", "d", "e"} {
+        b.push([]byte(c))
+    }
+    if n := b.takeDropped(); n != 2 {
+        t.Fatalf("takeDropped() = %d, want 2", n)
+    }
+    if n := b.takeDropped(); n != 0 {
+        t.Fatalf("takeDropped() after reset = %d, want 0", n)
+    }
+    if got := drain(t, b); len(got) != 3 || got[0] != "c" || got[2] != "e" {
+        t.Fatalf("chunks = %v, want [c d e]", got)
+    }
+}
+
+func TestAudioBufferUnshift(t *testing.T) {
+    b := newAudioBuffer(4)
+    b.push([]byte("b"))
+    b.push([]byte("c"))
+    chunk, _, _ := b.next(context.Background())
+    // the send of b failed: it goes out first on the next stream
+    b.unshift(chunk)
+    b.unshift([]byte("a"))
+    if got := drain(t, b); len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+        t.Fatalf("chunks = %v, want [a b c]", got)
+    }
+}
+
+func TestAudioBufferCloseInput(t *testing.T) {
+    b := newAudioBuffer(4)
+    b.push([]byte("a"))
+    b.closeInput()
+
+    // buffered audio is still delivered after close
+    chunk, ok, err := b.next(context.Background())
+    if string(chunk) != "a" || !ok || err != nil {
+        t.Fatalf("next = %q, %v, %v; want a", chunk, ok, err)
+    }
+    if chunk, ok, err := b.next(context.Background()); chunk != nil || ok || err != nil {
+        t.Fatalf("next after drain = %q, %v, %v; want end of input", chunk, ok, err)
+    }
+}
+
+func TestAudioBufferNextWaits(t *testing.T) {
+    b := newAudioBuffer(4)
+    got := make(chan string, 1)
+    go func() {
+        chunk, _, _ := b.next(context.Background())
+        got <- string(chunk)
+    }()
+    select {
+    case c := <-got:
+        t.Fatalf("next returned %q from an empty buffer", c)
+    case <-time.After(20 * time.Millisecond):
+    }
+    b.push([]byte("a"))
+    select {
+    case c := <-got:
+        if c != "a" {
+            t.Fatalf("next = %q, want a", c)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("next did not wake on push")
+    }
+
+    done := make(chan bool, 1)
+    go func() {
+        _, ok, _ := b.next(context.Background())
+        done <- ok
+    }()
+    b.closeInput()
+    select {
+    case ok := <-done:
+        if ok {
+            t.Fatal("next reported a chunk after closeInput")
+        }
+    case <-time.After(time.Second):
+        t.Fatal("next did not wake on closeInput")
+    }
+}
+
+func TestAudioBufferNextContext(t *testing.T) {
+    b := newAudioBuffer(4)
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, ok, err := b.next(ctx); ok || !errors.Is(err, context.Canceled) {
+        t.Fatalf("next = %v, %v; want context.Canceled", ok, err)
+    }
+}
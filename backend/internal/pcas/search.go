@@ -0,0 +1,94 @@
+package pcas
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+// SearchQuery asks PCAS for the memory events most similar to Text.
+type SearchQuery struct {
+    Text   string
+    TopK   int
+    UserID string
+    // Filters must all match event attributes, e.g. course, session_id or
+    // tag:<name>="true" as set by MemoryEvent.
+    Filters map[string]string
+}
+
+// SearchHit is one event returned by Search with its similarity score (0..1).
+type SearchHit struct {
+    EventID    string            `json:"eventId"`
+    Type       string            `json:"type,omitempty"`
+    Text       string            `json:"text"`
+    UserID     string            `json:"userId,omitempty"`
+    SessionID  string            `json:"sessionId,omitempty"`
+    Time       time.Time         `json:"time"`
+    Attributes map[string]string `json:"attributes,omitempty"`
+    Score      float32           `json:"score"`
+}
+
+// eventText is the memory text of an event: Subject, or the text field of
+// its JSON payload for events that carry none. MemoryEvent wraps the payload
+// as a structpb.Value; a bare Struct is accepted too.
+func eventText(ev *eventsv1.Event) string {
+    if ev.GetSubject() != "" {
+        return ev.GetSubject()
+    }
+    data := ev.GetData()
+    if data == nil {
+        return ""
+    }
+    var st *structpb.Struct
+    switch {
+    case data.MessageIs(&structpb.Value{}):
+        var v structpb.Value
+        if err := data.UnmarshalTo(&v); err != nil {
+            return ""
+        }
+        st = v.GetStructValue()
+    case data.MessageIs(&structpb.Struct{}):
+        st = &structpb.Struct{}
+        if err := data.UnmarshalTo(st); err != nil {
+            return ""
+        }
+    }
+    return st.GetFields()["text"].GetStringValue()
+}
+
+// Search runs a semantic search over the events PCAS stored, best match first.
+func (g *Gateway) Search(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+    resp, err := g.client.Search(ctx, &busv1.SearchRequest{
+        QueryText:        q.Text,
+        TopK:             int32(q.TopK),
+        UserId:           q.UserID,
+        AttributeFilters: q.Filters,
+    })
+    if err != nil {
+        return nil, fmt.Errorf("search failed: %w", err)
+    }
+    scores := resp.GetScores()
+    hits := make([]SearchHit, 0, len(resp.GetEvents()))
+    for i, ev := range resp.GetEvents() {
+        hit := SearchHit{
+            EventID:    ev.GetId(),
+            Type:       ev.GetType(),
+            Text:       eventText(ev),
+            UserID:     ev.GetUserId(),
+            SessionID:  ev.GetSessionId(),
+            Attributes: ev.GetAttributes(),
+        }
+        if ev.GetTime() != nil {
+            hit.Time = ev.GetTime().AsTime()
+        }
+        if i < len(scores) {
+            hit.Score = scores[i]
+        }
+        hits = append(hits, hit)
+    }
+    return hits, nil
+}
@@ -0,0 +1,274 @@
+package pcas
+
+import (
+    "context"
+    "log"
+    "os"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+)
+
+// SubscriberOptions configures the background Subscribe stream. Zero values
+// fall back to defaults.
+type SubscriberOptions struct {
+    // ClientID names this backend to PCAS. Keep it stable across restarts so
+    // PCAS can tell a reconnect from a new client; defaults to
+    // dreamscribe-<hostname>.
+    ClientID       string
+    InitialBackoff time.Duration
+    MaxBackoff     time.Duration
+    // Buffer is the per-listener queue; a listener that falls behind loses
+    // the events that do not fit.
+    Buffer int
+}
+
+func (o SubscriberOptions) withDefaults() SubscriberOptions {
+    if o.ClientID == "" {
+        host, _ := os.Hostname()
+        if host == "" {
+            host = "local"
+        }
+        o.ClientID = "dreamscribe-" + host
+    }
+    if o.InitialBackoff <= 0 {
+        o.InitialBackoff = time.Second
+    }
+    if o.MaxBackoff <= 0 {
+        o.MaxBackoff = 30 * time.Second
+    }
+    if o.Buffer <= 0 {
+        o.Buffer = 64
+    }
+    return o
+}
+
+// PushEvent is an event PCAS pushed on the subscription, e.g. a hint.
+type PushEvent struct {
+    ID         string            `json:"id"`
+    Type       string            `json:"type"`
+    Text       string            `json:"text"`
+    UserID     string            `json:"userId,omitempty"`
+    SessionID  string            `json:"sessionId,omitempty"`
+    Time       time.Time         `json:"time"`
+    Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func pushEvent(ev *eventsv1.Event) PushEvent {
+    pe := PushEvent{
+        ID:         ev.GetId(),
+        Type:       ev.GetType(),
+        Text:       eventText(ev),
+        UserID:     ev.GetUserId(),
+        SessionID:  ev.GetSessionId(),
+        Attributes: ev.GetAttributes(),
+    }
+    if ev.GetTime() != nil {
+        pe.Time = ev.GetTime().AsTime()
+    } else {
+        pe.Time = time.Now()
+    }
+    return pe
+}
+
+// SubscriptionFilter selects the events of one listener. Events without a
+// user (or session) are broadcasts and match every filter.
+type SubscriptionFilter struct {
+    UserID    string
+    SessionID string
+}
+
+func (f SubscriptionFilter) match(ev PushEvent) bool {
+    if f.UserID != "" && ev.UserID != "" && ev.UserID != f.UserID {
+        return false
+    }
+    if f.SessionID != "" && ev.SessionID != "" && ev.SessionID != f.SessionID {
+        return false
+    }
+    return true
+}
+
+// Subscription is one listener of a Subscriber.
+type Subscription struct {
+    s       *Subscriber
+    filter  SubscriptionFilter
+    events  chan PushEvent
+    dropped atomic.Int64
+    once    sync.Once
+}
+
+// Events delivers the matching events; it is closed by Close or when the
+// subscriber stops.
+func (sub *Subscription) Events() <-chan PushEvent {
+    return sub.events
+}
+
+// Dropped reports how many events were lost because the listener was slow.
+func (sub *Subscription) Dropped() int64 {
+    return sub.dropped.Load()
+}
+
+// Close detaches the listener.
+func (sub *Subscription) Close() {
+    sub.s.mu.Lock()
+    delete(sub.s.listeners, sub)
+    sub.s.mu.Unlock()
+    sub.once.Do(func() { close(sub.events) })
+}
+
+// SubscriberStats reports the state of the Subscribe stream.
+type SubscriberStats struct {
+    ClientID   string `json:"clientId"`
+    Connected  bool   `json:"connected"`
+    Listeners  int    `json:"listeners"`
+    Received   int64  `json:"received"`
+    Delivered  int64  `json:"delivered"`
+    Dropped    int64  `json:"dropped"`
+    Reconnects int64  `json:"reconnects"`
+    LastError  string `json:"lastError,omitempty"`
+}
+
+// Subscriber keeps one Subscribe stream to PCAS open, re-establishing it with
+// backoff, and fans the pushed events out to filtered listeners.
+type Subscriber struct {
+    opts   SubscriberOptions
+    client func() busv1.EventBusServiceClient
+
+    mu        sync.Mutex
+    listeners map[*Subscription]struct{}
+    connected bool
+    lastError string
+    stopped   bool
+
+    received   atomic.Int64
+    delivered  atomic.Int64
+    dropped    atomic.Int64
+    reconnects atomic.Int64
+}
+
+// NewSubscriber prepares a subscriber; it starts once attached to a Pool.
+func NewSubscriber(opts SubscriberOptions) *Subscriber {
+    return &Subscriber{opts: opts.withDefaults(), listeners: make(map[*Subscription]struct{})}
+}
+
+// ClientID is the id this backend subscribes as.
+func (s *Subscriber) ClientID() string {
+    return s.opts.ClientID
+}
+
+// Subscribe adds a listener for the events matching f.
+func (s *Subscriber) Subscribe(f SubscriptionFilter) *Subscription {
+    sub := &Subscription{s: s, filter: f, events: make(chan PushEvent, s.opts.Buffer)}
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.stopped {
+        // closed through once so a later Close does not close it again
+        sub.once.Do(func() { close(sub.events) })
+        return sub
+    }
+    s.listeners[sub] = struct{}{}
+    return sub
+}
+
+// Stats snapshots the subscriber state.
+func (s *Subscriber) Stats() SubscriberStats {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return SubscriberStats{
+        ClientID:   s.opts.ClientID,
+        Connected:  s.connected,
+        Listeners:  len(s.listeners),
+        Received:   s.received.Load(),
+        Delivered:  s.delivered.Load(),
+        Dropped:    s.dropped.Load(),
+        Reconnects: s.reconnects.Load(),
+        LastError:  s.lastError,
+    }
+}
+
+// dispatch hands ev to every matching listener without blocking.
+func (s *Subscriber) dispatch(ev PushEvent) {
+    s.received.Add(1)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for sub := range s.listeners {
+        if !sub.filter.match(ev) {
+            continue
+        }
+        select {
+        case sub.events <- ev:
+            s.delivered.Add(1)
+        default:
+            sub.dropped.Add(1)
+            s.dropped.Add(1)
+        }
+    }
+}
+
+func (s *Subscriber) setConnected(ok bool, err error) {
+    s.mu.Lock()
+    s.connected = ok
+    if err != nil {
+        s.lastError = err.Error()
+    }
+    s.mu.Unlock()
+}
+
+// run keeps the stream open until ctx is done, then closes all listeners.
+func (s *Subscriber) run(ctx context.Context) {
+    defer func() {
+        s.mu.Lock()
+        s.stopped = true
+        for sub := range s.listeners {
+            delete(s.listeners, sub)
+            sub.once.Do(func() { close(sub.events) })
+        }
+        s.connected = false
+        s.mu.Unlock()
+    }()
+
+    policy := ReconnectPolicy{InitialBackoff: s.opts.InitialBackoff, MaxBackoff: s.opts.MaxBackoff}
+    attempt := 0
+    for {
+        opened := time.Now()
+        err := s.receive(ctx)
+        if ctx.Err() != nil {
+            return
+        }
+        s.setConnected(false, err)
+        // a stream that stayed up past the longest backoff was healthy, even
+        // if quiet; start over from the initial backoff
+        if time.Since(opened) >= s.opts.MaxBackoff {
+            attempt = 0
+        }
+        attempt++
+        s.reconnects.Add(1)
+        delay := policy.backoff(attempt)
+        log.Printf("[pcas-subscribe] client=%s stream lost (%v); retrying in %s", s.opts.ClientID, err, delay)
+        select {
+        case <-time.After(delay):
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// receive runs one Subscribe stream until it fails.
+func (s *Subscriber) receive(ctx context.Context) error {
+    stream, err := s.client().Subscribe(ctx, &busv1.SubscribeRequest{ClientId: s.opts.ClientID})
+    if err != nil {
+        return err
+    }
+    // connected from here on, whether or not PCAS has anything to push
+    s.setConnected(true, nil)
+    for {
+        ev, err := stream.Recv()
+        if err != nil {
+            return err
+        }
+        s.dispatch(pushEvent(ev))
+    }
+}
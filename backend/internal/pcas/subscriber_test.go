@@ -0,0 +1,75 @@
+package pcas
+
+import (
+    "context"
+    "testing"
+
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials/insecure"
+)
+
+// stoppedSubscriber returns a subscriber whose run loop already ended.
+func stoppedSubscriber(t *testing.T) *Subscriber {
+    t.Helper()
+    conn, err := grpc.NewClient("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { _ = conn.Close() })
+    s := NewSubscriber(SubscriberOptions{ClientID: "test"})
+    s.client = func() busv1.EventBusServiceClient { return busv1.NewEventBusServiceClient(conn) }
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    s.run(ctx)
+    return s
+}
+
+func TestSubscribeAfterStop(t *testing.T) {
+    s := stoppedSubscriber(t)
+
+    sub := s.Subscribe(SubscriptionFilter{UserID: "alice"})
+    if _, ok := <-sub.Events(); ok {
+        t.Fatal("events channel of a stopped subscriber is open")
+    }
+    // handleEventStream always closes its subscription; this must not panic
+    sub.Close()
+    sub.Close()
+    if n := s.Stats().Listeners; n != 0 {
+        t.Fatalf("listeners = %d, want 0", n)
+    }
+}
+
+func TestSubscriptionCloseWhileRunning(t *testing.T) {
+    s := NewSubscriber(SubscriberOptions{ClientID: "test", Buffer: 1})
+    sub := s.Subscribe(SubscriptionFilter{})
+    sub.Close()
+    sub.Close()
+    if _, ok := <-sub.Events(); ok {
+        t.Fatal("events channel open after Close")
+    }
+    // dispatching to a closed listener must not panic either
+    s.dispatch(PushEvent{ID: "ev-1"})
+}
+
+func TestSubscriberDispatchFilter(t *testing.T) {
+    s := NewSubscriber(SubscriberOptions{ClientID: "test", Buffer: 1})
+    alice := s.Subscribe(SubscriptionFilter{UserID: "alice", SessionID: "s1"})
+    defer alice.Close()
+
+    s.dispatch(PushEvent{ID: "bob", UserID: "bob"})
+    s.dispatch(PushEvent{ID: "other-session", UserID: "alice", SessionID: "s2"})
+    s.dispatch(PushEvent{ID: "broadcast"})
+    s.dispatch(PushEvent{ID: "overflow", UserID: "alice"})
+
+    if ev := <-alice.Events(); ev.ID != "broadcast" {
+        t.Fatalf("got %s, want broadcast", ev.ID)
+    }
+    if alice.Dropped() != 1 {
+        t.Fatalf("dropped = %d, want 1", alice.Dropped())
+    }
+    st := s.Stats()
+    if st.Received != 4 || st.Delivered != 1 || st.Dropped != 1 {
+        t.Fatalf("stats = %+v", st)
+    }
+}
@@ -0,0 +1,70 @@
+package pcas
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "os"
+
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/grpc/credentials/insecure"
+)
+
+// TLSOptions configures transport security for the PCAS gRPC link.
+// With Enabled=false the connection is plaintext, as before, and none of the
+// other fields may be set.
+type TLSOptions struct {
+    Enabled bool
+    // CAFile is a PEM bundle used to verify the server; empty means system roots.
+    CAFile string
+    // CertFile/KeyFile hold the client certificate presented for mutual TLS.
+    CertFile string
+    KeyFile  string
+    // ServerName overrides the name checked against the server certificate.
+    ServerName string
+    // InsecureSkipVerify disables server verification. Development only.
+    InsecureSkipVerify bool
+}
+
+// transportCredentials builds gRPC transport credentials from the options.
+func transportCredentials(opts TLSOptions) (credentials.TransportCredentials, error) {
+    if !opts.Enabled {
+        // a CA or client certificate without enabled would silently fall
+        // back to plaintext
+        if opts.CAFile != "" || opts.CertFile != "" || opts.KeyFile != "" || opts.ServerName != "" || opts.InsecureSkipVerify {
+            return nil, fmt.Errorf("PCAS TLS settings are set but pcas.tls.enabled is false")
+        }
+        return insecure.NewCredentials(), nil
+    }
+
+    tlsCfg := &tls.Config{
+        MinVersion:         tls.VersionTLS12,
+        ServerName:         opts.ServerName,
+        InsecureSkipVerify: opts.InsecureSkipVerify,
+    }
+
+    if opts.CAFile != "" {
+        pem, err := os.ReadFile(opts.CAFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read PCAS CA bundle: %w", err)
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("no certificates found in PCAS CA bundle %s", opts.CAFile)
+        }
+        tlsCfg.RootCAs = pool
+    }
+
+    if opts.CertFile != "" || opts.KeyFile != "" {
+        if opts.CertFile == "" || opts.KeyFile == "" {
+            return nil, fmt.Errorf("PCAS client certificate requires both certFile and keyFile")
+        }
+        cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to load PCAS client certificate: %w", err)
+        }
+        tlsCfg.Certificates = []tls.Certificate{cert}
+    }
+
+    return credentials.NewTLS(tlsCfg), nil
+}
@@ -0,0 +1,324 @@
+package pcas
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "fmt"
+    "math/big"
+    "net"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    busv1 "github.com/pcas/dreams-cli/backend/gen/pcas/bus/v1"
+    eventsv1 "github.com/pcas/dreams-cli/backend/gen/pcas/events/v1"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials"
+)
+
+// testPKI is a throwaway CA with a server and a client certificate, written
+// as PEM files into a temp dir. The named server certificate is valid only
+// for pcas.internal, not for the address the tests dial.
+type testPKI struct {
+    caFile, serverCert, serverKey, clientCert, clientKey string
+    namedCert, namedKey                                 string
+}
+
+func newTestPKI(t *testing.T) testPKI {
+    t.Helper()
+    dir := t.TempDir()
+
+    caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    caTmpl := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "test-ca"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IsCA:                  true,
+        KeyUsage:              x509.KeyUsageCertSign,
+        BasicConstraintsValid: true,
+    }
+    caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    caCert, err := x509.ParseCertificate(caDER)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    issue := func(name string, serial int64, usage x509.ExtKeyUsage, dnsNames []string, ips []net.IP) (string, string) {
+        key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+        if err != nil {
+            t.Fatal(err)
+        }
+        tmpl := &x509.Certificate{
+            SerialNumber: big.NewInt(serial),
+            Subject:      pkix.Name{CommonName: name},
+            NotBefore:    time.Now().Add(-time.Hour),
+            NotAfter:     time.Now().Add(time.Hour),
+            KeyUsage:     x509.KeyUsageDigitalSignature,
+            ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+            DNSNames:     dnsNames,
+            IPAddresses:  ips,
+        }
+        der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+        if err != nil {
+            t.Fatal(err)
+        }
+        keyDER, err := x509.MarshalECPrivateKey(key)
+        if err != nil {
+            t.Fatal(err)
+        }
+        certFile := writePEM(t, dir, name+".crt", "CERTIFICATE", der)
+        keyFile := writePEM(t, dir, name+".key", "EC PRIVATE KEY", keyDER)
+        return certFile, keyFile
+    }
+
+    p := testPKI{caFile: writePEM(t, dir, "ca.crt", "CERTIFICATE", caDER)}
+    local, loopback := []string{"localhost"}, []net.IP{net.ParseIP("127.0.0.1")}
+    p.serverCert, p.serverKey = issue("server", 2, x509.ExtKeyUsageServerAuth, local, loopback)
+    p.clientCert, p.clientKey = issue("client", 3, x509.ExtKeyUsageClientAuth, local, loopback)
+    p.namedCert, p.namedKey = issue("named", 4, x509.ExtKeyUsageServerAuth, []string{"pcas.internal"}, nil)
+    return p
+}
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+    t.Helper()
+    path := filepath.Join(dir, name)
+    if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+type tlsSearchServer struct {
+    busv1.UnimplementedEventBusServiceServer
+}
+
+func (tlsSearchServer) Search(context.Context, *busv1.SearchRequest) (*busv1.SearchResponse, error) {
+    return &busv1.SearchResponse{Events: []*eventsv1.Event{{Id: "ev-1", Subject: "hello"}}, Scores: []float32{0.9}}, nil
+}
+
+// startMTLSServer runs an EventBusService that requires a client certificate
+// signed by the test CA.
+func startMTLSServer(t *testing.T, p testPKI) string {
+    t.Helper()
+    return startTLSServer(t, p, p.serverCert, p.serverKey, tls.RequireAndVerifyClientCert)
+}
+
+// startTLSServer runs an EventBusService presenting certFile with the given
+// client certificate policy.
+func startTLSServer(t *testing.T, p testPKI, certFile, keyFile string, clientAuth tls.ClientAuthType) string {
+    t.Helper()
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    caPEM, err := os.ReadFile(p.caFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    clientCAs := x509.NewCertPool()
+    clientCAs.AppendCertsFromPEM(caPEM)
+    creds := credentials.NewTLS(&tls.Config{
+        Certificates: []tls.Certificate{cert},
+        ClientCAs:    clientCAs,
+        ClientAuth:   clientAuth,
+        MinVersion:   tls.VersionTLS12,
+    })
+
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := grpc.NewServer(grpc.Creds(creds))
+    busv1.RegisterEventBusServiceServer(srv, tlsSearchServer{})
+    go func() { _ = srv.Serve(lis) }()
+    t.Cleanup(srv.Stop)
+    return lis.Addr().String()
+}
+
+func searchOnce(g *Gateway) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    hits, err := g.Search(ctx, SearchQuery{Text: "hello", TopK: 1})
+    if err == nil && (len(hits) != 1 || hits[0].EventID != "ev-1") {
+        return fmt.Errorf("unexpected hits %+v", hits)
+    }
+    return err
+}
+
+func TestMutualTLS(t *testing.T) {
+    p := newTestPKI(t)
+    addr := startMTLSServer(t, p)
+
+    withCert := TLSOptions{Enabled: true, CAFile: p.caFile, CertFile: p.clientCert, KeyFile: p.clientKey}
+    withoutCert := TLSOptions{Enabled: true, CAFile: p.caFile}
+
+    t.Run("pool with client cert", func(t *testing.T) {
+        pool, err := NewPool(addr, PoolOptions{TLS: withCert})
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer pool.Close()
+        if err := searchOnce(pool.Gateway()); err != nil {
+            t.Fatalf("search over mTLS failed: %v", err)
+        }
+    })
+
+    t.Run("gateway with client cert", func(t *testing.T) {
+        g, err := NewGateway(addr, withCert)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer g.Close()
+        if err := searchOnce(g); err != nil {
+            t.Fatalf("search over mTLS failed: %v", err)
+        }
+    })
+
+    t.Run("pool without client cert", func(t *testing.T) {
+        pool, err := NewPool(addr, PoolOptions{TLS: withoutCert})
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer pool.Close()
+        if err := searchOnce(pool.Gateway()); err == nil {
+            t.Fatal("search succeeded without a client certificate")
+        }
+    })
+
+    t.Run("gateway without client cert", func(t *testing.T) {
+        g, err := NewGateway(addr, withoutCert)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer g.Close()
+        if err := searchOnce(g); err == nil {
+            t.Fatal("search succeeded without a client certificate")
+        }
+    })
+
+    t.Run("unknown CA", func(t *testing.T) {
+        other := newTestPKI(t)
+        g, err := NewGateway(addr, TLSOptions{Enabled: true, CAFile: other.caFile, CertFile: p.clientCert, KeyFile: p.clientKey})
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer g.Close()
+        if err := searchOnce(g); err == nil {
+            t.Fatal("search succeeded against a server signed by another CA")
+        }
+    })
+}
+
+func TestServerTLS(t *testing.T) {
+    p := newTestPKI(t)
+    addr := startTLSServer(t, p, p.serverCert, p.serverKey, tls.NoClientCert)
+
+    cases := []struct {
+        name    string
+        opts    TLSOptions
+        wantErr bool
+    }{
+        // a server that does not ask for a certificate only needs the CA
+        {"CA only", TLSOptions{Enabled: true, CAFile: p.caFile}, false},
+        {"client cert not requested", TLSOptions{Enabled: true, CAFile: p.caFile, CertFile: p.clientCert, KeyFile: p.clientKey}, false},
+        {"system roots", TLSOptions{Enabled: true}, true},
+        {"insecureSkipVerify without CA", TLSOptions{Enabled: true, InsecureSkipVerify: true}, false},
+        {"insecureSkipVerify with another CA", TLSOptions{Enabled: true, CAFile: newTestPKI(t).caFile, InsecureSkipVerify: true}, false},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            g, err := NewGateway(addr, tc.opts)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer g.Close()
+            if err := searchOnce(g); (err != nil) != tc.wantErr {
+                t.Fatalf("search error = %v, wantErr %v", err, tc.wantErr)
+            }
+        })
+    }
+}
+
+func TestServerNameOverride(t *testing.T) {
+    p := newTestPKI(t)
+    // the certificate names pcas.internal, not the 127.0.0.1 we dial
+    addr := startTLSServer(t, p, p.namedCert, p.namedKey, tls.NoClientCert)
+
+    cases := []struct {
+        name       string
+        serverName string
+        wantErr    bool
+    }{
+        {"dialed address", "", true},
+        {"certificate name", "pcas.internal", false},
+        {"other name", "other.internal", true},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            for _, kind := range []string{"gateway", "pool"} {
+                opts := TLSOptions{Enabled: true, CAFile: p.caFile, ServerName: tc.serverName}
+                var g *Gateway
+                if kind == "pool" {
+                    pool, err := NewPool(addr, PoolOptions{TLS: opts})
+                    if err != nil {
+                        t.Fatal(err)
+                    }
+                    defer pool.Close()
+                    g = pool.Gateway()
+                } else {
+                    var err error
+                    if g, err = NewGateway(addr, opts); err != nil {
+                        t.Fatal(err)
+                    }
+                    defer g.Close()
+                }
+                if err := searchOnce(g); (err != nil) != tc.wantErr {
+                    t.Fatalf("%s: search error = %v, wantErr %v", kind, err, tc.wantErr)
+                }
+            }
+        })
+    }
+}
+
+func TestTransportCredentialsOptions(t *testing.T) {
+    p := newTestPKI(t)
+    cases := []struct {
+        name    string
+        opts    TLSOptions
+        wantErr bool
+    }{
+        {"plaintext", TLSOptions{}, false},
+        {"disabled with cert", TLSOptions{CertFile: p.clientCert, KeyFile: p.clientKey}, true},
+        {"disabled with CA", TLSOptions{CAFile: p.caFile}, true},
+        {"disabled with insecureSkipVerify", TLSOptions{InsecureSkipVerify: true}, true},
+        {"cert without key", TLSOptions{Enabled: true, CertFile: p.clientCert}, true},
+        {"missing CA file", TLSOptions{Enabled: true, CAFile: filepath.Join(t.TempDir(), "none.pem")}, true},
+        {"CA without certificates", TLSOptions{Enabled: true, CAFile: p.clientKey}, true},
+        {"mutual", TLSOptions{Enabled: true, CAFile: p.caFile, CertFile: p.clientCert, KeyFile: p.clientKey}, false},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            _, err := transportCredentials(tc.opts)
+            if (err != nil) != tc.wantErr {
+                t.Fatalf("transportCredentials(%+v) error = %v, wantErr %v", tc.opts, err, tc.wantErr)
+            }
+        })
+    }
+    if _, err := NewPool("127.0.0.1:1", PoolOptions{TLS: TLSOptions{CertFile: p.clientCert, KeyFile: p.clientKey}}); err == nil {
+        t.Fatal("NewPool accepted a client certificate with TLS disabled")
+    }
+}
@@ -0,0 +1,129 @@
+package pcas
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "github.com/pcas/dreams-cli/backend/internal/distiller"
+)
+
+// Transcript event types emitted by ProcessStream.
+const (
+    EventPartial         = "partial"
+    EventFinal           = "final"
+    EventMemoryPublished = "memory_published"
+    EventStatus          = "status"
+    EventError           = "error"
+    EventMark            = "mark"
+)
+
+// Control types accepted on StreamOptions.Controls.
+const (
+    ControlFlush = "flush"
+    ControlMark  = "mark"
+)
+
+// StreamControl is a client request applied to a running stream.
+type StreamControl struct {
+    Type  string
+    Label string
+}
+
+// MarkInfo is a bookmark inserted into the transcript at the current position.
+type MarkInfo struct {
+    Label string `json:"label,omitempty"`
+    // Offset is the rune offset in the session transcript the mark points at.
+    Offset int `json:"offset"`
+    // SegmentIndex is the index of the segment the mark falls into.
+    SegmentIndex int   `json:"segmentIndex"`
+    At           int64 `json:"at"`
+}
+
+// Error codes carried by EventError.
+const (
+    CodePCASUnavailable = "pcas_unavailable"
+    CodePCASError       = "pcas_error"
+    CodeReconnectFailed = "reconnect_failed"
+    CodeStreamFailed    = "stream_failed"
+    CodeCanceled        = "canceled"
+)
+
+// ErrorCode classifies an error returned by a gateway stream method.
+func ErrorCode(err error) string {
+    switch {
+    case errors.Is(err, errStreamSetup):
+        return CodePCASUnavailable
+    case errors.Is(err, errStreamTerminal):
+        return CodePCASError
+    case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+        return CodeCanceled
+    }
+    return CodeStreamFailed
+}
+
+// TranscriptEvent is one item ProcessStream emits towards the client. Which
+// fields are set depends on Type.
+type TranscriptEvent struct {
+    Type string
+    // Raw is the ASR chunk exactly as PCAS sent it (partial).
+    Raw []byte
+    // Segment is the distilled sentence (final, memory_published).
+    Segment *SegmentInfo
+    // EventID is the PCAS event id of a published memory (memory_published).
+    EventID string
+    Status  *StreamStatus
+    Mark    *MarkInfo
+    Code    string
+    Message string
+}
+
+// SegmentInfo identifies a distilled segment within the session transcript.
+// Start/End are rune offsets over all text received in this session.
+type SegmentInfo struct {
+    ID     string `json:"segmentId"`
+    Index  int    `json:"index"`
+    Start  int    `json:"start"`
+    End    int    `json:"end"`
+    Text   string `json:"text"`
+    Reason string `json:"reason"`
+}
+
+// StreamStatus reports stream lifecycle changes, e.g. while recovering from a
+// PCAS outage.
+type StreamStatus struct {
+    Status    string `json:"status"`
+    Attempt   int    `json:"attempt,omitempty"`
+    RetryInMs int64  `json:"retryInMs,omitempty"`
+    StreamID  string `json:"streamId,omitempty"`
+    SessionID string `json:"sessionId,omitempty"`
+    Dropped   int    `json:"droppedChunks,omitempty"`
+    Reason    string `json:"reason,omitempty"`
+}
+
+func segmentInfo(seg distiller.Segment) *SegmentInfo {
+    return &SegmentInfo{
+        ID:     fmt.Sprintf("seg-%d", seg.Index),
+        Index:  seg.Index,
+        Start:  seg.Start,
+        End:    seg.End,
+        Text:   seg.Text,
+        Reason: seg.Reason,
+    }
+}
+
+func statusEvent(s StreamStatus) TranscriptEvent {
+    return TranscriptEvent{Type: EventStatus, Status: &s}
+}
+
+func errorEvent(code, msg string) TranscriptEvent {
+    return TranscriptEvent{Type: EventError, Code: code, Message: msg}
+}
+
+// emit delivers an event unless the session is already shutting down.
+func emit(ctx context.Context, out chan<- TranscriptEvent, ev TranscriptEvent) {
+    select {
+    case out <- ev:
+    case <-ctx.Done():
+    }
+}
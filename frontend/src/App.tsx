@@ -7,6 +7,7 @@ import {
 import { useBackendWebSocket } from './hooks/useBackendWebSocket';
 import { useSmartScroll } from './hooks/useSmartScroll';
 import { saveSession, loadSession, clearSession } from './db';
+import { lectureSessionId } from './utils/session';
 import { throttle } from 'lodash';
 
 import './App.css';
@@ -51,11 +52,13 @@ function TranscriptionApp() {
   const audioStreamRef = useRef<MediaStream | null>(null);
 
   const SESSION_ID = 'current_session';
+  // Backend session of this lecture, shared by the transcription socket and chat
+  const [lectureId] = useState(lectureSessionId);
   const linesRef = useRef<TranscriptLine[]>([]);
 
   // WebSocket + audio hooks
   const { startRecording, stopRecording } = usePCMAudioRecorderContext();
-  const { connect, sendBinary, disconnect, status: wsStatus, onMessage, waitForConnection } = useBackendWebSocket();
+  const { connect, sendBinary, disconnect, status: wsStatus, onMessage, waitForConnection } = useBackendWebSocket(lectureId);
 
   // Throttled session save
   const throttledSave = useMemo(
@@ -305,7 +308,7 @@ function TranscriptionApp() {
         <TranslationPane lines={lines} targetLang="en" />
         <div className="right-stack">
           <SummaryPanel lines={lines} />
-          <ChatPanel />
+          <ChatPanel sessionId={lectureId} />
           <div className="controls" style={{ justifyContent: 'flex-end' }}>
             <button onClick={handleDownloadText} className="btn btn-secondary" disabled={lines.length === 0}>导出文本</button>
             <button onClick={handleDownloadAudio} className="btn btn-secondary" disabled={audioChunksRef.current.length === 0}>导出音频</button>
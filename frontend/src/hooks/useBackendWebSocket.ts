@@ -33,7 +33,9 @@ const getExplicitBase = (): string | undefined => {
 };
 const EXPLICIT_WS_BASE = getExplicitBase();
 
-export const useBackendWebSocket = (): UseBackendWebSocketReturn => {
+// sessionId, when given, is sent as ?sessionId= so the backend files the
+// transcript under it.
+export const useBackendWebSocket = (sessionId?: string): UseBackendWebSocketReturn => {
   const wsRef = useRef<WebSocket | null>(null);
   const statusRef = useRef<WebSocketStatus>('closed');
   const [status, setStatus] = useState<WebSocketStatus>('closed');
@@ -87,14 +89,15 @@ export const useBackendWebSocket = (): UseBackendWebSocketReturn => {
 
     try {
       // Build final WS URL
+      const query = sessionId ? `?sessionId=${encodeURIComponent(sessionId)}` : '';
       const wsUrl = (() => {
         if (EXPLICIT_WS_BASE) {
           const base = EXPLICIT_WS_BASE.replace(/\/$/, '');
-          return `${base}/ws/transcribe`;
+          return `${base}/ws/transcribe${query}`;
         }
         // Use same-origin by default. In dev, vite proxy should forward /ws to backend 8080 with ws upgrade.
         const scheme = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        return `${scheme}//${window.location.host}/ws/transcribe`;
+        return `${scheme}//${window.location.host}/ws/transcribe${query}`;
       })();
       console.log('[WS] connecting to', wsUrl);
       
@@ -143,7 +146,7 @@ export const useBackendWebSocket = (): UseBackendWebSocketReturn => {
       statusRef.current = 'error';
       setStatus('error');
     }
-  }, [reconnect]);
+  }, [reconnect, sessionId]);
 
   // Store the connect function in the ref
   connectRef.current = connect;
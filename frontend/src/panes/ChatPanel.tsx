@@ -3,44 +3,21 @@ import { streamSSE } from '../utils/sse';
 
 type Msg = { id: string; role: 'user' | 'ai'; content: string; typing?: boolean };
 
-export function ChatPanel() {
+export function ChatPanel({ sessionId }: { sessionId: string }) {
   const [messages, setMessages] = useState<Msg[]>([
     { id: 'ai-hello', role: 'ai', content: '你好，我是你的课堂助手。' },
   ]);
   const [input, setInput] = useState('');
   const [sending, setSending] = useState(false);
   const inputRef = useRef<HTMLInputElement>(null);
+  // Without an auth token the backend files this tab's turns under a key it
+  // issues with the first answer; sending it back keeps the conversation
+  const historyKey = useRef('');
 
   const send = async () => {
     const text = input.trim();
     if (!text || sending) return;
 
-    // Build a lightweight rolling context from recent turns with hard caps
-    const MAX_TURNS = 6;         // limit number of recent messages
-    const MAX_CONTEXT_CHARS = 2000; // limit total characters
-    const turns = messages.filter((m) => m.role === 'user' || m.role === 'ai');
-    const recentTurns = turns.slice(-MAX_TURNS);
-    // Enforce char budget from the end backward, then restore order
-    let budget = MAX_CONTEXT_CHARS;
-    const picked: string[] = [];
-    for (let i = recentTurns.length - 1; i >= 0; i--) {
-      const t = `${recentTurns[i].role === 'user' ? 'User' : 'Assistant'}: ${recentTurns[i].content}`;
-      if (t.length <= budget) {
-        picked.push(t);
-        budget -= t.length + 1; // + newline
-      } else {
-        // take tail part to fit budget, if any
-        if (budget > 20) { // avoid adding extremely short fragments
-          picked.push(t.slice(t.length - budget));
-          budget = 0;
-        }
-        break;
-      }
-      if (budget <= 0) break;
-    }
-    picked.reverse();
-    const contextText = picked.join('\n');
-
     const user: Msg = { id: `u-${Date.now()}`, role: 'user', content: text };
     const ai: Msg = { id: `a-${Date.now()}`, role: 'ai', content: '', typing: true };
     setMessages((m) => [...m, user, ai]);
@@ -52,18 +29,15 @@ export function ChatPanel() {
         '/api/chat',
         {
           method: 'POST',
-          headers: { 'Content-Type': 'application/json' },
+          headers: {
+            'Content-Type': 'application/json',
+            ...(historyKey.current ? { 'X-History-Key': historyKey.current } : {}),
+          },
           body: JSON.stringify({
-            sessionId: 'current_session',
-            // Preface prior turns as plain-text context before the new input
-            message: contextText ? `Context\n${contextText}\n\nUser: ${text}` : text,
-            attrs: {
-              model: 'gpt-5',
-              system:
-                'You are a helpful assistant. Use the provided Context (if any) to answer concisely.',
-              // Also provide context via attrs for providers that read attributes
-              context: contextText || '',
-            },
+            // The backend keeps this lecture's earlier turns and sends them with the message
+            sessionId,
+            message: text,
+            attrs: { model: 'gpt-5' },
           }),
         },
         (t) => {
@@ -72,6 +46,11 @@ export function ChatPanel() {
             m.map((msg) => (msg.id === ai.id ? { ...msg, typing: false, content: msg.content + t } : msg)),
           );
         },
+        undefined,
+        (resp) => {
+          const key = resp.headers.get('X-History-Key');
+          if (key) historyKey.current = key;
+        },
       );
     } catch (e) {
       // eslint-disable-next-line no-console
@@ -0,0 +1,24 @@
+const LECTURE_KEY = 'dreamscribe.lectureId';
+
+// lectureSessionId identifies the lecture recorded in this tab. The
+// transcription socket and chat both send it as sessionId, so chat history
+// and refs stay with the transcript they belong to; it survives reloads of
+// the tab but not a new tab.
+export function lectureSessionId(): string {
+  try {
+    const saved = sessionStorage.getItem(LECTURE_KEY);
+    if (saved) return saved;
+  } catch {
+    // storage can be unavailable (e.g. privacy mode); fall through
+  }
+  const id =
+    typeof crypto !== 'undefined' && 'randomUUID' in crypto
+      ? crypto.randomUUID()
+      : `lecture-${Date.now().toString(36)}-${Math.random().toString(36).slice(2, 10)}`;
+  try {
+    sessionStorage.setItem(LECTURE_KEY, id);
+  } catch {
+    // keep the id for this page only
+  }
+  return id;
+}
@@ -1,12 +1,16 @@
 export type SSEOnText = (text: string) => void;
 
-// Stream an SSE response and invoke onText for each {text:"..."} payload.
-// Expects server to emit lines like: "data: {\"text\":\"...\"}\n\n".
+// Stream an SSE response and invoke onText for each delta payload.
+// Expects frames like: "event: delta\ndata: {\"text\":\"...\"}\n\n". An "error"
+// event rejects with its message; "done" ends the stream. Frames without an
+// event name are treated as deltas (older backends). onResponse sees the
+// response (e.g. its headers) before the body is read.
 export async function streamSSE(
   input: RequestInfo,
   init: RequestInit | undefined,
   onText: SSEOnText,
   signal?: AbortSignal,
+  onResponse?: (resp: Response) => void,
 ): Promise<void> {
   const controller = new AbortController();
   const linked = signal ? linkAbortSignals(signal, controller) : undefined;
@@ -16,6 +20,7 @@ export async function streamSSE(
       const t = await resp.text().catch(() => '');
       throw new Error(t || `${resp.status} ${resp.statusText}`);
     }
+    onResponse?.(resp);
     const reader = resp.body.getReader();
     const dec = new TextDecoder();
     let buf = '';
@@ -27,18 +32,25 @@ export async function streamSSE(
       while ((idx = buf.indexOf('\n\n')) >= 0) {
         const frame = buf.slice(0, idx);
         buf = buf.slice(idx + 2);
-        const lines = frame.split('\n');
-        for (const line of lines) {
-          if (line.startsWith('data: ')) {
-            const json = line.slice(6);
-            try {
-              const obj = JSON.parse(json);
-              if (obj && typeof obj.text === 'string') onText(obj.text);
-            } catch {
-              // ignore parse errors for non-JSON or partial frames
-            }
-          }
+        let event = 'delta';
+        let data = '';
+        for (const line of frame.split('\n')) {
+          if (line.startsWith('event: ')) event = line.slice(7);
+          else if (line.startsWith('data: ')) data = line.slice(6);
         }
+        if (!data) continue;
+        let obj: { text?: unknown; code?: string; message?: string } | null;
+        try {
+          obj = JSON.parse(data);
+        } catch {
+          // ignore parse errors for non-JSON or partial frames
+          continue;
+        }
+        if (event === 'error') {
+          throw new Error(obj?.message ? `${obj.code || 'error'}: ${obj.message}` : data);
+        }
+        if (event === 'done') return;
+        if (event === 'delta' && obj && typeof obj.text === 'string') onText(obj.text);
       }
     }
   } finally {